@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// openJSONLines opens all tunnels described in r via the daemon, see
+// config.OpenJSONLines
+func openJSONLines(r io.Reader) (map[string]config.Opened, []error) {
+	conf, err := prepare()
+	if err != nil {
+		log.Fatalf("Startup: %s", err.Error())
+	}
+	return conf.OpenJSONLines(r, func(t *tunnel.Desc) (*tunnel.Tunnel, error) {
+		return nil, openTunnel(t)
+	})
+}
+
+// openFromStdin opens the tunnels given as JSON lines on stdin
+func openFromStdin() {
+	res, errs := openJSONLines(os.Stdin)
+	for _, err := range errs {
+		log.Errorf("Invalid tunnel definition: %v", err)
+	}
+	failed := len(errs)
+	for _, o := range res {
+		if o.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Fatalf("Could not open %d of %d tunnels.", failed, len(res)+len(errs))
+	}
+}
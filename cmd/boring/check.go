@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/table"
+	"github.com/alebeck/boring/internal/tunnel"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	checkTimeout = 1 * time.Minute
+	// Limits the number of concurrent SSH handshakes
+	checkConcurrency = 8
+)
+
+// checkTunnels connects to and authenticates against the servers of the
+// selected tunnels, reporting which ones work. Nothing is forwarded, and
+// the daemon is not involved.
+func checkTunnels(args []string) {
+	conf, err := config.Load()
+	if err != nil {
+		log.Fatalf("Could not load boring config: %v", err)
+	}
+
+	keep := selectForCheck(conf.TunnelsMap, args)
+	if len(keep) == 0 {
+		log.Fatalf("No tunnels to check.")
+	}
+
+	// Check in config order, so results can be displayed that way
+	var descs []tunnel.Desc
+	for _, t := range conf.Tunnels {
+		if keep[t.Name] {
+			descs = append(descs, t)
+		}
+	}
+	errs := runChecks(descs)
+	if failed := reportChecks(descs, errs); failed > 0 {
+		log.Fatalf("%d of %d tunnels failed.", failed, len(descs))
+	}
+}
+
+// selectForCheck returns the names of the tunnels selected by args, all
+// of them if there are none
+func selectForCheck(ts map[string]*tunnel.Desc, args []string) map[string]bool {
+	switch {
+	case len(args) == 0 || args[0] == "--all" || args[0] == "-a":
+		keep := make(map[string]bool, len(ts))
+		for n := range ts {
+			keep[n] = true
+		}
+		return keep
+	case args[0] == "-g" || args[0] == "--group":
+		if len(args) != 2 {
+			log.Fatalf("'-g/--group' requires exactly one group name argument.")
+		}
+		return filterByGroup(ts, groupName(args[1]))
+	}
+	keep, notMatched := filterByPatterns(ts, args)
+	for _, pat := range notMatched {
+		log.Warningf("No tunnels match pattern '%s'.", pat)
+	}
+	return keep
+}
+
+// runChecks checks descs concurrently, returning their results in order
+func runChecks(descs []tunnel.Desc) []error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	errs := make([]error, len(descs))
+	var g errgroup.Group
+	g.SetLimit(checkConcurrency)
+	for i := range descs {
+		g.Go(func() error {
+			errs[i] = tunnel.FromDesc(&descs[i]).Check(ctx)
+			return nil
+		})
+	}
+	g.Wait()
+	return errs
+}
+
+// reportChecks prints the results of checking descs and returns the number
+// of failed checks
+func reportChecks(descs []tunnel.Desc, errs []error) int {
+	tbl := table.New("Result", "Name", "Category", "Error")
+	failed := 0
+	for i, err := range errs {
+		if err == nil {
+			tbl.AddRow(log.Green+"ok"+log.Reset, descs[i].Name, "", "")
+			continue
+		}
+		failed++
+		cat := tunnel.CategoryOther
+		var ce *tunnel.CheckError
+		if errors.As(err, &ce) {
+			cat = ce.Category
+		}
+		tbl.AddRow(log.Red+"failed"+log.Reset, descs[i].Name, cat, err)
+	}
+	log.Emitf("%v", tbl)
+	return failed
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/alebeck/boring/internal/daemon"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/table"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// showConns lists the connections a running tunnel currently forwards
+func showConns(args []string) {
+	asJSON := false
+	if len(args) == 2 && args[1] == "--json" {
+		asJSON, args = true, args[:1]
+	}
+	if len(args) != 1 {
+		log.Fatalf("'connections' requires exactly one tunnel name argument," +
+			" optionally followed by '--json'.")
+	}
+	name := args[0]
+
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Conns, Tunnel: &tunnel.Desc{Name: name}})
+	if err != nil {
+		log.Fatalf("Could not transmit 'connections' command: %v", err)
+	}
+	if !resp.Success {
+		log.Fatalf("Could not list connections of '%v': %v", name, resp.Error)
+	}
+
+	if asJSON {
+		conns := resp.Conns
+		if conns == nil {
+			conns = []tunnel.Conn{}
+		}
+		b, _ := json.Marshal(conns)
+		log.Emitf("%s\n", b)
+		return
+	}
+	if len(resp.Conns) == 0 {
+		log.Infof("No active connections.")
+		return
+	}
+	log.Emitf("%v", connTable(resp.Conns, time.Now()))
+}
+
+func connTable(conns []tunnel.Conn, now time.Time) *table.Table {
+	tbl := table.New("Peer", "Target", "Age", "In", "Out")
+	for _, c := range conns {
+		age := now.Sub(c.Started).Truncate(time.Second)
+		tbl.AddRow(c.Peer, c.Target, age, formatBytes(c.BytesIn), formatBytes(c.BytesOut))
+	}
+	return tbl
+}
+
+// formatBytes formats n with a binary unit, e.g., "1.5 MiB"
+func formatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+func TestFormatBytes(t *testing.T) {
+	cases := map[uint64]string{
+		0:           "0 B",
+		1023:        "1023 B",
+		1536:        "1.5 KiB",
+		5 << 20:     "5.0 MiB",
+		3 << 30 / 2: "1.5 GiB",
+	}
+	for n, want := range cases {
+		if got := formatBytes(n); got != want {
+			t.Errorf("%d: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestConnTable(t *testing.T) {
+	log.Init(io.Discard, false, false)
+	now := time.Now()
+	conns := []tunnel.Conn{{Peer: "127.0.0.1:50000", Target: "db:5432",
+		Started: now.Add(-90 * time.Second), BytesIn: 2048, BytesOut: 10}}
+	out := connTable(conns, now).String()
+	for _, s := range []string{"127.0.0.1:50000", "db:5432", "1m30s", "2.0 KiB", "10 B"} {
+		if !strings.Contains(out, s) {
+			t.Errorf("expected %q in table: %q", s, out)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alebeck/boring/internal/daemon"
+	"github.com/alebeck/boring/internal/log"
+)
+
+// showHealth reports on the daemon as a whole. It does not spawn the daemon,
+// and exits with a non-zero code if it is not running or tunnels failed.
+func showHealth(args []string) {
+	asJSON := false
+	if len(args) == 1 && args[0] == "--json" {
+		asJSON = true
+	} else if len(args) > 0 {
+		log.Fatalf("Unknown arguments for 'health'. Use '--json' for JSON output.")
+	}
+
+	resp, err := sendCmd(daemon.Cmd{Kind: daemon.Health})
+	if err != nil {
+		log.Fatalf("Daemon not running: %v", err)
+	}
+	if !resp.Success || resp.Health == nil {
+		log.Fatalf("Could not get daemon health: %s", resp.Error)
+	}
+	h := resp.Health
+
+	if asJSON {
+		b, _ := json.Marshal(h)
+		log.Emitf("%s\n", b)
+	} else {
+		log.Emitf("%s", healthText(h, resp.Info.Commit))
+	}
+	if h.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func healthText(h *daemon.HealthInfo, commit string) string {
+	v := h.Version
+	if v == "" {
+		v = "snapshot"
+	}
+	if commit != "" {
+		v += fmt.Sprintf(" (#%s)", commit)
+	}
+	configured := fmt.Sprint(h.Configured)
+	if h.Configured < 0 {
+		configured = "unknown"
+	}
+	return fmt.Sprintf("Version:    %s\n", v) +
+		fmt.Sprintf("Uptime:     %s\n", h.Uptime.Truncate(time.Second)) +
+		fmt.Sprintf("Configured: %s\n", configured) +
+		fmt.Sprintf("Open:       %d\n", h.Open) +
+		fmt.Sprintf("Reconn:     %d\n", h.Reconn) +
+		fmt.Sprintf("Paused:     %d\n", h.Paused) +
+		fmt.Sprintf("Failed:     %d\n", h.Failed)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"github.com/alebeck/boring/internal/table"
+)
+
+// showKnownHost reports whether the host of a tunnel, or a host alias, is
+// trusted by known_hosts and with which keys. Hashed entries are matched
+// like plain ones. Exits with 1 if the host is not trusted.
+func showKnownHost(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("'known-host' requires exactly one tunnel name or host argument.")
+	}
+
+	host, user, port, _, file := resolveDestination(args[0])
+	sc, err := ssh_config.ParseSSHConfigFile(host, user, file)
+	if err != nil {
+		log.Fatalf("Could not parse SSH config: %v", err)
+	}
+	// Like tunnels, take the host literally if not resolved
+	if sc.HostName == "" {
+		sc.HostName = host
+	}
+	if port != 0 {
+		sc.Port = port
+	}
+	name, keys, err := sc.KnownHostKeys()
+	if err != nil {
+		log.Fatalf("Could not read known hosts: %v", err)
+	}
+	if len(keys) == 0 {
+		log.Errorf("'%v' is not in known hosts, tried %v.", name,
+			strings.Join(sc.KnownHostsFiles, ", "))
+		os.Exit(1)
+	}
+
+	log.Infof("'%v' is trusted with:", name)
+	tbl := table.New("Type", "Fingerprint", "Entry")
+	for _, k := range keys {
+		typ := k.Type
+		if k.Authority {
+			typ += " (CA)"
+		}
+		tbl.AddRow(typ, k.Fingerprint, fmt.Sprintf("%v:%d", k.File, k.Line))
+	}
+	log.Emitf("%v", tbl)
+}
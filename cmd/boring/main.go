@@ -35,32 +35,82 @@ func main() {
 		os.Exit(1)
 	}
 
-	switch os.Args[1] {
-	case "open", "o":
-		if len(os.Args) < 3 {
-			log.Fatalf("'open' requires at least one 'pattern' argument," +
-				" or an '--all/-a' or '-g/--group <group>' flag.")
-		}
-		controlTunnels(os.Args[2:], daemon.Open)
-	case "close", "c":
-		if len(os.Args) < 3 {
-			log.Fatalf("'close' requires at least one 'pattern' argument," +
-				" or an '--all/-a' or '-g/--group <group>' flag.")
-		}
-		controlTunnels(os.Args[2:], daemon.Close)
-	case "list", "l", "ls":
-		listTunnels(os.Args[2:])
-	case "edit", "e":
-		editConfig()
-	case "version", "v":
-		printVersion()
-	case "help", "h":
-		printUsage()
-	default:
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
 		log.Printf("Unknown command: %v\n\n", os.Args[1])
 		printUsage()
 		os.Exit(1)
 	}
+	cmd(os.Args[2:])
+}
+
+// commands maps command names and their aliases to handlers, which are
+// passed the arguments following the command
+var commands = map[string]func(args []string){
+	"open":        openCmd,
+	"o":           openCmd,
+	"close":       closeCmd,
+	"c":           closeCmd,
+	"pause":       pauseCmd,
+	"resume":      resumeCmd,
+	"list":        listTunnels,
+	"l":           listTunnels,
+	"ls":          listTunnels,
+	"check":       checkTunnels,
+	"health":      showHealth,
+	"connections": showConns,
+	"conns":       showConns,
+	"config":      showSSHConfig,
+	"known-host":  showKnownHost,
+	"edit":        noArgs(editConfig),
+	"e":           noArgs(editConfig),
+	"version":     noArgs(printVersion),
+	"v":           noArgs(printVersion),
+	"help":        noArgs(printUsage),
+	"h":           noArgs(printUsage),
+}
+
+func noArgs(f func()) func([]string) {
+	return func([]string) { f() }
+}
+
+// requirePatterns exits unless args select tunnels to apply cmd to
+func requirePatterns(cmd string, args []string) {
+	if len(args) < 1 {
+		log.Fatalf("'%s' requires at least one 'pattern' argument,"+
+			" or an '--all/-a' or '-g/--group <group>' flag.", cmd)
+	}
+}
+
+func openCmd(args []string) {
+	requirePatterns("open", args)
+	if args[0] == "--json" {
+		if len(args) != 1 {
+			log.Fatalf("'--json' does not take any additional arguments.")
+		}
+		openFromStdin()
+		return
+	}
+	controlTunnels(args, daemon.Open, false)
+}
+
+func closeCmd(args []string) {
+	requirePatterns("close", args)
+	controlTunnels(args, daemon.Close, false)
+}
+
+func pauseCmd(args []string) {
+	drop := false
+	if len(args) > 0 && args[0] == "--drop" {
+		args, drop = args[1:], true
+	}
+	requirePatterns("pause", args)
+	controlTunnels(args, daemon.Pause, drop)
+}
+
+func resumeCmd(args []string) {
+	requirePatterns("resume", args)
+	controlTunnels(args, daemon.Resume, false)
 }
 
 func handleCompletions() {
@@ -96,12 +146,35 @@ func printVersion() {
 func printUsage() {
 	log.Printf("The `boring` SSH tunnel manager\n\n")
 	log.Printf("Usage:\n")
-	log.Printf("  boring list, l [-g <group>]    List all tunnels\n")
-	log.Printf(`  boring open, o (-a | -g <group> | <patterns>...)
+	log.Printf("  boring list, l [-g <group> | -t <tag>]\n" +
+		"                                 List all tunnels, and the SSH server version of\n" +
+		"                                 open ones\n")
+	log.Printf(`  boring open, o (-a | -g <group> | -t <tag> | <patterns>...)
     <patterns>...                Open tunnels matching any glob pattern
     -a, --all                    Open all tunnels
-    -g, --group <group>          Open all tunnels in a group` + "\n")
+    -g, --group <group>          Open all tunnels in a group
+    -t, --tag <tag>              Open all tunnels with a tag in 'tags', or whose
+                                 host has it as Tag in the SSH config
+    --json                       Open tunnels read from stdin, given as one
+                                 JSON object per line` + "\n")
 	log.Printf("  boring close, c                Close tunnels (same options as 'open')\n")
+	log.Printf("  boring pause [--drop]          Stop forwarding new connections, keeping the SSH\n" +
+		"                                 connection (same options as 'open'). --drop also\n" +
+		"                                 closes established connections\n")
+	log.Printf("  boring resume                  Resume paused tunnels (same options as 'open')\n")
+	log.Printf("  boring check [-a | -g <group> | <patterns>...]\n" +
+		"                                 Test connecting and authenticating, without\n" +
+		"                                 forwarding. Checks all tunnels by default\n")
+	log.Printf("  boring health [--json]         Show daemon health and tunnel counts\n")
+	log.Printf("  boring connections, conns <name> [--json]\n" +
+		"                                 List the connections a tunnel forwards, with\n" +
+		"                                 their age and bytes transferred\n")
+	log.Printf("  boring config <name | host>    Show the SSH config applying to a tunnel or host,\n" +
+		"                                 and where each value comes from, including the\n" +
+		"                                 effective user, port and keys\n")
+	log.Printf("  boring known-host <name | host>\n" +
+		"                                 Show whether a tunnel's or host's key is trusted\n" +
+		"                                 by known_hosts, hashed entries included\n")
 	log.Printf("  boring edit, e                 Edit the configuration file\n")
 	log.Printf("  boring version, v              Show the version number\n")
 	log.Printf("  boring help, h                 Show this help message\n")
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"github.com/alebeck/boring/internal/table"
+)
+
+// showSSHConfig prints what the ssh config resolves to for a tunnel's host
+// or a host alias, and where each value comes from. Nothing is connected.
+// The values used for connecting follow, with tunnel settings applied.
+func showSSHConfig(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("'config' requires exactly one tunnel name or host argument.")
+	}
+
+	host, user, _, overrides, file := resolveDestination(args[0])
+	res, err := ssh_config.Resolve(host, user, file)
+	if err != nil {
+		log.Fatalf("Could not resolve SSH config: %v", err)
+	}
+	tbl := table.New("Directive", "Value", "Source")
+	for _, d := range res.Directives {
+		tbl.AddRow(d.Key, strings.Join(d.Values, " "), d.Source)
+	}
+	log.Emitf("%v\n", tbl)
+
+	tbl = table.New("Effective", "Value", "Source")
+	for _, s := range res.Effective(overrides...) {
+		tbl.AddRow(s.Key, s.Value, s.Source)
+	}
+	log.Emitf("%v", tbl)
+}
+
+// resolveDestination returns the host alias, user and port, if any, for a
+// tunnel name or a destination like "user@host:port". overrides are the
+// values given by the tunnel or destination, which take precedence over
+// the ssh config. file is the tunnel's own ssh config file, if any.
+func resolveDestination(arg string) (host, user string, port int, overrides []ssh_config.Override, file string) {
+	dest, tPort := arg, ""
+	if conf, err := config.Load(); err == nil {
+		ssh_config.SetDefaultIdentityFiles(conf.DefaultIdentityFiles)
+		ssh_config.SetNewHostsFile(conf.KnownHostsFile)
+		if t, ok := conf.TunnelsMap[arg]; ok {
+			dest, user, tPort, file = t.Host.First(), t.User, t.Port.String(), t.SSHConfigFile
+			overrides = append(overrides,
+				ssh_config.Override{Key: "User", Value: t.User, Source: "tunnel"},
+				ssh_config.Override{Key: "Port", Value: tPort, Source: "tunnel"},
+				ssh_config.Override{Key: "IdentityFile", Value: t.IdentityFile, Source: "tunnel"})
+		}
+	}
+	host, u, port, err := ssh_config.ParseDestination(dest)
+	if err != nil {
+		log.Fatalf("Invalid host %q: %v", dest, err)
+	}
+	if u != "" {
+		user = u
+		overrides = append(overrides, ssh_config.Override{Key: "User", Value: u, Source: "host"})
+	}
+	if port != 0 {
+		overrides = append(overrides,
+			ssh_config.Override{Key: "Port", Value: strconv.Itoa(port), Source: "host"})
+	} else if tPort != "" {
+		if port, err = strconv.Atoi(tPort); err != nil {
+			log.Fatalf("Invalid port %q", tPort)
+		}
+	}
+	return
+}
@@ -11,9 +11,14 @@ import (
 func status(t *tunnel.Desc) string {
 	switch t.Status {
 	case tunnel.Closed:
+		if s, err := tunnel.ParseSchedule(t.Schedule); err == nil && s != nil && !s.Active(time.Now()) {
+			return log.Yellow + "scheduled-down" + log.Reset
+		}
 		return log.Red + "closed" + log.Reset
 	case tunnel.Reconn:
 		return log.Yellow + "reconn" + log.Reset
+	case tunnel.Paused:
+		return log.Yellow + "paused" + log.Reset
 	}
 
 	// Tunnel is open, show uptime
@@ -7,6 +7,7 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"time"
@@ -14,6 +15,7 @@ import (
 	"github.com/alebeck/boring/internal/config"
 	"github.com/alebeck/boring/internal/daemon"
 	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
 	"github.com/alebeck/boring/internal/table"
 	"github.com/alebeck/boring/internal/tunnel"
 	"golang.org/x/sync/errgroup"
@@ -60,22 +62,13 @@ func prepare() (*config.Config, error) {
 	return conf, nil
 }
 
-// flow is very linear and I don't mind it at the moment
-//
-//gocyclo:ignore
-func controlTunnels(args []string, kind daemon.CmdKind) {
-	var groupFilter string
-
-	if args[0] == "--all" || args[0] == "-a" {
+func controlTunnels(args []string, kind daemon.CmdKind, drop bool) {
+	group, tag, filtered := parseFilter(args)
+	if !filtered && (args[0] == "--all" || args[0] == "-a") {
 		if len(args) != 1 {
 			log.Fatalf("'--all' does not take any additional arguments.")
 		}
 		args = []string{"*"}
-	} else if args[0] == "-g" || args[0] == "--group" {
-		if len(args) != 2 {
-			log.Fatalf("'-g/--group' requires exactly one group name argument.")
-		}
-		groupFilter = args[1]
 	}
 
 	conf, err := prepare()
@@ -85,64 +78,130 @@ func controlTunnels(args []string, kind daemon.CmdKind) {
 
 	// Get available tunnels for requested command
 	ts := conf.TunnelsMap
-	if kind == daemon.Close {
+	var m string
+	if kind != daemon.Open {
 		ts, err = getRunningTunnels()
 		if err != nil {
 			log.Fatalf("Could not get running tunnels: %v", err)
 		}
-	}
-
-	var m string
-	if kind == daemon.Close {
 		m = "running "
 	}
 
-	var keep map[string]bool
+	keep := selectTunnels(ts, args, group, tag, m)
 
-	if groupFilter != "" {
-		filterValue := groupFilter
-		if groupFilter == "default" {
-			filterValue = ""
+	// Issue concurrent commands for all tunnels
+	var g errgroup.Group
+	if kind == daemon.Open && conf.OpenConcurrency > 0 {
+		g.SetLimit(conf.OpenConcurrency)
+	}
+	for _, n := range orderNames(keep, conf.Tunnels) {
+		g.Go(func() error { return controlTunnel(ts[n], kind, drop) })
+	}
+	// This is just for determining the exit code really,
+	// a detailed message will have been logged to the user.
+	if err := g.Wait(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// parseFilter parses a leading -g/--group or -t/--tag argument and reports
+// whether there is one
+func parseFilter(args []string) (group, tag string, ok bool) {
+	if len(args) == 0 {
+		return "", "", false
+	}
+	switch args[0] {
+	case "-g", "--group":
+		if len(args) != 2 {
+			log.Fatalf("'-g/--group' requires exactly one group name argument.")
 		}
-		keep = filterByGroup(ts, filterValue)
-		if len(keep) == 0 {
-			log.Fatalf("No %stunnels in group '%s'.", m, groupFilter)
+		return args[1], "", true
+	case "-t", "--tag":
+		if len(args) != 2 {
+			log.Fatalf("'-t/--tag' requires exactly one tag argument.")
 		}
-	} else {
-		var notMatched []string
-		keep, notMatched = filterByPatterns(ts, args)
+		return "", args[1], true
+	}
+	return "", "", false
+}
 
+// groupName returns the name of group in the config, where tunnels of the
+// default group have none
+func groupName(group string) string {
+	if group == "default" {
+		return ""
+	}
+	return group
+}
+
+// selectTunnels returns the names of the tunnels in ts in group, with tag,
+// or matching patterns, exiting if there are none. m qualifies the tunnels
+// in messages, e.g., as running.
+func selectTunnels(ts map[string]*tunnel.Desc, patterns []string, group, tag, m string) map[string]bool {
+	switch {
+	case group != "":
+		keep := filterByGroup(ts, groupName(group))
 		if len(keep) == 0 {
-			msg := fmt.Sprintf("No %stunnels match pattern '%s'.", m, args[0])
-			if len(args) > 1 {
-				msg = fmt.Sprintf("No %stunnels match any provided pattern.", m)
-			}
-			log.Fatalf("%s", msg)
+			log.Fatalf("No %stunnels in group '%s'.", m, group)
 		}
+		return keep
+	case tag != "":
+		keep := filterByTag(ts, tag)
+		if len(keep) == 0 {
+			log.Fatalf("No %stunnels with tag '%s'.", m, tag)
+		}
+		return keep
+	}
 
-		// If tunnels were matched, do print a warning for unmatched patterns
-		for _, pat := range notMatched {
-			log.Warningf("No %stunnels match pattern '%s'.", m, pat)
+	keep, notMatched := filterByPatterns(ts, patterns)
+	if len(keep) == 0 {
+		msg := fmt.Sprintf("No %stunnels match pattern '%s'.", m, patterns[0])
+		if len(patterns) > 1 {
+			msg = fmt.Sprintf("No %stunnels match any provided pattern.", m)
 		}
+		log.Fatalf("%s", msg)
+	}
+	// If tunnels were matched, do print a warning for unmatched patterns
+	for _, pat := range notMatched {
+		log.Warningf("No %stunnels match pattern '%s'.", m, pat)
 	}
+	return keep
+}
 
-	// Issue concurrent commands for all tunnels
-	var g errgroup.Group
-	for n := range keep {
-		g.Go(func() error {
-			if kind == daemon.Open {
-				return openTunnel(ts[n])
-			} else if kind == daemon.Close {
-				return closeTunnel(ts[n])
-			}
-			panic("unknown command kind: " + kind.String())
-		})
+// controlTunnel sends the command of kind for t to the daemon
+func controlTunnel(t *tunnel.Desc, kind daemon.CmdKind, drop bool) error {
+	switch kind {
+	case daemon.Open:
+		return openTunnel(t)
+	case daemon.Close:
+		return closeTunnel(t)
+	case daemon.Pause, daemon.Resume:
+		return pauseTunnel(t, kind, drop)
+	}
+	panic("unknown command kind: " + kind.String())
+}
+
+// orderNames returns the names in keep in the order of the config, followed
+// by the remaining ones, e.g. running tunnels no longer configured, sorted.
+// This is only the order in which commands are issued; without a limit on
+// concurrency, they may complete in any order.
+func orderNames(keep map[string]bool, conf []tunnel.Desc) []string {
+	names := make([]string, 0, len(keep))
+	seen := make(map[string]bool, len(keep))
+	for _, t := range conf {
+		if keep[t.Name] && !seen[t.Name] {
+			names = append(names, t.Name)
+			seen[t.Name] = true
+		}
 	}
-	// This is just for determining the exit code really,
-	// a detailed message will have been logged to the user.
-	if err := g.Wait(); err != nil {
-		os.Exit(1)
+	var rest []string
+	for n := range keep {
+		if !seen[n] {
+			rest = append(rest, n)
+		}
 	}
+	slices.Sort(rest)
+	return append(names, rest...)
 }
 
 func openTunnel(t *tunnel.Desc) error {
@@ -161,8 +220,12 @@ func openTunnel(t *tunnel.Desc) error {
 		return errOpFailed
 	}
 
+	if o, ok := resp.Tunnels[t.Name]; ok {
+		t.Bound = o.Bound
+	}
+	local, remote := boundAddrs(t)
 	log.Infof("Opened tunnel '%s': %s %v %s via %s.", log.Green+log.Bold+t.Name+log.Reset,
-		t.LocalAddress, t.Mode, t.RemoteAddress, t.Host)
+		local, t.Mode, remote, t.Host)
 	return nil
 }
 
@@ -182,6 +245,22 @@ func closeTunnel(t *tunnel.Desc) error {
 	return nil
 }
 
+func pauseTunnel(t *tunnel.Desc, kind daemon.CmdKind, drop bool) error {
+	verb := strings.ToLower(kind.String())
+	t = &tunnel.Desc{Name: t.Name}
+	resp, err := sendCmd(daemon.Cmd{Kind: kind, Tunnel: t, Drop: drop})
+	if err != nil {
+		log.Errorf("Could not transmit '%s' command: %v", verb, err)
+		return errOpFailed
+	}
+	if !resp.Success {
+		log.Errorf("Tunnel '%v' could not be %sd: %v", t.Name, verb, resp.Error)
+		return errOpFailed
+	}
+	log.Infof("%sd tunnel '%s'.", kind.String(), log.Green+log.Bold+t.Name+log.Reset)
+	return nil
+}
+
 func getRunningTunnels() (map[string]*tunnel.Desc, error) {
 	resp, err := sendCmd(daemon.Cmd{Kind: daemon.List})
 	if err != nil {
@@ -198,14 +277,10 @@ func getRunningTunnels() (map[string]*tunnel.Desc, error) {
 }
 
 func listTunnels(args []string) {
-	var groupFilter string
-	if len(args) > 0 && (args[0] == "-g" || args[0] == "--group") {
-		if len(args) != 2 {
-			log.Fatalf("'-g/--group' requires exactly one group name argument.")
-		}
-		groupFilter = args[1]
-	} else if len(args) > 0 {
-		log.Fatalf("Unknown arguments for 'list'. Use '-g <group>' to filter by group.")
+	group, tag, ok := parseFilter(args)
+	if !ok && len(args) > 0 {
+		log.Fatalf("Unknown arguments for 'list'. Use '-g <group>' to filter by group," +
+			" or '-t <tag>' by tag.")
 	}
 
 	conf, err := prepare()
@@ -224,26 +299,28 @@ func listTunnels(args []string) {
 	}
 
 	all := orderTunnelsForList(conf.Tunnels, ts)
+	printTunnelList(filterList(all, group, tag))
+}
 
-	// Filter by group if requested
-	if groupFilter != "" {
-		filterValue := groupFilter
-		if groupFilter == "default" {
-			filterValue = ""
-		}
-		var filtered []*tunnel.Desc
-		for _, t := range all {
-			if t.Group == filterValue {
-				filtered = append(filtered, t)
-			}
-		}
-		if len(filtered) == 0 {
-			log.Fatalf("No tunnels in group '%s'.", groupFilter)
+// filterList keeps the tunnels in group or with tag, if given, exiting if
+// there are none
+func filterList(all []*tunnel.Desc, group, tag string) []*tunnel.Desc {
+	if group == "" && tag == "" {
+		return all
+	}
+	var filtered []*tunnel.Desc
+	for _, t := range all {
+		if group != "" && t.Group == groupName(group) || tag != "" && hasTag(t, tag) {
+			filtered = append(filtered, t)
 		}
-		all = filtered
 	}
-
-	printTunnelList(all)
+	if len(filtered) == 0 && group != "" {
+		log.Fatalf("No tunnels in group '%s'.", group)
+	}
+	if len(filtered) == 0 {
+		log.Fatalf("No tunnels with tag '%s'.", tag)
+	}
+	return filtered
 }
 
 // orderTunnelsForList combines configured and running tunnels into an ordered slice.
@@ -316,13 +393,46 @@ func printTunnelList(all []*tunnel.Desc) {
 }
 
 func tunnelTable(tunnels []*tunnel.Desc) *table.Table {
-	tbl := table.New("Status", "Name", "Local", "", "Remote", "Via")
+	tbl := table.New("Status", "Name", "Local", "", "Remote", "Via", "Server")
 	for _, t := range tunnels {
-		tbl.AddRow(status(t), t.Name, t.LocalAddress, t.Mode, t.RemoteAddress, t.Host)
+		local, remote := boundAddrs(t)
+		// With multiple hosts, show the one in use
+		via := t.Host.String()
+		if t.Bastion != "" {
+			via = t.Bastion
+		}
+		tbl.AddRow(status(t), t.Name, local, t.Mode, remote, via, t.Server)
 	}
 	return tbl
 }
 
+// boundAddrs returns the local and remote address of t, with the listen
+// address replaced by the addresses bound if the OS picked the port
+func boundAddrs(t *tunnel.Desc) (local, remote string) {
+	local, remote = t.LocalAddress.String(), t.RemoteAddress.String()
+	if t.Mode == tunnel.Remote || t.Mode == tunnel.RemoteSocks {
+		remote = withBound(remote, t.Bound)
+	} else {
+		local = withBound(local, t.Bound)
+	}
+	return
+}
+
+// withBound replaces a listen address with the addresses actually bound if
+// it lets the OS pick a port, such that the port can be discovered.
+func withBound(addr string, bound []string) string {
+	if len(bound) == 0 {
+		return addr
+	}
+	for _, a := range strings.Split(addr, ",") {
+		a = strings.TrimSpace(a)
+		if a == "0" || strings.HasSuffix(a, ":0") {
+			return strings.Join(bound, ",")
+		}
+	}
+	return addr
+}
+
 func filterByPatterns(ts map[string]*tunnel.Desc, pats []string) (map[string]bool, []string) {
 	keep := make(map[string]bool, len(ts))
 	var notMatched []string
@@ -364,3 +474,34 @@ func filterByGroup(ts map[string]*tunnel.Desc, group string) map[string]bool {
 	}
 	return keep
 }
+
+func filterByTag(ts map[string]*tunnel.Desc, tag string) map[string]bool {
+	keep := make(map[string]bool)
+	for name, t := range ts {
+		if hasTag(t, tag) {
+			keep[name] = true
+		}
+	}
+	return keep
+}
+
+// hasTag reports whether tag is among the tunnel's tags, or is the Tag of
+// its host in the ssh config.
+func hasTag(t *tunnel.Desc, tag string) bool {
+	if slices.Contains(t.Tags, tag) {
+		return true
+	}
+	host, user, _, err := ssh_config.ParseDestination(t.Host.First())
+	if err != nil {
+		return false
+	}
+	if user == "" {
+		user = t.User
+	}
+	sc, err := ssh_config.ParseSSHConfigFile(host, user, t.SSHConfigFile)
+	if err != nil {
+		log.Warningf("Could not parse SSH config for '%v': %v", t.Name, err)
+		return false
+	}
+	return sc.Tag == tag
+}
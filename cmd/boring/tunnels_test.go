@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+func TestWithBound(t *testing.T) {
+	bound := []string{"127.0.0.1:53412"}
+	cases := []struct {
+		addr  string
+		bound []string
+		want  string
+	}{
+		{"127.0.0.1:0", bound, "127.0.0.1:53412"},
+		{"0", bound, "127.0.0.1:53412"},
+		{"9000,localhost:0", []string{"127.0.0.1:9000", "127.0.0.1:53412"},
+			"127.0.0.1:9000,127.0.0.1:53412"},
+		{"localhost:9000", []string{"127.0.0.1:9000"}, "localhost:9000"},
+		{"127.0.0.1:0", nil, "127.0.0.1:0"},
+	}
+	for _, c := range cases {
+		if got := withBound(c.addr, c.bound); got != c.want {
+			t.Errorf("withBound(%q, %v) = %q, want %q", c.addr, c.bound, got, c.want)
+		}
+	}
+}
+
+func TestOrderNames(t *testing.T) {
+	conf := []tunnel.Desc{{Name: "db"}, {Name: "web"}, {Name: "cache"}}
+	keep := map[string]bool{"cache": true, "db": true, "old-b": true, "old-a": true}
+	want := []string{"db", "cache", "old-a", "old-b"}
+	if got := orderNames(keep, conf); !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
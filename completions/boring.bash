@@ -3,7 +3,7 @@ _boring() {
     cur="${COMP_WORDS[COMP_CWORD]}"
     prev="${COMP_WORDS[COMP_CWORD-1]}"
 
-    local commands=("open" "close" "list" "edit" "version" "help")
+    local commands=("open" "close" "pause" "resume" "list" "check" "health" "connections" "config" "known-host" "edit" "version" "help")
 
     _boring_get_names() {
         local status="$1"
@@ -12,6 +12,8 @@ _boring() {
         # retrieve tunnel names based on command
         if [[ "$status" == "closed" ]]; then
             names=($(boring list 2>/dev/null | awk '$1 == "closed" { print $2 }'))
+        elif [[ "$status" == "all" ]]; then
+            names=($(boring list 2>/dev/null | awk '$1 != "Status" && NF >= 2 { print $2 }'))
         else
             names=($(boring list 2>/dev/null | awk '$1 != "closed" && $1 != "Status" && NF >= 2 { print $2 }'))
         fi
@@ -50,8 +52,10 @@ _boring() {
             COMPREPLY=()
         elif [[ "$cmd" == "open" || "$cmd" == "o" ]]; then
             _boring_get_names "closed"
-        elif [[ "$cmd" == "close" || "$cmd" == "c" ]]; then
+        elif [[ "$cmd" == "close" || "$cmd" == "c" || "$cmd" == "pause" || "$cmd" == "resume" || "$cmd" == "connections" || "$cmd" == "conns" ]]; then
             _boring_get_names "open"
+        elif [[ "$cmd" == "check" || "$cmd" == "config" || "$cmd" == "known-host" ]]; then
+            _boring_get_names "all"
         fi
     fi
 }
@@ -8,7 +8,6 @@ require (
 	golang.org/x/crypto v0.54.0
 	golang.org/x/net v0.56.0
 	golang.org/x/sync v0.19.0
+	golang.org/x/sys v0.47.0
 	golang.org/x/term v0.45.0
 )
-
-require golang.org/x/sys v0.47.0 // indirect
@@ -51,3 +51,12 @@ func GetSigners() ([]ssh.Signer, error) {
 
 	return signers, nil
 }
+
+// Add adds a private key to the agent
+func Add(key agent.AddedKey) error {
+	agent, err := getAgent()
+	if err != nil {
+		return err
+	}
+	return agent.Add(key)
+}
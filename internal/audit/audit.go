@@ -0,0 +1,110 @@
+// Package audit records the lifecycle of tunnels and forwarded connections,
+// one JSON object per line, separately from the regular log.
+package audit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Kinds of events
+const (
+	TunnelOpen  = "tunnel_open"
+	TunnelClose = "tunnel_close"
+	ConnOpen    = "conn_open"
+	ConnClose   = "conn_close"
+)
+
+// Event is a line of the audit log. Bytes are counted from the perspective
+// of the peer connecting to the tunnel, i.e., BytesIn were sent by it.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Event      string    `json:"event"`
+	Tunnel     string    `json:"tunnel"`
+	Peer       string    `json:"peer,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	BytesIn    uint64    `json:"bytes_in"`
+	BytesOut   uint64    `json:"bytes_out"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+var (
+	mutex  sync.Mutex
+	writer io.Writer
+	// path of the audit log, if opened via InitFile
+	path string
+)
+
+// Init directs events to w. A nil w disables auditing.
+func Init(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	writer, path = w, ""
+}
+
+// InitFile directs events to the file at p. The file and its parent
+// directories are created if needed, otherwise the file is appended to.
+func InitFile(p string) error {
+	f, err := openFile(p)
+	if err != nil {
+		return err
+	}
+	Init(f)
+	mutex.Lock()
+	path = p
+	mutex.Unlock()
+	return nil
+}
+
+// Reopen reopens the file opened via InitFile, e.g., after it has been
+// moved away by an external log rotation tool.
+func Reopen() error {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if path == "" {
+		return nil
+	}
+	f, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	if c, ok := writer.(io.Closer); ok {
+		c.Close()
+	}
+	writer = f
+	return nil
+}
+
+func openFile(p string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
+// Enabled tells whether events are recorded
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return writer != nil
+}
+
+// Record writes e to the audit log, if enabled. The time defaults to now.
+func Record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	if writer != nil {
+		writer.Write(append(b, '\n'))
+	}
+}
@@ -0,0 +1,53 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRecord(t *testing.T) {
+	defer Init(nil)
+	var buf bytes.Buffer
+
+	Record(Event{Event: ConnOpen, Tunnel: "test"})
+	if Enabled() {
+		t.Fatal("enabled without writer")
+	}
+
+	Init(&buf)
+	Record(Event{Event: ConnClose, Tunnel: "test", Peer: "127.0.0.1:5000",
+		Target: "db:5432", BytesIn: 3, BytesOut: 5, DurationMs: 42})
+	var e Event
+	if err := json.Unmarshal(buf.Bytes(), &e); err != nil {
+		t.Fatalf("invalid JSON %q: %v", buf.String(), err)
+	}
+	if e.Time.IsZero() || e.Event != ConnClose || e.Target != "db:5432" || e.BytesOut != 5 {
+		t.Errorf("unexpected event %+v", e)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	defer Init(nil)
+	p := filepath.Join(t.TempDir(), "audit", "audit.log")
+	if err := InitFile(p); err != nil {
+		t.Fatal(err)
+	}
+	Record(Event{Event: TunnelOpen, Tunnel: "a"})
+	if err := os.Rename(p, p+".1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	Record(Event{Event: TunnelClose, Tunnel: "a"})
+
+	for _, f := range []string{p + ".1", p} {
+		b, err := os.ReadFile(f)
+		if err != nil || bytes.Count(b, []byte("\n")) != 1 {
+			t.Errorf("%v: got %q, %v", f, b, err)
+		}
+	}
+}
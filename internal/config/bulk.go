@@ -0,0 +1,53 @@
+package config
+
+import (
+	"io"
+	"sync"
+
+	"github.com/alebeck/boring/internal/tunnel"
+	"golang.org/x/sync/errgroup"
+)
+
+// Opened is the outcome of opening a tunnel of a bulk request
+type Opened struct {
+	// Tunnel is the handle of the opened tunnel, if open returned one
+	Tunnel *tunnel.Tunnel
+	Err    error
+}
+
+// OpenJSONLines opens all tunnels described in r, one JSON object per line,
+// at most OpenConcurrency at once, and continues past individual failures.
+// Tunnels are opened by open, or in this process if it is nil. It returns
+// the outcome per tunnel name, and errors of lines that could not be
+// decoded.
+func (c *Config) OpenJSONLines(r io.Reader, open func(*tunnel.Desc) (*tunnel.Tunnel, error)) (map[string]Opened, []error) {
+	if open == nil {
+		open = openInProcess
+	}
+	ts, errs := c.DecodeJSONLines(r)
+	res := make(map[string]Opened, len(ts))
+	var mu sync.Mutex
+	var g errgroup.Group
+	if c.OpenConcurrency > 0 {
+		g.SetLimit(c.OpenConcurrency)
+	}
+	for i := range ts {
+		g.Go(func() error {
+			t, err := open(&ts[i])
+			mu.Lock()
+			res[ts[i].Name] = Opened{t, err}
+			mu.Unlock()
+			return nil
+		})
+	}
+	g.Wait()
+	return res, errs
+}
+
+func openInProcess(d *tunnel.Desc) (*tunnel.Tunnel, error) {
+	t := tunnel.FromDesc(d)
+	if err := t.Open(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
@@ -1,10 +1,16 @@
 package config
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime"
+	"slices"
 	"strings"
 
 	"github.com/BurntSushi/toml"
@@ -15,6 +21,8 @@ import (
 const (
 	fileName   = ".boring.toml"
 	socksLabel = "[SOCKS]"
+	// envTunnels holds additional tunnels as a TOML array of inline tables
+	envTunnels = "BORING_TUNNELS"
 )
 
 var defaultKeepAliveInterval = 2 * 60 // seconds
@@ -25,11 +33,64 @@ var Path string
 type Config struct {
 	// Tunnels is a list of tunnel descriptions
 	Tunnels []tunnel.Desc `toml:"tunnels"`
+	// Templates are named base definitions tunnels can extend
+	Templates map[string]toml.Primitive `toml:"templates"`
+	// Include lists files, directories or glob patterns of files defining
+	// further tunnels, which are merged in order
+	Include []string `toml:"include"`
 	// KeepAlive allows to specify a global keep alive interval,
 	// (in seconds) overriding the default one. `0` indicates
 	// no keep alive.
-	KeepAlive  *int                    `toml:"keep_alive"`
-	TunnelsMap map[string]*tunnel.Desc `toml:"-"`
+	KeepAlive *int `toml:"keep_alive"`
+	// ClientVersion is the SSH version string sent to servers by tunnels
+	// that don't specify one on their own.
+	ClientVersion string `toml:"client_version"`
+	// DefaultIdentityFiles are the key files tried for hosts without an
+	// IdentityFile in the ssh config, replacing OpenSSH's defaults.
+	DefaultIdentityFiles []string `toml:"default_identity_files"`
+	// KnownHostsFile is the known_hosts file keys of new hosts are added
+	// to, replacing the first UserKnownHostsFile of the ssh config.
+	KnownHostsFile string `toml:"known_hosts_file"`
+	// LogFile is the path of the daemon log file, `$BORING_LOG_FILE`
+	// takes precedence.
+	LogFile string `toml:"log_file"`
+	// PIDFile is the path of the daemon's lock file, `$BORING_PID_FILE`
+	// takes precedence.
+	PIDFile string `toml:"pid_file"`
+	// StateFile is the path tunnel state is persisted to across daemon
+	// restarts, if set
+	StateFile string `toml:"state_file"`
+	// AuditLog is the path tunnel and connection lifecycle events are
+	// written to as JSON lines, if set
+	AuditLog string `toml:"audit_log"`
+	// MetricsListen is the address to serve Prometheus metrics on, if set
+	MetricsListen string `toml:"metrics_listen"`
+	// ConnectLimit caps connection attempts per host within
+	// ConnectLimitWindow (in seconds), across all tunnels. `0` disables it.
+	ConnectLimit       int `toml:"connect_limit"`
+	ConnectLimitWindow int `toml:"connect_limit_window"`
+	// OpenConcurrency caps how many tunnels the CLI opens at once when
+	// opening several, which then start in config order. `0` opens all at
+	// once, in no particular order. The daemon itself does not apply it.
+	OpenConcurrency int `toml:"open_concurrency"`
+	// LogSampleWindow (in seconds) enables coalescing of identical daemon
+	// log messages within the window. `0` disables sampling.
+	LogSampleWindow int `toml:"log_sample_window"`
+	// LogSampleThreshold is the number of identical messages logged per
+	// window before further ones are suppressed.
+	LogSampleThreshold int `toml:"log_sample_threshold"`
+	// LogMaxFiles is the number of rotated daemon log files kept, `0`
+	// truncates the log file when full instead.
+	LogMaxFiles int `toml:"log_max_files"`
+	// LogCompress gzips rotated log files
+	LogCompress bool `toml:"log_compress"`
+	// LogMaxLineLength (in bytes) truncates longer log messages, `0`
+	// disables truncation.
+	LogMaxLineLength *int `toml:"log_max_line_length"`
+	// ShutdownTimeout (in seconds) bounds closing tunnels on shutdown,
+	// after which remaining ones are force-closed.
+	ShutdownTimeout int                     `toml:"shutdown_timeout"`
+	TunnelsMap      map[string]*tunnel.Desc `toml:"-"`
 }
 
 func init() {
@@ -57,28 +118,30 @@ func Load() (*Config, error) {
 	cfg := Config{KeepAlive: &defaultKeepAliveInterval}
 
 	if _, err := toml.DecodeFile(Path, &cfg); err != nil {
-		return nil, fmt.Errorf("could not decode config file: %w", err)
-	}
-
-	// Set global keep alive interval for all tunnels
-	// that don't specify one on their own.
-	for i := range cfg.Tunnels {
-		t := &cfg.Tunnels[i]
-		if t.KeepAlive == nil {
-			t.KeepAlive = cfg.KeepAlive
+		// The config file is optional if tunnels are given via environment
+		if !errors.Is(err, fs.ErrNotExist) || os.Getenv(envTunnels) == "" {
+			return nil, fmt.Errorf("could not decode config file: %w", err)
 		}
 	}
+	err := extendTemplates(cfg.Tunnels, cfg.Templates, func(v any) (toml.MetaData, error) {
+		return toml.DecodeFile(Path, v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := mergeIncludes(&cfg); err != nil {
+		return nil, err
+	}
+	if err := mergeEnvTunnels(&cfg); err != nil {
+		return nil, err
+	}
 
-	// Expand environment variables for a pre-defined set of fields
-	expand := func(s string) string { return os.Expand(s, expandWithDefault) }
+	cfg.expandFiles()
 	for i := range cfg.Tunnels {
 		t := &cfg.Tunnels[i]
-		t.Host = expand(t.Host)
-		t.User = expand(t.User)
-		t.IdentityFile = expand(t.IdentityFile)
-		t.Port = tunnel.StringOrInt(expand(t.Port.String()))
-		t.LocalAddress = tunnel.StringOrInt(expand(t.LocalAddress.String()))
-		t.RemoteAddress = tunnel.StringOrInt(expand(t.RemoteAddress.String()))
+		if err := cfg.normalize(t); err != nil {
+			return nil, fmt.Errorf("tunnel '%v': %v", t.Name, err)
+		}
 	}
 
 	// Create a map of tunnel names to tunnel pointers for easy lookup later
@@ -87,19 +150,201 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	cfg.TunnelsMap = m
+	return &cfg, nil
+}
+
+// expand expands environment variables in s
+func expand(s string) string {
+	return os.Expand(s, expandWithDefault)
+}
+
+// expandFiles expands environment variables and ~ in the paths of files
+// written by the daemon
+func (c *Config) expandFiles() {
+	for _, p := range []*string{&c.LogFile, &c.PIDFile, &c.StateFile, &c.AuditLog} {
+		if *p != "" {
+			*p = paths.ReplaceTilde(expand(*p))
+		}
+	}
+}
+
+// normalize gives t the global settings it does not set on its own,
+// expands environment variables and ~ in a pre-defined set of fields, and
+// reads secrets from files.
+func (c *Config) normalize(t *tunnel.Desc) error {
+	if t.KeepAlive == nil {
+		t.KeepAlive = c.KeepAlive
+		if t.KeepAlive == nil {
+			t.KeepAlive = &defaultKeepAliveInterval
+		}
+	}
+	if t.ClientVersion == "" {
+		t.ClientVersion = c.ClientVersion
+	}
+
+	t.Host = tunnel.Addresses(expand(t.Host.String()))
+	t.User = expand(t.User)
+	t.IdentityFile = expand(t.IdentityFile)
+	t.Port = tunnel.StringOrInt(expand(t.Port.String()))
+	t.LocalAddress = tunnel.Addresses(expand(t.LocalAddress.String()))
+	t.RemoteAddress = tunnel.StringOrInt(expand(t.RemoteAddress.String()))
+	t.PasswordFile = paths.ReplaceTilde(expand(t.PasswordFile))
+	t.TLSCert = paths.ReplaceTilde(expand(t.TLSCert))
+	t.TLSKey = paths.ReplaceTilde(expand(t.TLSKey))
+	t.SSHConfigFile = paths.ReplaceTilde(expand(t.SSHConfigFile))
+
 	// Replace the remote address of Socks tunnels and local address of reverse
 	// socks tunnels by a fixed indicator, it is not used for anything anyway
-	for _, t := range m {
-		switch t.Mode {
-		case tunnel.Socks:
-			t.RemoteAddress = socksLabel
-		case tunnel.RemoteSocks:
-			t.LocalAddress = socksLabel
+	switch t.Mode {
+	case tunnel.Socks:
+		t.RemoteAddress = socksLabel
+	case tunnel.RemoteSocks:
+		t.LocalAddress = socksLabel
+	}
+	return readSecretFiles(t)
+}
+
+// mergeEnvTunnels adds the tunnels defined in $BORING_TUNNELS to cfg, e.g.,
+// `[{name = "db", local = 5432, remote = "localhost:5432", host = "bastion"}]`.
+// Tunnels from the environment replace file-based ones of the same name.
+func mergeEnvTunnels(cfg *Config) error {
+	s := os.Getenv(envTunnels)
+	if s == "" {
+		return nil
+	}
+	var env struct {
+		Tunnels []tunnel.Desc `toml:"tunnels"`
+	}
+	if _, err := toml.Decode("tunnels = "+s, &env); err != nil {
+		return fmt.Errorf("could not decode $%s: %v", envTunnels, err)
+	}
+	err := extendTemplates(env.Tunnels, cfg.Templates, func(v any) (toml.MetaData, error) {
+		return toml.Decode("tunnels = "+s, v)
+	})
+	if err != nil {
+		return fmt.Errorf("$%s: %v", envTunnels, err)
+	}
+	for _, t := range env.Tunnels {
+		i := slices.IndexFunc(cfg.Tunnels, func(c tunnel.Desc) bool { return c.Name == t.Name })
+		if i >= 0 {
+			cfg.Tunnels[i] = t
+		} else {
+			cfg.Tunnels = append(cfg.Tunnels, t)
 		}
 	}
+	return nil
+}
 
-	cfg.TunnelsMap = m
-	return &cfg, nil
+// mergeIncludes adds the tunnels of included files. A tunnel replaces one
+// of the same name defined before only if it sets override.
+func mergeIncludes(cfg *Config) error {
+	files, err := includedFiles(cfg.Include)
+	if err != nil {
+		return err
+	}
+	origin := make(map[string]string, len(cfg.Tunnels))
+	for _, t := range cfg.Tunnels {
+		origin[t.Name] = Path
+	}
+	for _, f := range files {
+		var inc struct {
+			Tunnels []tunnel.Desc `toml:"tunnels"`
+		}
+		md, err := toml.DecodeFile(f, &inc)
+		if err != nil {
+			return fmt.Errorf("could not decode included file: %v", err)
+		}
+		for _, k := range md.Undecoded() {
+			if len(k) == 1 {
+				return fmt.Errorf("%v: only tunnels can be defined in included files, found '%v'", f, k)
+			}
+		}
+		err = extendTemplates(inc.Tunnels, cfg.Templates, func(v any) (toml.MetaData, error) {
+			return toml.DecodeFile(f, v)
+		})
+		if err != nil {
+			return fmt.Errorf("%v: %v", f, err)
+		}
+		for _, t := range inc.Tunnels {
+			i := slices.IndexFunc(cfg.Tunnels, func(c tunnel.Desc) bool { return c.Name == t.Name })
+			if i < 0 {
+				cfg.Tunnels = append(cfg.Tunnels, t)
+			} else if t.Override {
+				cfg.Tunnels[i] = t
+			} else {
+				return fmt.Errorf("%v: tunnel '%v' is already defined in %v, set override = true to replace it",
+					f, t.Name, origin[t.Name])
+			}
+			origin[t.Name] = f
+		}
+	}
+	return nil
+}
+
+// extendTemplates replaces tunnels that extend a template by the template,
+// with the settings of the tunnel applied on top. decode decodes the source
+// of tunnels again, such that the settings given can be told apart from
+// zero values.
+func extendTemplates(tunnels []tunnel.Desc, templates map[string]toml.Primitive,
+	decode func(any) (toml.MetaData, error)) error {
+	if !slices.ContainsFunc(tunnels, func(t tunnel.Desc) bool { return t.Extends != "" }) {
+		return nil
+	}
+	var raw struct {
+		Tunnels []toml.Primitive `toml:"tunnels"`
+	}
+	md, err := decode(&raw)
+	if err != nil {
+		return fmt.Errorf("could not decode tunnels: %v", err)
+	}
+	for i := range tunnels {
+		name := tunnels[i].Extends
+		if name == "" {
+			continue
+		}
+		base, ok := templates[name]
+		if !ok {
+			return fmt.Errorf("tunnel '%v' extends unknown template '%v'", tunnels[i].Name, name)
+		}
+		var t tunnel.Desc
+		if err := md.PrimitiveDecode(base, &t); err != nil {
+			return fmt.Errorf("could not decode template '%v': %v", name, err)
+		}
+		if t.Extends != "" {
+			return fmt.Errorf("template '%v' cannot extend another template", name)
+		}
+		if err := md.PrimitiveDecode(raw.Tunnels[i], &t); err != nil {
+			return fmt.Errorf("could not decode tunnel '%v': %v", tunnels[i].Name, err)
+		}
+		tunnels[i] = t
+	}
+	return nil
+}
+
+// includedFiles returns the files matched by the include patterns, in
+// order. Directories include the .toml files in them, and relative paths
+// are relative to the config file.
+func includedFiles(patterns []string) ([]string, error) {
+	var files []string
+	for _, p := range patterns {
+		p = paths.ReplaceTilde(os.Expand(p, expandWithDefault))
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(Path), p)
+		}
+		if info, err := os.Stat(p); err == nil && info.IsDir() {
+			p = filepath.Join(p, "*.toml")
+		}
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid include pattern %q: %v", p, err)
+		}
+		if len(matches) == 0 && !containsGlob(p) {
+			return nil, fmt.Errorf("included file %v does not exist", p)
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
 }
 
 func buildTunnelsMap(tunnels []tunnel.Desc) (map[string]*tunnel.Desc, error) {
@@ -109,23 +354,95 @@ func buildTunnelsMap(tunnels []tunnel.Desc) (map[string]*tunnel.Desc, error) {
 		if _, exists := m[t.Name]; exists {
 			return nil, fmt.Errorf("found duplicated tunnel name '%v'", t.Name)
 		}
-		if t.Name == "" || strings.Contains(t.Name, " ") ||
-			specialPrefix(t.Name) || containsGlob(t.Name) {
-			return nil, fmt.Errorf("tunnel names cannot be empty, contain spaces,"+
-				" start with special characters, or contain glob characters '*?['."+
-				" Found '%v'.", t.Name)
+		if err := validateNames(t); err != nil {
+			return nil, err
 		}
-		if t.Group != "" && (strings.Contains(t.Group, " ") ||
-			specialPrefix(t.Group) || containsGlob(t.Group) || t.Group == "default") {
-			return nil, fmt.Errorf("groups cannot be named 'default', contain spaces,"+
-				" start with special characters, or contain glob characters '*?['."+
-				" Found '%v'.", t.Group)
+		if _, err := tunnel.ParseSchedule(t.Schedule); err != nil {
+			return nil, fmt.Errorf("tunnel '%v': %v", t.Name, err)
 		}
 		m[t.Name] = t
 	}
 	return m, nil
 }
 
+func validateNames(t *tunnel.Desc) error {
+	if t.Name == "" || strings.Contains(t.Name, " ") ||
+		specialPrefix(t.Name) || containsGlob(t.Name) {
+		return fmt.Errorf("tunnel names cannot be empty, contain spaces,"+
+			" start with special characters, or contain glob characters '*?['."+
+			" Found '%v'.", t.Name)
+	}
+	if t.Group != "" && (strings.Contains(t.Group, " ") ||
+		specialPrefix(t.Group) || containsGlob(t.Group) || t.Group == "default") {
+		return fmt.Errorf("groups cannot be named 'default', contain spaces,"+
+			" start with special characters, or contain glob characters '*?['."+
+			" Found '%v'.", t.Group)
+	}
+	for _, tag := range t.Tags {
+		if tag == "" || strings.Contains(tag, " ") || containsGlob(tag) {
+			return fmt.Errorf("tags cannot be empty, contain spaces, or contain"+
+				" glob characters '*?['. Found '%v'.", tag)
+		}
+	}
+	return nil
+}
+
+// DecodeJSONLines reads tunnel descriptions from r, one JSON object per
+// line, e.g., as pushed by an orchestrator. They are validated and receive
+// global settings and are normalized like tunnels from the config file.
+// Invalid lines are skipped and reported in errs.
+func (c *Config) DecodeJSONLines(r io.Reader) (ts []tunnel.Desc, errs []error) {
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		var t tunnel.Desc
+		if err := json.Unmarshal([]byte(line), &t); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", n, err))
+			continue
+		}
+		if err := validateNames(&t); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", n, err))
+			continue
+		}
+		if seen[t.Name] {
+			errs = append(errs, fmt.Errorf("line %d: duplicated tunnel name '%v'", n, t.Name))
+			continue
+		}
+		if err := c.normalize(&t); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %v", n, err))
+			continue
+		}
+		seen[t.Name] = true
+		t.Status = tunnel.Closed
+		ts = append(ts, t)
+	}
+	if err := sc.Err(); err != nil {
+		errs = append(errs, err)
+	}
+	return
+}
+
+// readSecretFiles sets secrets given as "<field>_file" from the contents of
+// the respective file, such that they need not be inlined into the config.
+func readSecretFiles(t *tunnel.Desc) error {
+	if t.PasswordFile == "" {
+		return nil
+	}
+	if t.Password != "" {
+		return fmt.Errorf("only one of password and password_file may be set")
+	}
+	b, err := os.ReadFile(t.PasswordFile)
+	if err != nil {
+		return fmt.Errorf("could not read password_file: %v", err)
+	}
+	t.Password = strings.TrimRight(string(b), "\r\n")
+	return nil
+}
+
 func specialPrefix(s string) bool {
 	if s == "" {
 		return false
@@ -1,8 +1,17 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
 )
 
 func TestLoadMissingFile(t *testing.T) {
@@ -75,7 +84,7 @@ func TestExpandDefault(t *testing.T) {
 		"unset": "fallback",
 	}
 	for name, want := range cases {
-		if got := cfg.TunnelsMap[name].Host; got != want {
+		if got := cfg.TunnelsMap[name].Host.String(); got != want {
 			t.Errorf("tunnel %q Host = %q, want %q", name, got, want)
 		}
 	}
@@ -104,3 +113,299 @@ func TestExpandFieldsNotExpanded(t *testing.T) {
 		t.Errorf("Group = %q, want it left literal", tun.Group)
 	}
 }
+
+func TestEnvTunnels(t *testing.T) {
+	t.Setenv("BORING_TUNNELS", `[`+
+		`{name = "test", local = 9001, remote = "localhost:8081", host = "env-host"},`+
+		`{name = "extra", local = "9002", remote = "localhost:8082", host = "other", mode = "local"}]`)
+	p := filepath.Join(t.TempDir(), "config.toml")
+	conf := "[[tunnels]]\nname = \"test\"\nlocal = 9000\nremote = \"localhost:8080\"\nhost = \"file-host\"\n"
+	if err := os.WriteFile(p, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadFixture(t, p)
+	if len(cfg.Tunnels) != 2 {
+		t.Fatalf("got %d tunnels, want 2", len(cfg.Tunnels))
+	}
+	if h := cfg.TunnelsMap["test"].Host; h != "env-host" {
+		t.Errorf("environment did not take precedence, host = %q", h)
+	}
+	if cfg.TunnelsMap["extra"] == nil || cfg.TunnelsMap["extra"].KeepAlive == nil {
+		t.Errorf("extra tunnel not merged properly: %+v", cfg.TunnelsMap["extra"])
+	}
+
+	// No config file is needed
+	cfg = loadFixture(t, filepath.Join(t.TempDir(), "missing.toml"))
+	if len(cfg.Tunnels) != 2 {
+		t.Errorf("got %d tunnels without config file, want 2", len(cfg.Tunnels))
+	}
+}
+
+func TestEnvTunnelsInvalid(t *testing.T) {
+	t.Setenv("BORING_TUNNELS", `[{name = "test"`)
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(t.TempDir(), "missing.toml")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "BORING_TUNNELS") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGlobalClientVersion(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.toml")
+	conf := "client_version = \"SSH-2.0-global\"\n" +
+		"[[tunnels]]\nname = \"a\"\nhost = \"h\"\n" +
+		"[[tunnels]]\nname = \"b\"\nhost = \"h\"\nclient_version = \"SSH-2.0-own\"\n"
+	if err := os.WriteFile(p, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadFixture(t, p)
+	if v := cfg.TunnelsMap["a"].ClientVersion; v != "SSH-2.0-global" {
+		t.Errorf("a: got %q, want global version", v)
+	}
+	if v := cfg.TunnelsMap["b"].ClientVersion; v != "SSH-2.0-own" {
+		t.Errorf("b: got %q, want own version", v)
+	}
+}
+
+func TestLocalAddressList(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "config.toml")
+	conf := "[[tunnels]]\nname = \"a\"\nhost = \"h\"\nremote = \"localhost:80\"\n" +
+		"local = [9000, \"192.168.1.5:9000\"]\n"
+	if err := os.WriteFile(p, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadFixture(t, p)
+	if l := cfg.TunnelsMap["a"].LocalAddress; l != "9000,192.168.1.5:9000" {
+		t.Errorf("got local %q", l)
+	}
+}
+
+func TestDecodeJSONLines(t *testing.T) {
+	keepAlive := 30
+	c := &Config{KeepAlive: &keepAlive}
+	in := `{"name": "a", "host": "h", "local": "9000", "remote": "localhost:80", "mode": "socks"}
+
+not json
+{"name": "a", "host": "h"}
+{"name": "*"}
+{"name": "b", "host": "h", "keep_alive": 5}
+`
+	ts, errs := c.DecodeJSONLines(strings.NewReader(in))
+	if len(ts) != 2 || len(errs) != 3 {
+		t.Fatalf("got %d tunnels and errors %v", len(ts), errs)
+	}
+	if !strings.HasPrefix(errs[0].Error(), "line 3:") {
+		t.Errorf("error lacks line number: %v", errs[0])
+	}
+	if ts[0].Mode != tunnel.Socks || *ts[0].KeepAlive != 30 {
+		t.Errorf("unexpected tunnel: %+v", ts[0])
+	}
+	if *ts[1].KeepAlive != 5 {
+		t.Errorf("keep-alive overridden: %v", *ts[1].KeepAlive)
+	}
+}
+
+// Lines are normalized like tunnels from the config file
+func TestDecodeJSONLinesNormalized(t *testing.T) {
+	t.Setenv("JSON_HOST", "bastion")
+	in := `{"name": "c", "host": "${JSON_HOST}", "tls_cert": "~/cert.pem", "mode": "socks", "remote": "x"}`
+	ts, errs := (&Config{}).DecodeJSONLines(strings.NewReader(in))
+	if len(ts) != 1 || len(errs) != 0 {
+		t.Fatalf("got %d tunnels and errors %v", len(ts), errs)
+	}
+	if ts[0].Host != "bastion" || ts[0].TLSCert == "" || strings.HasPrefix(ts[0].TLSCert, "~") ||
+		ts[0].RemoteAddress != socksLabel || *ts[0].KeepAlive != defaultKeepAliveInterval {
+		t.Errorf("tunnel not normalized: %+v", ts[0])
+	}
+}
+
+// Values take the forms documented for the config file
+func TestDecodeJSONLinesValueForms(t *testing.T) {
+	in := `{"name": "d", "host": ["h1", "h2"], "port": 2222, "local": ["a:1", 9000], ` +
+		`"remote": 80, "proxy_protocol": "v1"}`
+	ts, errs := (&Config{}).DecodeJSONLines(strings.NewReader(in))
+	if len(ts) != 1 || len(errs) != 0 {
+		t.Fatalf("got %d tunnels and errors %v", len(ts), errs)
+	}
+	if d := ts[0]; d.Host != "h1,h2" || d.Port != "2222" || d.LocalAddress != "a:1,9000" ||
+		d.RemoteAddress != "80" || d.ProxyProtocol != tunnel.ProxyV1 {
+		t.Errorf("unexpected tunnel: %+v", d)
+	}
+}
+
+func TestOpenJSONLines(t *testing.T) {
+	c := &Config{OpenConcurrency: 1}
+	in := `{"name": "a", "host": "h", "local": "9000", "remote": "localhost:80"}
+not json
+{"name": "b", "host": "h", "local": "9001", "remote": "localhost:80"}
+`
+	res, errs := c.OpenJSONLines(strings.NewReader(in), func(d *tunnel.Desc) (*tunnel.Tunnel, error) {
+		if d.Name == "b" {
+			return nil, errors.New("failed")
+		}
+		return tunnel.FromDesc(d), nil
+	})
+	if len(errs) != 1 || len(res) != 2 {
+		t.Fatalf("got results %v and errors %v", res, errs)
+	}
+	if res["a"].Tunnel == nil || res["a"].Err != nil {
+		t.Errorf("unexpected result for a: %+v", res["a"])
+	}
+	if res["b"].Tunnel != nil || res["b"].Err == nil {
+		t.Errorf("unexpected result for b: %+v", res["b"])
+	}
+
+	// By default, tunnels are opened in this process
+	log.Init(io.Discard, false, false)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	in = fmt.Sprintf(`{"name": "c", "host": "127.0.0.1", "port": %d, "local": "127.0.0.1:0", `+
+		`"remote": "localhost:80", "host_key_policy": "insecure"}`, port)
+	res, errs = c.OpenJSONLines(strings.NewReader(in), nil)
+	if len(errs) != 0 || res["c"].Tunnel != nil || res["c"].Err == nil {
+		t.Errorf("expected connection to fail, got %+v, %v", res["c"], errs)
+	}
+}
+
+func TestPasswordFile(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.WriteFile(secret, []byte("hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(dir, "config.toml")
+	conf := "[[tunnels]]\nname = \"a\"\nhost = \"h\"\npassword_file = \"" +
+		filepath.ToSlash(secret) + "\"\n"
+	if err := os.WriteFile(p, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadFixture(t, p)
+	if pw := cfg.TunnelsMap["a"].Password; pw != "hunter2" {
+		t.Errorf("got password %q", pw)
+	}
+
+	// Both, or an unreadable file, are rejected
+	for _, d := range []tunnel.Desc{
+		{Password: "x", PasswordFile: secret},
+		{PasswordFile: filepath.Join(dir, "missing")},
+	} {
+		if err := readSecretFiles(&d); err == nil {
+			t.Errorf("expected error for %+v", d)
+		}
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	for _, tags := range [][]string{{""}, {"a b"}, {"stag*"}} {
+		if err := validateNames(&tunnel.Desc{Name: "t", Tags: tags}); err == nil {
+			t.Errorf("expected error for tags %q", tags)
+		}
+	}
+	if err := validateNames(&tunnel.Desc{Name: "t", Tags: []string{"staging", "db"}}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": "include = [\"conf.d\", \"personal.toml\"]\n" +
+			"[[tunnels]]\nname = \"base\"\nhost = \"shared-host\"\n",
+		"conf.d/a.toml": "[[tunnels]]\nname = \"a\"\nhost = \"h\"\n",
+		"conf.d/b.toml": "[[tunnels]]\nname = \"b\"\nhost = \"h\"\n",
+		"personal.toml": "[[tunnels]]\nname = \"base\"\nhost = \"own-host\"\noverride = true\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := loadFixture(t, filepath.Join(dir, "config.toml"))
+	var names []string
+	for _, tun := range cfg.Tunnels {
+		names = append(names, tun.Name)
+	}
+	if strings.Join(names, ",") != "base,a,b" {
+		t.Errorf("got tunnels %v", names)
+	}
+	if h := cfg.TunnelsMap["base"].Host; h != "own-host" {
+		t.Errorf("base not overridden, host = %q", h)
+	}
+
+	// Duplicates without override are reported
+	p := filepath.Join(dir, "personal.toml")
+	if err := os.WriteFile(p, []byte("[[tunnels]]\nname = \"a\"\nhost = \"x\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(dir, "config.toml")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "already defined in "+filepath.Join(dir, "conf.d", "a.toml")) {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// Included files only define tunnels
+	if err := os.WriteFile(p, []byte("keep_alive = 5\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "keep_alive") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.toml": "include = [\"more.toml\"]\n" +
+			"[templates.prod]\nhost = \"bastion\"\nuser = \"deploy\"\nkeep_alive = 30\nlocal_allow = [\"10.0.0.0/8\"]\n" +
+			"[[tunnels]]\nname = \"db\"\nextends = \"prod\"\nlocal = 5432\nremote = \"db:5432\"\n" +
+			"[[tunnels]]\nname = \"web\"\nextends = \"prod\"\nuser = \"web\"\nlocal = 8080\nremote = \"web:80\"\n",
+		"more.toml": "[[tunnels]]\nname = \"cache\"\nextends = \"prod\"\nlocal = 6379\nremote = \"cache:6379\"\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg := loadFixture(t, filepath.Join(dir, "config.toml"))
+	for name, user := range map[string]string{"db": "deploy", "web": "web", "cache": "deploy"} {
+		tun := cfg.TunnelsMap[name]
+		if tun == nil {
+			t.Fatalf("tunnel %v missing", name)
+		}
+		if tun.Host != "bastion" || tun.User != user || *tun.KeepAlive != 30 || len(tun.LocalAllow) != 1 {
+			t.Errorf("%v: got host %q, user %q, keep alive %v, local allow %v",
+				name, tun.Host, tun.User, *tun.KeepAlive, tun.LocalAllow)
+		}
+	}
+	if r := cfg.TunnelsMap["web"].RemoteAddress; r != "web:80" {
+		t.Errorf("got remote %q", r)
+	}
+
+	// Unknown templates are reported
+	p := filepath.Join(dir, "more.toml")
+	if err := os.WriteFile(p, []byte("[[tunnels]]\nname = \"x\"\nextends = \"dev\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	orig := Path
+	t.Cleanup(func() { Path = orig })
+	Path = filepath.Join(dir, "config.toml")
+	if _, err := Load(); err == nil || !strings.Contains(err.Error(), "unknown template 'dev'") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -14,6 +14,10 @@ const (
 	Close
 	List
 	Shutdown
+	Health
+	Pause
+	Resume
+	Conns
 )
 
 var cmdKindNames = map[CmdKind]string{
@@ -22,6 +26,10 @@ var cmdKindNames = map[CmdKind]string{
 	Close:    "Close",
 	List:     "List",
 	Shutdown: "Shutdown",
+	Health:   "Health",
+	Pause:    "Pause",
+	Resume:   "Resume",
+	Conns:    "Conns",
 }
 
 func (k CmdKind) String() string {
@@ -36,4 +44,6 @@ func (k CmdKind) String() string {
 type Cmd struct {
 	Kind   CmdKind      `json:"kind"`
 	Tunnel *tunnel.Desc `json:"tunnel,omitempty"`
+	// Drop indicates that pausing should close established connections
+	Drop bool `json:"drop,omitempty"`
 }
@@ -5,16 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/alebeck/boring/internal/audit"
 	"github.com/alebeck/boring/internal/buildinfo"
+	"github.com/alebeck/boring/internal/config"
 	"github.com/alebeck/boring/internal/ipc"
 	"github.com/alebeck/boring/internal/log"
 	"github.com/alebeck/boring/internal/tunnel"
@@ -29,6 +33,7 @@ const (
 var (
 	LogFile        string
 	Socket         string
+	PIDFile        string
 	AlreadyRunning = errors.New("already running")
 )
 
@@ -39,6 +44,10 @@ func init() {
 	if Socket = os.Getenv("BORING_SOCK"); Socket == "" {
 		Socket = filepath.Join(os.TempDir(), sockName)
 	}
+	if PIDFile = os.Getenv("BORING_PID_FILE"); PIDFile == "" {
+		// Derive from socket, such that each daemon instance has its own
+		PIDFile = strings.TrimSuffix(Socket, filepath.Ext(Socket)) + ".pid"
+	}
 }
 
 type daemon struct {
@@ -50,6 +59,20 @@ type daemon struct {
 	tunnels map[string]*tunnel.Tunnel
 	mutex   sync.RWMutex
 
+	// Names of tunnels that failed, and of those being closed on request,
+	// guarded by mutex.
+	failed  map[string]bool
+	closing map[string]bool
+	started time.Time
+	// statePath is the file tunnel state is persisted to, if set
+	statePath string
+	// shutdownTimeout bounds closing tunnels on shutdown
+	shutdownTimeout time.Duration
+	// schedules holds tunnels opened and closed on schedule, guarded by
+	// schedMu
+	schedules map[string]*scheduled
+	schedMu   sync.Mutex
+
 	once sync.Once
 	wg   sync.WaitGroup
 }
@@ -57,7 +80,17 @@ type daemon struct {
 func newDaemon(parent context.Context, ln net.Listener) (*daemon, context.CancelFunc) {
 	ctx, cancel := context.WithCancel(parent)
 	tunnels := make(map[string]*tunnel.Tunnel)
-	d := &daemon{ctx: ctx, cancel: cancel, ln: ln, tunnels: tunnels}
+	d := &daemon{
+		ctx:     ctx,
+		cancel:  cancel,
+		ln:      ln,
+		tunnels: tunnels,
+		failed:  make(map[string]bool),
+		closing: make(map[string]bool),
+		started: time.Now(),
+
+		shutdownTimeout: defaultShutdownTimeout,
+	}
 
 	go func() {
 		// Parent-driven shutdown
@@ -70,21 +103,12 @@ func newDaemon(parent context.Context, ln net.Listener) (*daemon, context.Cancel
 		log.Infof("Cleaning up...")
 		d.stop()
 		d.wg.Wait()
+		d.saveState()
 
-		// Take snapshot of tunnels to close
-		d.mutex.Lock()
-		ts := make([]*tunnel.Tunnel, 0, len(d.tunnels))
-		for _, t := range d.tunnels {
-			ts = append(ts, t)
-		}
-		d.mutex.Unlock()
-
-		// Drain
-		for _, t := range ts {
-			t.Close()
-		}
-		for _, t := range ts {
-			<-t.Closed
+		ctx, cancel := context.WithTimeout(context.Background(), d.shutdownTimeout)
+		defer cancel()
+		if err := d.closeAll(ctx); err != nil {
+			log.Warningf("Not all tunnels closed cleanly: %v", err)
 		}
 		log.Infof("Done.")
 	}
@@ -127,102 +151,228 @@ func (d *daemon) handleConn(conn net.Conn) {
 	}
 	log.Debugf("Received command %v", cmd)
 
-	if (cmd.Kind == Open || cmd.Kind == Close) && cmd.Tunnel == nil {
-		err := fmt.Errorf("no tunnel specified")
-		respond(conn, err, nil)
+	h, ok := handlers[cmd.Kind]
+	if !ok {
+		respond(conn, fmt.Errorf("unknown command: %v", cmd.Kind), nil)
 		return
 	}
+	if h.needsTunnel && cmd.Tunnel == nil {
+		respond(conn, fmt.Errorf("no tunnel specified"), nil)
+		return
+	}
+	h.run(d, conn, cmd)
+}
 
-	// Execute command
-	switch cmd.Kind {
-	case Nop:
+// handler executes a command and responds to it
+type handler struct {
+	run         func(d *daemon, conn net.Conn, cmd Cmd)
+	needsTunnel bool
+}
+
+var handlers = map[CmdKind]handler{
+	Nop: {run: func(_ *daemon, conn net.Conn, _ Cmd) {
 		respond(conn, nil, nil)
-	case Open:
+	}},
+	Open: {run: func(d *daemon, conn net.Conn, cmd Cmd) {
 		d.openTunnel(conn, cmd.Tunnel)
-	case Close:
+	}, needsTunnel: true},
+	Close: {run: func(d *daemon, conn net.Conn, cmd Cmd) {
 		d.closeTunnel(conn, cmd.Tunnel)
-	case List:
+	}, needsTunnel: true},
+	List: {run: func(d *daemon, conn net.Conn, _ Cmd) {
 		d.listTunnels(conn)
-	case Shutdown:
+	}},
+	Health: {run: func(d *daemon, conn net.Conn, _ Cmd) {
+		d.reportHealth(conn)
+	}},
+	Pause:  {run: (*daemon).pauseTunnel, needsTunnel: true},
+	Resume: {run: (*daemon).pauseTunnel, needsTunnel: true},
+	Conns: {run: func(d *daemon, conn net.Conn, cmd Cmd) {
+		d.listConns(conn, cmd.Tunnel)
+	}, needsTunnel: true},
+	Shutdown: {run: func(d *daemon, conn net.Conn, _ Cmd) {
 		log.Infof("Shutdown command received.")
 		respond(conn, nil, nil)
 		d.stop()
-	default:
-		err := fmt.Errorf("unknown command: %v", cmd.Kind)
-		respond(conn, err, nil)
-	}
+	}},
 }
 
+// openTunnel opens desc and responds with it as opened, including the
+// addresses bound
 func (d *daemon) openTunnel(conn net.Conn, desc *tunnel.Desc) {
-	var err error
-	defer func() { respond(conn, err, nil) }()
+	if err := d.open(desc); err != nil {
+		respond(conn, err, nil)
+		return
+	}
+	respond(conn, nil, map[string]tunnel.Desc{desc.Name: *desc})
+}
 
+func (d *daemon) open(desc *tunnel.Desc) error {
 	d.mutex.RLock()
 	_, exists := d.tunnels[desc.Name]
 	d.mutex.RUnlock()
 	if exists {
-		err = AlreadyRunning
-		log.Errorf("%v: could not open: %v", desc.Name, err)
-		return
+		log.With(desc.Name).Errorf("could not open: %v", AlreadyRunning)
+		return AlreadyRunning
 	}
 
 	t := tunnel.FromDesc(desc)
-	if err = t.Open(); err != nil {
-		log.Errorf("%v: could not open: %v", t.Name, err)
-		return
+	if err := t.Open(); err != nil {
+		log.With(t.Name).Errorf("could not open: %v", err)
+		d.mutex.Lock()
+		d.failed[t.Name] = true
+		d.mutex.Unlock()
+		return err
 	}
 
 	d.mutex.Lock()
 	d.tunnels[t.Name] = t
+	delete(d.failed, t.Name)
 	d.mutex.Unlock()
 
 	// Register closing logic
 	go func() {
 		<-t.Closed
 		d.mutex.Lock()
-		delete(d.tunnels, t.Name)
+		// The tunnel may have been replaced by a reload in the meantime
+		if d.tunnels[t.Name] == t {
+			delete(d.tunnels, t.Name)
+		}
+		if !d.closing[t.Name] {
+			// Closed without being asked to, i.e., re-connection failed
+			d.failed[t.Name] = true
+		}
+		delete(d.closing, t.Name)
 		d.mutex.Unlock()
-		log.Infof("Closed tunnel %s", t.Name)
+		log.With(t.Name).Infof("Closed tunnel")
 	}()
+	return nil
 }
 
 func (d *daemon) closeTunnel(conn net.Conn, q *tunnel.Desc) {
+	respond(conn, d.close(q.Name), nil)
+}
+
+// close closes the running tunnel name and waits for it to be closed
+func (d *daemon) close(name string) error {
+	d.mutex.Lock()
+	t, ok := d.tunnels[name]
+	if ok {
+		d.closing[name] = true
+	}
+	d.mutex.Unlock()
+	if !ok {
+		err := fmt.Errorf("tunnel not running")
+		log.With(name).Errorf("could not close tunnel: %v", err)
+		return err
+	}
+
+	if err := t.Close(); err != nil {
+		log.With(t.Name).Errorf("could not close tunnel: %v", err)
+		return err
+	}
+	<-t.Closed
+	return nil
+}
+
+func (d *daemon) pauseTunnel(conn net.Conn, cmd Cmd) {
 	var err error
 	defer func() { respond(conn, err, nil) }()
 
 	d.mutex.RLock()
-	t, ok := d.tunnels[q.Name]
+	t, ok := d.tunnels[cmd.Tunnel.Name]
 	d.mutex.RUnlock()
 	if !ok {
 		err = fmt.Errorf("tunnel not running")
-		log.Errorf("%v: could not close tunnel: %v", q.Name, err)
-		return
+	} else if cmd.Kind == Pause {
+		err = t.Pause(cmd.Drop)
+	} else {
+		err = t.Resume()
 	}
-
-	if err = t.Close(); err != nil {
-		log.Errorf("%v: could not close tunnel: %v", t.Name, err)
-		return
+	if err != nil {
+		log.With(cmd.Tunnel.Name).Errorf("could not %v tunnel: %v",
+			strings.ToLower(cmd.Kind.String()), err)
 	}
-	<-t.Closed
 }
 
 func (d *daemon) listTunnels(conn net.Conn) {
 	d.mutex.RLock()
 	ts := make(map[string]tunnel.Desc, len(d.tunnels))
 	for n, t := range d.tunnels {
-		ts[n] = *t.Desc
+		desc := *t.Desc
+		desc.Bastion = t.BastionHost()
+		desc.Server = strings.TrimPrefix(t.ServerVersion(), "SSH-2.0-")
+		ts[n] = desc
 	}
 	d.mutex.RUnlock()
 	respond(conn, nil, ts)
 }
 
+func (d *daemon) listConns(conn net.Conn, q *tunnel.Desc) {
+	d.mutex.RLock()
+	t, ok := d.tunnels[q.Name]
+	d.mutex.RUnlock()
+	if !ok {
+		respond(conn, fmt.Errorf("tunnel not running"), nil)
+		return
+	}
+	resp := Resp{Success: true, Info: Info{Commit: buildinfo.Commit}, Conns: t.Conns()}
+	if err := ipc.Write(resp, conn); err != nil {
+		log.Errorf("could not send response: %v", err)
+	}
+}
+
+func (d *daemon) reportHealth(conn net.Conn) {
+	h := HealthInfo{
+		Version:    buildinfo.Version,
+		Uptime:     time.Since(d.started),
+		Configured: -1,
+	}
+	if conf, err := config.Load(); err == nil {
+		h.Configured = len(conf.Tunnels)
+	}
+
+	d.mutex.RLock()
+	for _, t := range d.tunnels {
+		switch t.Status {
+		case tunnel.Open:
+			h.Open++
+		case tunnel.Reconn:
+			h.Reconn++
+		case tunnel.Paused:
+			h.Paused++
+		}
+	}
+	h.Failed = len(d.failed)
+	d.mutex.RUnlock()
+
+	resp := Resp{Success: true, Info: Info{Commit: buildinfo.Commit}, Health: &h}
+	if err := ipc.Write(resp, conn); err != nil {
+		log.Errorf("could not send response: %v", err)
+	}
+}
+
 func initLogging(path string) {
-	logFile, err := os.OpenFile(
-		path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
+	if err := log.InitFile(path, true, runtime.GOOS != "windows"); err != nil {
 		log.Fatalf("Failed to open log file: %v", err)
 	}
-	log.Init(logFile, true, runtime.GOOS != "windows")
+}
+
+// handleHangup reopens the log file on SIGHUP, so that external log
+// rotation works, and reloads the config.
+func (d *daemon) handleHangup(hup <-chan os.Signal) {
+	for range hup {
+		log.Infof("Received SIGHUP, reopening log file and reloading config")
+		if err := log.Reopen(); err != nil {
+			log.Errorf("Could not reopen log file: %v", err)
+		}
+		if err := audit.Reopen(); err != nil {
+			log.Errorf("Could not reopen audit log: %v", err)
+		}
+		if err := d.Reload(); err != nil {
+			log.Errorf("Could not reload config: %v", err)
+		}
+	}
 }
 
 func listen() (l net.Listener, err error) {
@@ -270,9 +420,53 @@ func (d *daemon) stop() {
 	})
 }
 
+// loadConfig reads global settings from the config file. Tunnels are not
+// read here, as they are transmitted by the CLI. On failure, defaults are
+// returned along with the error.
+func loadConfig() (*config.Config, error) {
+	conf, err := config.Load()
+	if err != nil {
+		return &config.Config{}, err
+	}
+	return conf, nil
+}
+
 func Run() {
+	nActivated := tunnel.InheritSockets()
+	conf, confErr := loadConfig()
+	if conf.LogFile != "" && os.Getenv("BORING_LOG_FILE") == "" {
+		LogFile = conf.LogFile
+	}
+
+	if conf.PIDFile != "" && os.Getenv("BORING_PID_FILE") == "" {
+		PIDFile = conf.PIDFile
+	}
+
 	initLogging(LogFile)
 	log.Infof("Daemon starting")
+	if nActivated > 0 {
+		log.Infof("Inherited %d socket(s) from systemd", nActivated)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	pf, err := acquirePIDFile(PIDFile)
+	if err != nil {
+		log.Fatalf("Refusing to start: %v", err)
+	}
+	defer pf.release()
+
+	if errors.Is(confErr, fs.ErrNotExist) {
+		log.Debugf("No config file found, using defaults")
+	} else if confErr != nil {
+		log.Warningf("Could not load config, using defaults: %v", confErr)
+	}
+	applySettings(conf)
+	if conf.AuditLog != "" {
+		if err := audit.InitFile(conf.AuditLog); err != nil {
+			log.Errorf("Could not open audit log: %v", err)
+		}
+	}
 
 	ln, err := listen()
 	if err != nil {
@@ -285,6 +479,22 @@ func Run() {
 
 	d, cleanup := newDaemon(ctx, ln)
 	defer cleanup()
+	if conf.ShutdownTimeout > 0 {
+		d.shutdownTimeout = time.Duration(conf.ShutdownTimeout) * time.Second
+	}
+	go d.handleHangup(hup)
+	if conf.StateFile != "" {
+		d.statePath = conf.StateFile
+		go func() {
+			d.restoreState(conf)
+			d.persistState()
+		}()
+	}
+	if conf.MetricsListen != "" {
+		go d.serveMetrics(conf.MetricsListen)
+	}
+	d.setSchedules(conf)
+	go d.runSchedules()
 
 	d.serve()
 }
@@ -0,0 +1,97 @@
+package daemon
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+var statusNames = map[tunnel.Status]string{
+	tunnel.Closed: "closed",
+	tunnel.Open:   "open",
+	tunnel.Reconn: "reconn",
+	tunnel.Paused: "paused",
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// serveMetrics serves tunnel metrics in the Prometheus text format on addr,
+// until the daemon is stopped.
+func (d *daemon) serveMetrics(addr string) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("Could not serve metrics: %v", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		d.writeMetrics(w)
+	})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-d.ctx.Done()
+		srv.Close()
+	}()
+	log.Infof("Serving metrics on %v", ln.Addr())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("Could not serve metrics: %v", err)
+	}
+}
+
+func (d *daemon) writeMetrics(w io.Writer) {
+	type entry struct {
+		name   string
+		status tunnel.Status
+		stats  tunnel.Stats
+	}
+	d.mutex.RLock()
+	es := make([]entry, 0, len(d.tunnels))
+	for n, t := range d.tunnels {
+		es = append(es, entry{n, t.Status, t.Stats()})
+	}
+	failed := len(d.failed)
+	d.mutex.RUnlock()
+	sort.Slice(es, func(i, j int) bool { return es[i].name < es[j].name })
+
+	metric := func(name, typ, help string, value func(e entry) any) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		for _, e := range es {
+			fmt.Fprintf(w, "%s{tunnel=\"%s\"} %v\n", name, labelEscaper.Replace(e.name), value(e))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP boring_tunnel_state Current state of the tunnel.\n"+
+		"# TYPE boring_tunnel_state gauge\n")
+	for _, e := range es {
+		for _, s := range []tunnel.Status{tunnel.Open, tunnel.Reconn, tunnel.Paused} {
+			v := 0
+			if e.status == s {
+				v = 1
+			}
+			fmt.Fprintf(w, "boring_tunnel_state{tunnel=\"%s\",state=\"%s\"} %d\n",
+				labelEscaper.Replace(e.name), statusNames[s], v)
+		}
+	}
+	metric("boring_tunnel_bytes_in_total", "counter",
+		"Bytes received from clients of the tunnel.",
+		func(e entry) any { return e.stats.BytesIn })
+	metric("boring_tunnel_bytes_out_total", "counter",
+		"Bytes sent to clients of the tunnel.",
+		func(e entry) any { return e.stats.BytesOut })
+	metric("boring_tunnel_active_conns", "gauge",
+		"Connections currently forwarded by the tunnel.",
+		func(e entry) any { return e.stats.ActiveConns })
+	metric("boring_tunnel_reconnects_total", "counter",
+		"Successful re-connects of the tunnel.",
+		func(e entry) any { return e.stats.Reconnects })
+	fmt.Fprintf(w, "# HELP boring_tunnels_failed Tunnels that failed to open or re-connect.\n"+
+		"# TYPE boring_tunnels_failed gauge\nboring_tunnels_failed %d\n", failed)
+}
@@ -0,0 +1,101 @@
+package daemon
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	d := &daemon{
+		tunnels: map[string]*tunnel.Tunnel{
+			"b":     tunnel.FromDesc(&tunnel.Desc{Name: "b", Status: tunnel.Reconn}),
+			`a"db"`: tunnel.FromDesc(&tunnel.Desc{Name: `a"db"`, Status: tunnel.Open}),
+		},
+		failed: map[string]bool{"c": true},
+	}
+	var sb strings.Builder
+	d.writeMetrics(&sb)
+	out := sb.String()
+
+	for _, want := range []string{
+		`boring_tunnel_state{tunnel="a\"db\"",state="open"} 1`,
+		`boring_tunnel_state{tunnel="b",state="open"} 0`,
+		`boring_tunnel_state{tunnel="b",state="reconn"} 1`,
+		"# TYPE boring_tunnel_bytes_in_total counter",
+		`boring_tunnel_bytes_out_total{tunnel="b"} 0`,
+		`boring_tunnel_active_conns{tunnel="b"} 0`,
+		`boring_tunnel_reconnects_total{tunnel="b"} 0`,
+		"boring_tunnels_failed 1",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q in:\n%s", want, out)
+		}
+	}
+	if strings.Index(out, `tunnel="a\"db\""`) > strings.Index(out, `tunnel="b"`) {
+		t.Error("tunnels not sorted by name")
+	}
+}
+
+var (
+	metricComment = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.+)$`)
+	metricSample  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)` +
+		`(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"` +
+		`(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*")*\})? (\S+)$`)
+)
+
+// TestWriteMetricsFormat checks the output against the text exposition
+// format: every line is a comment or a sample, each family is typed once
+// before its samples, and the samples of a family are contiguous.
+func TestWriteMetricsFormat(t *testing.T) {
+	d := &daemon{
+		tunnels: map[string]*tunnel.Tunnel{
+			"a\\b\nc": tunnel.FromDesc(&tunnel.Desc{Name: "a\\b\nc", Status: tunnel.Paused}),
+			`d"e`:     tunnel.FromDesc(&tunnel.Desc{Name: `d"e`, Status: tunnel.Open}),
+		},
+		failed: map[string]bool{},
+	}
+	var sb strings.Builder
+	d.writeMetrics(&sb)
+	out := sb.String()
+	if !strings.HasSuffix(out, "\n") {
+		t.Fatal("output does not end with a newline")
+	}
+
+	typed := map[string]bool{}
+	done := map[string]bool{}
+	var current string
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		if m := metricComment.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				if typed[m[2]] {
+					t.Errorf("family %v typed twice", m[2])
+				}
+				typed[m[2]] = true
+			}
+			continue
+		}
+		m := metricSample.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("malformed line %q", line)
+			continue
+		}
+		if !typed[m[1]] {
+			t.Errorf("sample of %v before its type", m[1])
+		}
+		if m[1] != current {
+			if done[m[1]] {
+				t.Errorf("samples of %v not contiguous", m[1])
+			}
+			done[current], current = true, m[1]
+		}
+		if m[3] != "0" && m[3] != "1" {
+			t.Errorf("unexpected value in %q", line)
+		}
+	}
+	if len(typed) != 6 {
+		t.Errorf("got %d families, want 6", len(typed))
+	}
+}
@@ -0,0 +1,58 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// pidFile is an exclusively locked file holding the daemon's PID. The
+// lock is what prevents a second daemon from starting; it is released by
+// the OS if the process dies, so stale files do not block startup.
+type pidFile struct {
+	path string
+	f    *os.File
+}
+
+// acquirePIDFile locks the file at path and writes the current PID to it.
+// If another process holds the lock, an error naming its PID is returned.
+func acquirePIDFile(path string) (*pidFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		b, _ := io.ReadAll(f)
+		f.Close()
+		pid := strings.TrimSpace(string(b))
+		if pid == "" {
+			pid = "unknown"
+		}
+		return nil, fmt.Errorf("another daemon (PID %s) holds the lock on %s", pid, path)
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &pidFile{path: path, f: f}, nil
+}
+
+func (p *pidFile) release() {
+	// Remove while still holding the lock, so we can't delete the file of
+	// a successor. Windows does not allow this, so retry after closing.
+	err := os.Remove(p.path)
+	p.f.Close()
+	if err != nil {
+		os.Remove(p.path)
+	}
+}
@@ -0,0 +1,44 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPIDFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "boringd.pid")
+	p, err := acquirePIDFile(path)
+	if err != nil {
+		t.Fatalf("could not acquire: %v", err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strconv.Itoa(os.Getpid())
+	if strings.TrimSpace(string(b)) != pid {
+		t.Errorf("got PID %q, want %q", b, pid)
+	}
+
+	// Second acquisition must fail while the lock is held
+	_, err = acquirePIDFile(path)
+	if err == nil || !strings.Contains(err.Error(), "PID "+pid) {
+		t.Errorf("expected lock error naming PID, got %v", err)
+	}
+
+	p.release()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file not removed on release: %v", err)
+	}
+
+	// Can be acquired again after release
+	p, err = acquirePIDFile(path)
+	if err != nil {
+		t.Fatalf("could not re-acquire: %v", err)
+	}
+	p.release()
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package daemon
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+func lockFile(f *os.File) error {
+	// Lock a byte far beyond the content, so that others can still read
+	// the PID from the file.
+	ol := &windows.Overlapped{OffsetHigh: 0x7fffffff}
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol)
+}
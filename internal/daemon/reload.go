@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// applySettings applies the daemon-level settings of conf
+func applySettings(conf *config.Config) {
+	window := time.Duration(conf.LogSampleWindow) * time.Second
+	log.SetSampling(window, conf.LogSampleThreshold)
+	log.SetRotation(conf.LogMaxFiles, conf.LogCompress)
+	maxLine := log.DefaultMaxLineLength
+	if conf.LogMaxLineLength != nil {
+		maxLine = *conf.LogMaxLineLength
+	}
+	log.SetMaxLineLength(maxLine)
+	ssh_config.SetDefaultIdentityFiles(conf.DefaultIdentityFiles)
+	ssh_config.SetNewHostsFile(conf.KnownHostsFile)
+	tunnel.SetConnectLimit(conf.ConnectLimit,
+		time.Duration(conf.ConnectLimitWindow)*time.Second)
+}
+
+// Reload re-reads the config file and applies its daemon-level settings.
+// Running tunnels whose configuration changed are restarted with the new
+// one, others are left untouched, keeping their counters. Tunnels not in
+// the config, e.g., opened from JSON, are not affected. The log and PID
+// file locations are only read at startup.
+func (d *daemon) Reload() error {
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+	applySettings(conf)
+	d.setSchedules(conf)
+
+	d.mutex.RLock()
+	var changed []*tunnel.Tunnel
+	for name, t := range d.tunnels {
+		if c, ok := conf.TunnelsMap[name]; ok && !reflect.DeepEqual(runConfig(t.Desc), runConfig(c)) {
+			changed = append(changed, t)
+		}
+	}
+	d.mutex.RUnlock()
+
+	for _, t := range changed {
+		d.restart(t, conf.TunnelsMap[t.Name])
+	}
+	log.Infof("Reloaded config, %d tunnel(s) restarted", len(changed))
+	return nil
+}
+
+// restart closes t and opens desc in its place
+func (d *daemon) restart(t *tunnel.Tunnel, desc *tunnel.Desc) {
+	log.With(t.Name).Infof("Config changed, restarting")
+	d.mutex.Lock()
+	d.closing[t.Name] = true
+	d.mutex.Unlock()
+	if err := t.Close(); err != nil {
+		log.With(t.Name).Errorf("could not close tunnel: %v", err)
+		return
+	}
+	<-t.Closed
+
+	d.mutex.Lock()
+	if d.tunnels[t.Name] == t {
+		delete(d.tunnels, t.Name)
+	}
+	d.mutex.Unlock()
+	d.open(desc)
+}
+
+// runConfig returns what determines how a tunnel is run, i.e., desc
+// without runtime state.
+func runConfig(desc *tunnel.Desc) tunnel.Desc {
+	c := *desc
+	c.Status = tunnel.Closed
+	c.LastConn = time.Time{}
+	c.Bound = nil
+	c.Bastion = ""
+	c.Server = ""
+	return c
+}
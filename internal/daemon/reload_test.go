@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+func init() {
+	log.Init(io.Discard, false, false)
+}
+
+func TestReloadKeepsUnchanged(t *testing.T) {
+	old := config.Path
+	config.Path = filepath.Join(t.TempDir(), "config.toml")
+	t.Cleanup(func() { config.Path = old })
+	conf := "[[tunnels]]\nname = \"a\"\nhost = \"example.com\"\nlocal = [\"9000\", \"9001\"]\n" +
+		"remote = \"localhost:80\"\nlocal_allow = [\"10.0.0.0/8\"]\n"
+	if err := os.WriteFile(config.Path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Like a tunnel opened by the CLI, whose description was sent as JSON
+	b, err := json.Marshal(c.TunnelsMap["a"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var desc tunnel.Desc
+	if err := json.Unmarshal(b, &desc); err != nil {
+		t.Fatal(err)
+	}
+	desc.Status = tunnel.Open
+	desc.LastConn = time.Now()
+	desc.Bound = []string{"127.0.0.1:9000", "127.0.0.1:9001"}
+	desc.Server = "OpenSSH_9.6"
+	tun := tunnel.FromDesc(&desc)
+
+	d := &daemon{tunnels: map[string]*tunnel.Tunnel{"a": tun}, closing: map[string]bool{}}
+	if err := d.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if d.tunnels["a"] != tun {
+		t.Error("unchanged tunnel was replaced")
+	}
+}
@@ -1,6 +1,8 @@
 package daemon
 
 import (
+	"time"
+
 	"github.com/alebeck/boring/internal/tunnel"
 )
 
@@ -16,4 +18,22 @@ type Resp struct {
 	Error   string                 `json:"error,omitempty"`
 	Tunnels map[string]tunnel.Desc `json:"tunnels,omitempty"`
 	Info    Info                   `json:"info,omitempty"`
+	Health  *HealthInfo            `json:"health,omitempty"`
+	Conns   []tunnel.Conn          `json:"conns,omitempty"`
+}
+
+// HealthInfo summarizes the state of the daemon and its tunnels, as opposed
+// to the state of individual tunnels.
+type HealthInfo struct {
+	Version string        `json:"version"`
+	Uptime  time.Duration `json:"uptime"`
+	// Configured is the number of tunnels in the config file, or -1
+	// if the config file could not be read.
+	Configured int `json:"configured"`
+	Open       int `json:"open"`
+	Reconn     int `json:"reconn"`
+	Paused     int `json:"paused"`
+	// Failed counts tunnels whose last opening or re-connection attempt
+	// failed, and which have not been opened since.
+	Failed int `json:"failed"`
 }
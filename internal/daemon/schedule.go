@@ -0,0 +1,143 @@
+package daemon
+
+import (
+	"errors"
+	"time"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// scheduleInterval is how often schedules are evaluated
+const scheduleInterval = 30 * time.Second
+
+// Opening a tunnel on schedule is retried with backoff between these,
+// replaceable in tests
+var (
+	scheduleRetryWait    = 5 * time.Second
+	scheduleRetryMaxWait = 5 * time.Minute
+)
+
+// scheduled is a tunnel opened and closed according to its schedule
+type scheduled struct {
+	desc  *tunnel.Desc
+	sched *tunnel.Schedule
+	// active is whether the schedule was active when last evaluated, which
+	// is unknown before the first evaluation
+	active, known bool
+}
+
+// setSchedules takes the tunnels with a schedule from conf. The last
+// evaluation is kept for tunnels whose schedule did not change, such that
+// a reload does not re-open tunnels closed manually.
+func (d *daemon) setSchedules(conf *config.Config) {
+	d.schedMu.Lock()
+	defer d.schedMu.Unlock()
+	prev := d.schedules
+	d.schedules = make(map[string]*scheduled)
+	for name, desc := range conf.TunnelsMap {
+		sched, err := tunnel.ParseSchedule(desc.Schedule)
+		if err != nil || sched == nil {
+			continue
+		}
+		s := &scheduled{desc: desc, sched: sched}
+		if p, ok := prev[name]; ok && p.desc.Schedule == desc.Schedule {
+			s.active, s.known = p.active, p.known
+		}
+		d.schedules[name] = s
+	}
+}
+
+// runSchedules opens tunnels when their schedule's window begins, or when
+// the daemon starts within it, and closes them when it ends. In between,
+// tunnels can still be opened and closed manually.
+func (d *daemon) runSchedules() {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		d.applySchedules(time.Now())
+		select {
+		case <-ticker.C:
+		case <-d.ctx.Done():
+			return
+		}
+	}
+}
+
+func (d *daemon) applySchedules(now time.Time) {
+	var toOpen, toClose []*tunnel.Desc
+	d.schedMu.Lock()
+	for _, s := range d.schedules {
+		active := s.sched.Active(now)
+		if s.known && active == s.active {
+			continue
+		}
+		s.active, s.known = active, true
+		if active {
+			toOpen = append(toOpen, s.desc)
+		} else {
+			toClose = append(toClose, s.desc)
+		}
+	}
+	d.schedMu.Unlock()
+
+	for _, desc := range toOpen {
+		if d.running(desc.Name) {
+			continue
+		}
+		log.With(desc.Name).Infof("Opening tunnel on schedule")
+		go d.openScheduled(desc.Name)
+	}
+	for _, desc := range toClose {
+		if !d.running(desc.Name) {
+			continue
+		}
+		log.With(desc.Name).Infof("Closing tunnel on schedule")
+		go d.close(desc.Name)
+	}
+}
+
+// openScheduled opens the tunnel name on schedule. Failures, e.g. as the
+// network is not up yet when the window begins, are retried with backoff
+// as long as the window lasts.
+func (d *daemon) openScheduled(name string) {
+	wait := scheduleRetryWait
+	for {
+		desc := d.activeScheduled(name)
+		if desc == nil {
+			return
+		}
+		err := d.open(desc)
+		if err == nil || errors.Is(err, AlreadyRunning) {
+			return
+		}
+		log.With(name).Infof("Retrying to open on schedule in %v", wait)
+		select {
+		case <-time.After(wait):
+		case <-d.ctx.Done():
+			return
+		}
+		wait = min(2*wait, scheduleRetryMaxWait)
+	}
+}
+
+// activeScheduled returns the description of the tunnel name if its
+// schedule is active, or nil otherwise. Descriptions are looked up anew
+// each time, as reloads may change them.
+func (d *daemon) activeScheduled(name string) *tunnel.Desc {
+	d.schedMu.Lock()
+	defer d.schedMu.Unlock()
+	s, ok := d.schedules[name]
+	if !ok || !s.sched.Active(time.Now()) {
+		return nil
+	}
+	return s.desc
+}
+
+func (d *daemon) running(name string) bool {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	_, ok := d.tunnels[name]
+	return ok
+}
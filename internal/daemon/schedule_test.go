@@ -0,0 +1,79 @@
+package daemon
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+// lockedBuffer is a strings.Builder safe for concurrent use
+type lockedBuffer struct {
+	mu sync.Mutex
+	b  strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b.String()
+}
+
+func TestOpenScheduledRetries(t *testing.T) {
+	var out lockedBuffer
+	log.Init(&out, true, false)
+	t.Cleanup(func() { log.Init(io.Discard, false, false) })
+	oldWait := scheduleRetryWait
+	scheduleRetryWait = 10 * time.Millisecond
+	t.Cleanup(func() { scheduleRetryWait = oldWait })
+
+	// Active, but the tunnel cannot be opened
+	now := time.Now()
+	sched, err := tunnel.ParseSchedule(now.Add(-time.Hour).Format("15:04") + "-" +
+		now.Add(time.Hour).Format("15:04"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d := &daemon{
+		ctx:       ctx,
+		tunnels:   map[string]*tunnel.Tunnel{},
+		failed:    map[string]bool{},
+		closing:   map[string]bool{},
+		schedules: map[string]*scheduled{"a": {desc: &tunnel.Desc{Name: "a"}, sched: sched}},
+	}
+	done := make(chan struct{})
+	go func() {
+		d.openScheduled("a")
+		close(done)
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); strings.Count(out.String(), "Retrying") < 2; {
+		if time.Now().After(deadline) {
+			t.Fatalf("open not retried: %q", out.String())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Retrying stops once the tunnel is no longer scheduled
+	d.schedMu.Lock()
+	delete(d.schedules, "a")
+	d.schedMu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("retrying did not stop")
+	}
+}
@@ -0,0 +1,66 @@
+package daemon
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+const (
+	defaultShutdownTimeout = 10 * time.Second
+	// abortGrace is how long a tunnel is waited for after force-closing it
+	abortGrace = time.Second
+)
+
+// closeAll closes all running tunnels in parallel. Tunnels that did not
+// finish closing once ctx is done are force-closed. The returned error
+// combines those of all tunnels.
+func (d *daemon) closeAll(ctx context.Context) error {
+	d.mutex.Lock()
+	ts := make([]*tunnel.Tunnel, 0, len(d.tunnels))
+	closing := make(map[*tunnel.Tunnel]bool)
+	for name, t := range d.tunnels {
+		ts = append(ts, t)
+		// Already being closed on request, only wait for those
+		closing[t] = d.closing[name]
+	}
+	d.mutex.Unlock()
+
+	errs := make([]error, len(ts))
+	var wg sync.WaitGroup
+	for i, t := range ts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = closeWithin(ctx, t, closing[t])
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// closeWithin closes t and waits for it until ctx is done, then aborts it
+func closeWithin(ctx context.Context, t *tunnel.Tunnel, closing bool) error {
+	if !closing {
+		if err := t.Close(); err != nil {
+			return fmt.Errorf("%v: %v", t.Name, err)
+		}
+	}
+	select {
+	case <-t.Closed:
+		return nil
+	case <-ctx.Done():
+	}
+
+	t.Abort()
+	select {
+	case <-t.Closed:
+		return fmt.Errorf("%v: forced to close after %v", t.Name, context.Cause(ctx))
+	case <-time.After(abortGrace):
+		return fmt.Errorf("%v: did not close", t.Name)
+	}
+}
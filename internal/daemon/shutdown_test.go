@@ -0,0 +1,33 @@
+package daemon
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+func TestCloseAllErrors(t *testing.T) {
+	d := &daemon{
+		tunnels: map[string]*tunnel.Tunnel{
+			"a": tunnel.FromDesc(&tunnel.Desc{Name: "a", Status: tunnel.Closed}),
+			"b": tunnel.FromDesc(&tunnel.Desc{Name: "b", Status: tunnel.Closed}),
+		},
+		closing: map[string]bool{},
+	}
+	err := d.closeAll(context.Background())
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, name := range []string{"a: ", "b: "} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("missing %q in %q", name, err)
+		}
+	}
+
+	d.tunnels = map[string]*tunnel.Tunnel{}
+	if err := d.closeAll(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,165 @@
+package daemon
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/alebeck/boring/internal/config"
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+const stateInterval = 30 * time.Second
+
+const (
+	stateRunning = "running"
+	statePaused  = "paused"
+	stateFailed  = "failed"
+)
+
+// tunnelState is what is kept of a tunnel across daemon restarts
+type tunnelState struct {
+	State      string `json:"state"`
+	BytesIn    uint64 `json:"bytes_in"`
+	BytesOut   uint64 `json:"bytes_out"`
+	Reconnects uint64 `json:"reconnects"`
+}
+
+// snapshotState returns the state of all running and failed tunnels
+func (d *daemon) snapshotState() map[string]tunnelState {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	s := make(map[string]tunnelState, len(d.tunnels)+len(d.failed))
+	for name := range d.failed {
+		s[name] = tunnelState{State: stateFailed}
+	}
+	for name, t := range d.tunnels {
+		st := t.Stats()
+		ts := tunnelState{State: stateRunning, BytesIn: st.BytesIn,
+			BytesOut: st.BytesOut, Reconnects: st.Reconnects}
+		if t.Status == tunnel.Paused {
+			ts.State = statePaused
+		}
+		s[name] = ts
+	}
+	return s
+}
+
+// saveState writes the state of all tunnels to the state file, if set
+func (d *daemon) saveState() {
+	if d.statePath == "" {
+		return
+	}
+	if err := writeState(d.statePath, d.snapshotState()); err != nil {
+		log.Errorf("Could not save state: %v", err)
+	}
+}
+
+// persistState saves the state periodically until the daemon stops
+func (d *daemon) persistState() {
+	tick := time.NewTicker(stateInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-d.ctx.Done():
+			return
+		case <-tick.C:
+			d.saveState()
+		}
+	}
+}
+
+// writeState replaces the file at path atomically, such that a crash
+// never leaves a partially written state behind.
+func writeState(path string, s map[string]tunnelState) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	// Flush to disk before the rename, which may otherwise be persisted
+	// ahead of the data
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func readState(path string) (map[string]tunnelState, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s map[string]tunnelState
+	if err = json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// restoreState brings tunnels back into the state saved by a previous
+// daemon. Tunnels are looked up in the config by name, so tunnels opened
+// from JSON are not restored.
+func (d *daemon) restoreState(conf *config.Config) {
+	s, err := readState(d.statePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return
+	} else if err != nil {
+		log.Warningf("Could not read state, not restoring tunnels: %v", err)
+		return
+	}
+	log.Infof("Restoring %d tunnel(s) from %v", len(s), d.statePath)
+
+	for name, ts := range s {
+		desc, ok := conf.TunnelsMap[name]
+		if !ok {
+			log.With(name).Warningf("not in config, not restoring")
+			continue
+		}
+		if desc.Schedule != "" {
+			// Whether it is open is up to the schedule
+			continue
+		}
+		if ts.State == stateFailed {
+			d.mutex.Lock()
+			d.failed[name] = true
+			d.mutex.Unlock()
+			continue
+		}
+		if err := d.open(desc); err != nil {
+			continue
+		}
+		d.mutex.RLock()
+		t := d.tunnels[name]
+		d.mutex.RUnlock()
+		if t == nil {
+			continue
+		}
+		t.RestoreStats(tunnel.Stats{BytesIn: ts.BytesIn, BytesOut: ts.BytesOut,
+			Reconnects: ts.Reconnects})
+		if ts.State == statePaused {
+			if err := t.Pause(false); err != nil {
+				log.With(name).Errorf("could not pause tunnel: %v", err)
+			}
+		}
+	}
+}
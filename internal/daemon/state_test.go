@@ -0,0 +1,46 @@
+package daemon
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/alebeck/boring/internal/tunnel"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	paused := tunnel.FromDesc(&tunnel.Desc{Name: "p", Status: tunnel.Paused})
+	paused.RestoreStats(tunnel.Stats{BytesIn: 10, BytesOut: 20, Reconnects: 3})
+	d := &daemon{
+		tunnels: map[string]*tunnel.Tunnel{
+			"o": tunnel.FromDesc(&tunnel.Desc{Name: "o", Status: tunnel.Open}),
+			"p": paused,
+		},
+		failed:    map[string]bool{"f": true},
+		statePath: filepath.Join(t.TempDir(), "sub", "state.json"),
+	}
+	d.saveState()
+
+	got, err := readState(d.statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]tunnelState{
+		"o": {State: stateRunning},
+		"p": {State: statePaused, BytesIn: 10, BytesOut: 20, Reconnects: 3},
+		"f": {State: stateFailed},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	// No temporary files are left behind
+	entries, err := os.ReadDir(filepath.Dir(d.statePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("got %d files in state dir, want 1", len(entries))
+	}
+}
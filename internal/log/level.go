@@ -0,0 +1,71 @@
+package log
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Level is the minimum severity of messages that are logged
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var (
+	levels   map[string]Level
+	levelsMu sync.RWMutex
+)
+
+// ParseLevel parses one of "debug", "info", "warning" or "error"
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "info":
+		return LevelInfo, nil
+	case "warning", "warn":
+		return LevelWarning, nil
+	case "error":
+		return LevelError, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// SetLevel sets the level of messages logged via With(name), taking
+// precedence over the global level. A nil level restores the global one.
+func SetLevel(name string, level *Level) {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+	if level == nil {
+		delete(levels, name)
+		return
+	}
+	if levels == nil {
+		levels = make(map[string]Level)
+	}
+	levels[name] = *level
+}
+
+// enabled reports whether a message of level lvl tagged with name is logged
+func (l *logger) enabled(name string, lvl Level) bool {
+	if !l.interactive {
+		return false
+	}
+	min := LevelInfo
+	if l.debug {
+		min = LevelDebug
+	}
+	if name != "" {
+		levelsMu.RLock()
+		if nl, ok := levels[name]; ok {
+			min = nl
+		}
+		levelsMu.RUnlock()
+	}
+	return lvl >= min
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 )
@@ -19,15 +20,24 @@ var (
 // logger wraps an io.Writer, and implements locking and rotation
 type logger struct {
 	writer io.Writer
-	mutex  sync.Mutex
-	debug  bool
+	// path of the log file, if opened via InitFile
+	path  string
+	mutex sync.Mutex
+	debug bool
 	// whether to output "interactive" messages like infos, warnings and errors
 	interactive bool
+	// sampler coalesces repeated messages if set, guarded by mutex
+	sampler *sampler
+	// maxFiles is the number of rotated files kept, see SetRotation
+	maxFiles int
+	compress bool
+	// maxLine is the length messages are truncated to, see SetMaxLineLength
+	maxLine int
 }
 
 func Init(w io.Writer, interactive bool, colors bool) {
 	debug := os.Getenv("DEBUG") != ""
-	instance = &logger{writer: w, debug: debug, interactive: interactive}
+	instance = &logger{writer: w, debug: debug, interactive: interactive, maxLine: DefaultMaxLineLength}
 	if colors {
 		Reset = "\033[0m"
 		Bold = "\033[1m"
@@ -38,6 +48,45 @@ func Init(w io.Writer, interactive bool, colors bool) {
 	}
 }
 
+// InitFile initializes logging to the file at path. The file and its parent
+// directories are created if needed, otherwise the file is appended to.
+func InitFile(path string, interactive bool, colors bool) error {
+	f, err := openFile(path)
+	if err != nil {
+		return err
+	}
+	Init(f, interactive, colors)
+	instance.path = path
+	return nil
+}
+
+// Reopen reopens the log file opened via InitFile, e.g., after it has been
+// moved away by an external log rotation tool.
+func Reopen() error {
+	if instance.path == "" {
+		return nil
+	}
+	f, err := openFile(instance.path)
+	if err != nil {
+		return err
+	}
+	instance.mutex.Lock()
+	old := instance.writer
+	instance.writer = f
+	instance.mutex.Unlock()
+	if c, ok := old.(io.Closer); ok {
+		c.Close()
+	}
+	return nil
+}
+
+func openFile(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+}
+
 // Write implements io.Writer, locking and rotating as needed
 func (l *logger) Write(bytes []byte) (int, error) {
 	l.mutex.Lock()
@@ -46,6 +95,52 @@ func (l *logger) Write(bytes []byte) (int, error) {
 	return l.writer.Write(bytes)
 }
 
+// SetSampling enables coalescing of identical messages: within window, only
+// the first threshold occurrences of a message are logged, further ones are
+// summarized once the window has passed. A zero window disables sampling.
+func SetSampling(window time.Duration, threshold int) {
+	var s *sampler
+	if window > 0 {
+		s = newSampler(window, max(threshold, 1))
+		s.flush = instance.writeRepeated
+	}
+	instance.mutex.Lock()
+	old := instance.sampler
+	instance.sampler = s
+	instance.mutex.Unlock()
+	if old != nil {
+		old.stop()
+	}
+}
+
+// writeRepeated logs summaries of suppressed messages
+func (l *logger) writeRepeated(reps []repeated) {
+	for _, r := range reps {
+		fmt.Fprintf(l, "%s %s %s (repeated %d times)\n",
+			timestamp(), r.label, r.message, r.n)
+	}
+}
+
+// logf writes a message with the given level label and name tag, subject
+// to truncation and sampling
+func (l *logger) logf(label, name, message string) {
+	l.mutex.Lock()
+	message = truncate(message, l.maxLine)
+	s := l.sampler
+	l.mutex.Unlock()
+	if name != "" {
+		message = "[" + name + "] " + message
+	}
+	if s != nil {
+		ok, reps := s.check(label, message, time.Now())
+		l.writeRepeated(reps)
+		if !ok {
+			return
+		}
+	}
+	fmt.Fprintf(l, "%s %s %s\n", timestamp(), label, message)
+}
+
 func (l *logger) tryRotate() {
 	f, ok := l.writer.(*os.File)
 	if !ok {
@@ -60,6 +155,10 @@ func (l *logger) tryRotate() {
 		// Not ripe for rotation
 		return
 	}
+	if l.maxFiles > 0 && l.path != "" {
+		l.rotateFile(f)
+		return
+	}
 	if f.Truncate(0) == nil {
 		f.Seek(0, 0)
 	}
@@ -75,40 +174,80 @@ func timestamp() string {
 }
 
 func Debugf(format string, a ...any) {
-	if !instance.debug || !instance.interactive {
+	debugf("", format, a...)
+}
+
+func Infof(format string, a ...any) {
+	infof("", format, a...)
+}
+
+func Warningf(format string, a ...any) {
+	warningf("", format, a...)
+}
+
+func Errorf(format string, a ...any) {
+	errorf("", format, a...)
+}
+
+func debugf(name, format string, a ...any) {
+	if !instance.enabled(name, LevelDebug) {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s DEBUG %s\n", timestamp(), message)
+	instance.logf("DEBUG", name, fmt.Sprintf(format, a...))
 }
 
-func Infof(format string, a ...any) {
-	if !instance.interactive {
+func infof(name, format string, a ...any) {
+	if !instance.enabled(name, LevelInfo) {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s %sINFO%s %s\n", timestamp(), Bold+Blue, Reset, message)
+	instance.logf(Bold+Blue+"INFO"+Reset, name, fmt.Sprintf(format, a...))
 }
 
-func Warningf(format string, a ...any) {
-	if !instance.interactive {
+func warningf(name, format string, a ...any) {
+	if !instance.enabled(name, LevelWarning) {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s %sWARNING%s %s\n", timestamp(), Bold+Yellow, Reset, message)
+	instance.logf(Bold+Yellow+"WARNING"+Reset, name, fmt.Sprintf(format, a...))
 }
 
-func Errorf(format string, a ...any) {
-	if !instance.interactive {
+func errorf(name, format string, a ...any) {
+	if !instance.enabled(name, LevelError) {
 		return
 	}
-	message := fmt.Sprintf(format, a...)
-	fmt.Fprintf(instance, "%s %sERROR%s %s\n", timestamp(), Bold+Red, Reset, message)
+	instance.logf(Bold+Red+"ERROR"+Reset, name, fmt.Sprintf(format, a...))
+}
+
+// Logger tags all messages logged through it with a name, e.g., the name
+// of a tunnel, such that interleaved messages can be told apart.
+type Logger struct {
+	name string
+}
+
+// With returns a Logger tagging messages with name. In text output,
+// messages are prefixed with "[name]".
+func With(name string) *Logger {
+	return &Logger{name: name}
+}
+
+func (l *Logger) Debugf(format string, a ...any) {
+	debugf(l.name, format, a...)
+}
+
+func (l *Logger) Infof(format string, a ...any) {
+	infof(l.name, format, a...)
+}
+
+func (l *Logger) Warningf(format string, a ...any) {
+	warningf(l.name, format, a...)
+}
+
+func (l *Logger) Errorf(format string, a ...any) {
+	errorf(l.name, format, a...)
 }
 
 func Fatalf(format string, a ...any) {
 	if instance.interactive {
-		message := fmt.Sprintf(format, a...)
+		message := truncate(fmt.Sprintf(format, a...), instance.maxLine)
 		fmt.Fprintf(instance, "%s %sFATAL%s %s\n", timestamp(), Bold+Red, Reset, message)
 	}
 	os.Exit(1)
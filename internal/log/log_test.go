@@ -0,0 +1,147 @@
+package log
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "boringd.log")
+	if err := InitFile(path, true, false); err != nil {
+		t.Fatal(err)
+	}
+
+	Infof("before")
+	rotated := filepath.Join(dir, "boringd.log.1")
+	if err := os.Rename(path, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if err := Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	Infof("after")
+
+	old, err := os.ReadFile(rotated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cur, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(old), "before") || strings.Contains(string(old), "after") {
+		t.Errorf("unexpected rotated content: %q", old)
+	}
+	if !strings.Contains(string(cur), "after") || strings.Contains(string(cur), "before") {
+		t.Errorf("unexpected current content: %q", cur)
+	}
+}
+
+func TestWith(t *testing.T) {
+	var b strings.Builder
+	Init(&b, true, false)
+
+	With("dev").Infof("connected to %v", "host")
+	Infof("untagged")
+
+	out := b.String()
+	if !strings.Contains(out, "INFO [dev] connected to host\n") {
+		t.Errorf("missing tagged message: %q", out)
+	}
+	if !strings.Contains(out, "INFO untagged\n") {
+		t.Errorf("missing untagged message: %q", out)
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	t.Setenv("DEBUG", "")
+	var b strings.Builder
+	Init(&b, true, false)
+
+	debug, warn := LevelDebug, LevelWarning
+	SetLevel("verbose", &debug)
+	SetLevel("quiet", &warn)
+	t.Cleanup(func() {
+		SetLevel("verbose", nil)
+		SetLevel("quiet", nil)
+	})
+
+	With("verbose").Debugf("shown")
+	With("quiet").Infof("hidden")
+	With("quiet").Warningf("shown")
+	With("other").Debugf("hidden")
+	Debugf("hidden")
+
+	out := b.String()
+	if strings.Contains(out, "hidden") {
+		t.Errorf("unexpected message: %q", out)
+	}
+	for _, want := range []string{"DEBUG [verbose] shown", "WARNING [quiet] shown"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("missing %q: %q", want, out)
+		}
+	}
+
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestRotateCompress(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "boringd.log")
+	if err := InitFile(path, true, false); err != nil {
+		t.Fatal(err)
+	}
+	SetRotation(2, true)
+	t.Cleanup(func() { SetRotation(0, false) })
+
+	line := strings.Repeat("x", 1024)
+	for i := 0; i < 4*maxFileSize/len(line); i++ {
+		Infof("%v", line)
+	}
+	compressing.Wait()
+
+	for _, name := range []string{path, path + ".1.gz", path + ".2.gz"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("expected %v: %v", name, err)
+		}
+	}
+	for _, name := range []string{path + ".1", path + ".2", path + ".3", path + ".3.gz"} {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("unexpected %v", name)
+		}
+	}
+}
+
+func TestMaxLineLength(t *testing.T) {
+	var b strings.Builder
+	Init(&b, true, false)
+	SetMaxLineLength(10)
+
+	Errorf("%s", strings.Repeat("x", 25))
+	Infof("short")
+	// Multi-byte characters are not split
+	Infof("%s", strings.Repeat("ä", 6))
+
+	out := b.String()
+	if !strings.Contains(out, "ERROR xxxxxxxxxx...(truncated 15 bytes)\n") {
+		t.Errorf("missing truncated message: %q", out)
+	}
+	if !strings.Contains(out, "INFO short\n") {
+		t.Errorf("missing short message: %q", out)
+	}
+	if !strings.Contains(out, "INFO äääää...(truncated 2 bytes)\n") {
+		t.Errorf("missing truncated multi-byte message: %q", out)
+	}
+
+	b.Reset()
+	SetMaxLineLength(0)
+	Infof("%s", strings.Repeat("y", 100))
+	if strings.Contains(b.String(), "truncated") {
+		t.Errorf("unexpected truncation: %q", b.String())
+	}
+}
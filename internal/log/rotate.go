@@ -0,0 +1,160 @@
+package log
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+var (
+	// filesMu guards rotated files, which are shifted while rotating and
+	// replaced by compressed ones in the background
+	filesMu sync.Mutex
+	// rotations counts rotations, to tell how far files compressed in the
+	// meantime have been shifted
+	rotations int
+	// compressMu serializes background compression
+	compressMu sync.Mutex
+	// compressing tracks background compression, for tests
+	compressing sync.WaitGroup
+)
+
+// SetRotation makes a full log file be moved to <file>.1, shifting older
+// ones, and keeps at most maxFiles rotated files. If compress is set, they
+// are gzipped in the background. With maxFiles 0, the log file is
+// truncated instead.
+func SetRotation(maxFiles int, compress bool) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.maxFiles = max(maxFiles, 0)
+	instance.compress = compress
+}
+
+// rotatedName returns the name of the n-th rotated file, without the
+// suffix of compressed ones
+func rotatedName(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// rotateFile replaces the full log file f by a new one, keeping f as the
+// first rotated file
+func (l *logger) rotateFile(f *os.File) {
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	f.Close()
+	for _, ext := range []string{"", ".gz"} {
+		os.Remove(rotatedName(l.path, l.maxFiles) + ext)
+	}
+	for n := l.maxFiles - 1; n >= 1; n-- {
+		for _, ext := range []string{"", ".gz"} {
+			os.Rename(rotatedName(l.path, n)+ext, rotatedName(l.path, n+1)+ext)
+		}
+	}
+	os.Rename(l.path, rotatedName(l.path, 1))
+	rotations++
+
+	nf, err := openFile(l.path)
+	if err != nil {
+		// Nowhere left to log to
+		l.writer = io.Discard
+		return
+	}
+	l.writer = nf
+
+	if l.compress {
+		compressing.Add(1)
+		go func() {
+			defer compressing.Done()
+			compressRotated(l.path, l.maxFiles)
+		}()
+	}
+}
+
+// compressRotated gzips all rotated files of the log at path that are not
+// compressed yet. The files are moved aside while being compressed, such
+// that logging, and thus rotating, need not wait for it.
+func compressRotated(path string, maxFiles int) {
+	compressMu.Lock()
+	defer compressMu.Unlock()
+
+	filesMu.Lock()
+	start := rotations
+	var staged []int
+	for n := 1; n <= maxFiles; n++ {
+		if os.Rename(rotatedName(path, n), stagedName(path, n)) == nil {
+			staged = append(staged, n)
+		}
+	}
+	filesMu.Unlock()
+
+	var errs []error
+	for _, n := range staged {
+		if err := gzipFile(stagedName(path, n)); err != nil {
+			errs = append(errs, fmt.Errorf("could not compress %v: %v", rotatedName(path, n), err))
+		}
+	}
+
+	filesMu.Lock()
+	shift := rotations - start
+	for _, n := range staged {
+		// The file is left uncompressed if compressing failed
+		for _, ext := range []string{"", ".gz"} {
+			name := stagedName(path, n) + ext
+			if _, err := os.Stat(name); err != nil {
+				continue
+			}
+			if n+shift > maxFiles {
+				// Rotated out in the meantime
+				os.Remove(name)
+				continue
+			}
+			os.Rename(name, rotatedName(path, n+shift)+ext)
+		}
+	}
+	// Logging may rotate again, so only once done with the files
+	filesMu.Unlock()
+	for _, err := range errs {
+		Errorf("%v", err)
+	}
+}
+
+// stagedName returns the name the n-th rotated file is moved to while
+// being compressed
+func stagedName(path string, n int) string {
+	return rotatedName(path, n) + ".compressing"
+}
+
+// gzipFile replaces the file at name by name.gz
+func gzipFile(name string) error {
+	in, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := name + ".gz.tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	in.Close()
+	return os.Remove(name)
+}
@@ -0,0 +1,126 @@
+package log
+
+import (
+	"sync"
+	"time"
+)
+
+// sampler coalesces identical log messages occurring within a time window,
+// similar to journald's rate limiting.
+type sampler struct {
+	window    time.Duration
+	threshold int
+	seen      map[sampleKey]*sample
+	mutex     sync.Mutex
+	// flush, if set, is called with the summaries of windows that expire
+	// without any further message being logged
+	flush func([]repeated)
+	timer *time.Timer
+}
+
+type sampleKey struct {
+	label, message string
+}
+
+type sample struct {
+	start      time.Time
+	count      int
+	suppressed int
+}
+
+// repeated summarizes suppressed occurrences of a message
+type repeated struct {
+	label, message string
+	n              int
+}
+
+func newSampler(window time.Duration, threshold int) *sampler {
+	return &sampler{
+		window:    window,
+		threshold: threshold,
+		seen:      make(map[sampleKey]*sample),
+	}
+}
+
+// check registers an occurrence of a message at time now and reports whether
+// it should be logged. It also returns summaries for messages whose window
+// has passed with occurrences suppressed.
+func (s *sampler) check(label, message string, now time.Time) (bool, []repeated) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	reps := s.expire(now)
+	k := sampleKey{label, message}
+	v, ok := s.seen[k]
+	if !ok {
+		s.seen[k] = &sample{start: now, count: 1}
+		return true, reps
+	}
+	v.count++
+	if v.count <= s.threshold {
+		return true, reps
+	}
+	v.suppressed++
+	s.arm(now)
+	return false, reps
+}
+
+// expire forgets messages whose window has passed at time now and returns
+// summaries for those with occurrences suppressed
+func (s *sampler) expire(now time.Time) []repeated {
+	var reps []repeated
+	for k, v := range s.seen {
+		if now.Sub(v.start) < s.window {
+			continue
+		}
+		if v.suppressed > 0 {
+			reps = append(reps, repeated{k.label, k.message, v.suppressed})
+		}
+		delete(s.seen, k)
+	}
+	return reps
+}
+
+// arm starts the flush timer for the earliest window with suppressed
+// occurrences, unless it is already running
+func (s *sampler) arm(now time.Time) {
+	if s.flush == nil || s.timer != nil {
+		return
+	}
+	var next time.Time
+	for _, v := range s.seen {
+		if v.suppressed > 0 && (next.IsZero() || v.start.Before(next)) {
+			next = v.start
+		}
+	}
+	if next.IsZero() {
+		return
+	}
+	s.timer = time.AfterFunc(next.Add(s.window).Sub(now), s.onTimer)
+}
+
+// onTimer emits summaries of expired windows and rearms the timer for
+// the remaining ones
+func (s *sampler) onTimer() {
+	s.mutex.Lock()
+	now := time.Now()
+	s.timer = nil
+	reps := s.expire(now)
+	s.arm(now)
+	flush := s.flush
+	s.mutex.Unlock()
+	if len(reps) > 0 && flush != nil {
+		flush(reps)
+	}
+}
+
+// stop stops the flush timer. Pending summaries are dropped.
+func (s *sampler) stop() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.flush = nil
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+}
@@ -0,0 +1,93 @@
+package log
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSamplerSuppresses(t *testing.T) {
+	s := newSampler(time.Second, 2)
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false, false} {
+		ok, reps := s.check("ERROR", "boom", now)
+		if ok != want {
+			t.Errorf("occurrence %d: got %v, want %v", i, ok, want)
+		}
+		if len(reps) != 0 {
+			t.Errorf("occurrence %d: unexpected summaries %v", i, reps)
+		}
+	}
+
+	// Other messages are not affected
+	if ok, _ := s.check("ERROR", "other", now); !ok {
+		t.Error("distinct message was suppressed")
+	}
+
+	// After the window, a summary is emitted and the message passes again
+	ok, reps := s.check("ERROR", "boom", now.Add(time.Second))
+	if !ok {
+		t.Error("message suppressed after window")
+	}
+	if len(reps) != 1 || reps[0].message != "boom" || reps[0].n != 2 {
+		t.Errorf("unexpected summaries: %v", reps)
+	}
+}
+
+func TestSamplerNoSummaryWithoutSuppression(t *testing.T) {
+	s := newSampler(time.Second, 1)
+	now := time.Now()
+	s.check("INFO", "hello", now)
+	if _, reps := s.check("INFO", "hello", now.Add(2*time.Second)); len(reps) != 0 {
+		t.Errorf("unexpected summaries: %v", reps)
+	}
+}
+
+// Summaries are flushed when the window passes, even if nothing else is
+// logged
+func TestSamplerFlush(t *testing.T) {
+	var b strings.Builder
+	Init(&b, true, false)
+	SetSampling(50*time.Millisecond, 1)
+	defer SetSampling(0, 0)
+
+	for range 3 {
+		Errorf("boom")
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		instance.mutex.Lock()
+		out := b.String()
+		instance.mutex.Unlock()
+		if strings.Contains(out, "ERROR boom (repeated 2 times)\n") {
+			if n := strings.Count(out, "boom"); n != 2 {
+				t.Errorf("expected message and summary only, got %q", out)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("summary not flushed: %q", out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Sampling can be changed while messages are logged, as on reload
+func TestSetSamplingWhileLogging(t *testing.T) {
+	Init(io.Discard, true, false)
+	defer SetSampling(0, 0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 1000 {
+			Errorf("boom")
+		}
+	}()
+	for i := range 100 {
+		SetSampling(time.Duration(i%2)*time.Second, 1)
+	}
+	<-done
+}
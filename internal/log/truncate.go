@@ -0,0 +1,30 @@
+package log
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// DefaultMaxLineLength is the default limit of a message's length in bytes
+const DefaultMaxLineLength = 16 * 1024
+
+// SetMaxLineLength makes messages longer than n bytes be truncated before
+// they are written. Zero or less disables truncation.
+func SetMaxLineLength(n int) {
+	instance.mutex.Lock()
+	defer instance.mutex.Unlock()
+	instance.maxLine = max(n, 0)
+}
+
+// truncate shortens message to at most n bytes, not splitting a UTF-8
+// sequence, and marks how much was cut off
+func truncate(message string, n int) string {
+	if n <= 0 || len(message) <= n {
+		return message
+	}
+	cut := n
+	for cut > 0 && !utf8.RuneStart(message[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s...(truncated %d bytes)", message[:cut], len(message)-cut)
+}
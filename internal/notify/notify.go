@@ -0,0 +1,21 @@
+// Package notify shows desktop notifications using the platform's notifier.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const timeout = 10 * time.Second
+
+// Send shows a desktop notification with the given title and message
+func Send(title, message string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := command(ctx, title, message)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %v %s", cmd.Path, err, out)
+	}
+	return nil
+}
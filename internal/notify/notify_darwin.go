@@ -0,0 +1,17 @@
+//go:build darwin
+
+package notify
+
+import (
+	"context"
+	"os/exec"
+)
+
+func command(ctx context.Context, title, message string) *exec.Cmd {
+	// Passed as arguments, so that they need not be quoted for AppleScript
+	return exec.CommandContext(ctx, "osascript",
+		"-e", "on run argv",
+		"-e", "display notification (item 2 of argv) with title (item 1 of argv)",
+		"-e", "end run",
+		title, message)
+}
@@ -0,0 +1,12 @@
+//go:build linux
+
+package notify
+
+import (
+	"context"
+	"os/exec"
+)
+
+func command(ctx context.Context, title, message string) *exec.Cmd {
+	return exec.CommandContext(ctx, "notify-send", "--app-name=boring", "--", title, message)
+}
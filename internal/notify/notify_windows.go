@@ -0,0 +1,28 @@
+//go:build windows
+
+package notify
+
+import (
+	"context"
+	"os"
+	"os/exec"
+)
+
+// toastScript shows a toast notification, reading title and message from
+// the environment so that they need not be quoted for PowerShell.
+const toastScript = `
+[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$t = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$x = $t.GetElementsByTagName('text')
+$x.Item(0).AppendChild($t.CreateTextNode($env:BORING_NOTIFY_TITLE)) > $null
+$x.Item(1).AppendChild($t.CreateTextNode($env:BORING_NOTIFY_MESSAGE)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('boring').Show([Windows.UI.Notifications.ToastNotification]::new($t))
+`
+
+func command(ctx context.Context, title, message string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive",
+		"-Command", toastScript)
+	cmd.Env = append(os.Environ(),
+		"BORING_NOTIFY_TITLE="+title, "BORING_NOTIFY_MESSAGE="+message)
+	return cmd
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+const connectTimeout = 10 * time.Second
+
+func init() {
+	xproxy.RegisterDialerType("http", newHTTPDialer)
+	xproxy.RegisterDialerType("https", newHTTPDialer)
+}
+
+// httpDialer establishes connections through an HTTP(S) proxy using the
+// CONNECT method. Basic authentication is used if the proxy URL contains
+// credentials.
+type httpDialer struct {
+	url     *url.URL
+	forward xproxy.Dialer
+}
+
+func newHTTPDialer(u *url.URL, forward xproxy.Dialer) (xproxy.Dialer, error) {
+	return &httpDialer{url: u, forward: forward}, nil
+}
+
+func (d *httpDialer) Dial(network, addr string) (net.Conn, error) {
+	proxyAddr := d.url.Host
+	if d.url.Port() == "" {
+		port := "80"
+		if d.url.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(d.url.Hostname(), port)
+	}
+
+	conn, err := d.forward.Dial("tcp", proxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach proxy: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(connectTimeout))
+
+	if d.url.Scheme == "https" {
+		tc := tls.Client(conn, &tls.Config{ServerName: d.url.Hostname()})
+		if err := tc.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy TLS handshake: %v", err)
+		}
+		conn = tc
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if u := d.url.User; u != nil {
+		pw, _ := u.Password()
+		cred := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + pw))
+		req.Header.Set("Proxy-Authorization", "Basic "+cred)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not send CONNECT request: %v", err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not read CONNECT response: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy refused CONNECT: %v", resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// The server already sent data, make sure it is not lost
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn whose reads are served from a buffered reader
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
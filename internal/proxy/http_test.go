@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// connectProxy serves a single CONNECT request, requiring the given
+// Proxy-Authorization header value if non-empty.
+func connectProxy(t *testing.T, auth string) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		if auth != "" && req.Header.Get("Proxy-Authorization") != auth {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		target, err := net.Dial("tcp", req.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer target.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		go io.Copy(target, conn)
+		io.Copy(conn, target)
+	}()
+
+	return l.Addr().String()
+}
+
+func dialThrough(t *testing.T, proxyURL string) (net.Conn, error) {
+	backend, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	go func() {
+		conn, err := backend.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("Test"))
+		conn.Close()
+	}()
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := xproxy.FromURL(u, xproxy.Direct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.Dial("tcp", backend.Addr().String())
+}
+
+func TestHTTPConnect(t *testing.T) {
+	conn, err := dialThrough(t, "http://"+connectProxy(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	buf, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "Test" {
+		t.Fatalf("got %q", buf)
+	}
+}
+
+func TestHTTPConnectAuth(t *testing.T) {
+	// base64("user:pass")
+	addr := connectProxy(t, "Basic dXNlcjpwYXNz")
+	conn, err := dialThrough(t, "http://user:pass@"+addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestHTTPConnectRefused(t *testing.T) {
+	addr := connectProxy(t, "Basic dXNlcjpwYXNz")
+	_, err := dialThrough(t, "http://user:wrong@"+addr)
+	if err == nil || !strings.Contains(err.Error(), "407") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
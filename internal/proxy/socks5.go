@@ -113,6 +113,8 @@ func (s *Server) Serve(l net.Listener) error {
 	}
 }
 
+// ServeConn handles a single connection and closes it. It returns the
+// error the connection failed with, if any.
 func (s *Server) ServeConn(conn net.Conn) error {
 	defer conn.Close()
 	socksC := &Conn{clientConn: conn, srv: s}
@@ -120,7 +122,7 @@ func (s *Server) ServeConn(conn net.Conn) error {
 	if err != nil {
 		log.Errorf("client connection failed: %v", err)
 	}
-	return nil
+	return err
 }
 
 // Conn is a SOCKS5 connection for client to reach
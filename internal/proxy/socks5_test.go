@@ -459,13 +459,17 @@ func TestHandleTCPDialError(t *testing.T) {
 	}
 	t.Cleanup(func() { ln.Close() })
 	port := ln.Addr().(*net.TCPAddr).Port
+	errc := make(chan error, 1)
 	go func() {
 		s := Server{Dialer: func(context.Context, string, string) (net.Conn, error) {
 			return nil, errors.New("dial failed")
 		}}
-		if err := s.Serve(ln); err != nil && !errors.Is(err, net.ErrClosed) {
-			panic(err)
+		c, err := ln.Accept()
+		if err != nil {
+			errc <- err
+			return
 		}
+		errc <- s.ServeConn(c)
 	}()
 
 	conn := dialSocks(t, fmt.Sprintf("localhost:%d", port))
@@ -486,6 +490,9 @@ func TestHandleTCPDialError(t *testing.T) {
 	if n < 2 || resp[1] != byte(generalFailure) {
 		t.Fatalf("got %v, want general failure", resp[:n])
 	}
+	if err := <-errc; err == nil || err.Error() != "dial failed" {
+		t.Errorf("ServeConn returned %v, want dial error", err)
+	}
 }
 
 func TestHandleRequestParseError(t *testing.T) {
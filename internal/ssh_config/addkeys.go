@@ -0,0 +1,138 @@
+package ssh_config
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/alebeck/boring/internal/agent"
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+	xagent "golang.org/x/crypto/ssh/agent"
+)
+
+// addKeysPolicy corresponds to the AddKeysToAgent option
+type addKeysPolicy struct {
+	enabled  bool
+	confirm  bool
+	lifetime time.Duration
+}
+
+// Overridden in tests
+var addToAgent = agent.Add
+
+// parseAddKeys parses AddKeysToAgent values, i.e., "yes", "no", "ask",
+// "confirm" and/or a time interval, e.g., "confirm 1h".
+func parseAddKeys(s string) (addKeysPolicy, error) {
+	var p addKeysPolicy
+	for _, f := range strings.Fields(strings.ToLower(s)) {
+		switch f {
+		case "no":
+			return addKeysPolicy{}, nil
+		case "yes":
+			p.enabled = true
+		case "confirm":
+			p.enabled, p.confirm = true, true
+		case "ask":
+			// We cannot ask, as boring is not meant to be interactive
+			log.Warningf("AddKeysToAgent 'ask' not supported, not adding keys")
+			return addKeysPolicy{}, nil
+		default:
+			d, err := parseTimeInterval(f)
+			if err != nil {
+				return p, fmt.Errorf("unsupported AddKeysToAgent option '%v'", s)
+			}
+			p.enabled, p.lifetime = true, d
+		}
+	}
+	return p, nil
+}
+
+// parseTimeInterval parses sshd_config(5) time formats, e.g., "90", "1h30m"
+func parseTimeInterval(s string) (time.Duration, error) {
+	units := map[rune]time.Duration{
+		's': time.Second, 'm': time.Minute, 'h': time.Hour,
+		'd': 24 * time.Hour, 'w': 7 * 24 * time.Hour,
+	}
+	var total time.Duration
+	for s != "" {
+		i := strings.IndexFunc(s, func(r rune) bool { return !unicode.IsDigit(r) })
+		if i == 0 {
+			return 0, fmt.Errorf("invalid time interval")
+		}
+		num := s
+		unit := time.Second
+		if i > 0 {
+			num = s[:i]
+			u, ok := units[unicode.ToLower(rune(s[i]))]
+			if !ok {
+				return 0, fmt.Errorf("invalid time unit %q", s[i])
+			}
+			unit = u
+			s = s[i+1:]
+		} else {
+			s = ""
+		}
+		n, err := strconv.Atoi(num)
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n) * unit
+	}
+	return total, nil
+}
+
+// agentAddingSigner adds its key to ssh-agent once it is used for signing,
+// which only happens after the server accepted the key.
+type agentAddingSigner struct {
+	ssh.AlgorithmSigner
+	key     any
+	comment string
+	policy  addKeysPolicy
+	once    sync.Once
+}
+
+// withAddToAgent wraps s, such that its key is added to the agent upon use.
+// key is the raw private key of s.
+func (sc *SSHConfig) withAddToAgent(s ssh.Signer, key any, comment string) ssh.Signer {
+	as, ok := s.(ssh.AlgorithmSigner)
+	if !sc.AddKeysToAgent.enabled || !ok {
+		return s
+	}
+	return &agentAddingSigner{AlgorithmSigner: as, key: key, comment: comment,
+		policy: sc.AddKeysToAgent}
+}
+
+func (s *agentAddingSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	sig, err := s.AlgorithmSigner.Sign(rand, data)
+	if err == nil {
+		s.once.Do(s.add)
+	}
+	return sig, err
+}
+
+func (s *agentAddingSigner) SignWithAlgorithm(rand io.Reader, data []byte, algo string) (*ssh.Signature, error) {
+	sig, err := s.AlgorithmSigner.SignWithAlgorithm(rand, data, algo)
+	if err == nil {
+		s.once.Do(s.add)
+	}
+	return sig, err
+}
+
+func (s *agentAddingSigner) add() {
+	err := addToAgent(xagent.AddedKey{
+		PrivateKey:       s.key,
+		Comment:          s.comment,
+		LifetimeSecs:     uint32(s.policy.lifetime / time.Second),
+		ConfirmBeforeUse: s.policy.confirm,
+	})
+	if err != nil {
+		log.Debugf("Not adding key %s to ssh-agent: %v", s.comment, err)
+		return
+	}
+	log.Debugf("Added key %s to ssh-agent", s.comment)
+}
@@ -0,0 +1,81 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	xagent "golang.org/x/crypto/ssh/agent"
+)
+
+func TestParseAddKeys(t *testing.T) {
+	cases := []struct {
+		in   string
+		want addKeysPolicy
+	}{
+		{"no", addKeysPolicy{}},
+		{"", addKeysPolicy{}},
+		{"yes", addKeysPolicy{enabled: true}},
+		{"confirm", addKeysPolicy{enabled: true, confirm: true}},
+		{"1h30m", addKeysPolicy{enabled: true, lifetime: 90 * time.Minute}},
+		{"confirm 90", addKeysPolicy{enabled: true, confirm: true, lifetime: 90 * time.Second}},
+		{"ask", addKeysPolicy{}},
+	}
+	for _, c := range cases {
+		got, err := parseAddKeys(c.in)
+		if err != nil {
+			t.Errorf("parseAddKeys(%q) error: %v", c.in, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("parseAddKeys(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+	for _, in := range []string{"sometimes", "1x", "h"} {
+		if _, err := parseAddKeys(in); err == nil {
+			t.Errorf("parseAddKeys(%q): expected error", in)
+		}
+	}
+}
+
+func TestAddToAgentOnUse(t *testing.T) {
+	var added []xagent.AddedKey
+	orig := addToAgent
+	addToAgent = func(k xagent.AddedKey) error {
+		added = append(added, k)
+		return nil
+	}
+	t.Cleanup(func() { addToAgent = orig })
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Disabled: signer is returned as is
+	sc := &SSHConfig{}
+	if sc.withAddToAgent(s, priv, "id") != s {
+		t.Error("signer wrapped although disabled")
+	}
+
+	sc.AddKeysToAgent = addKeysPolicy{enabled: true, lifetime: time.Minute}
+	w := sc.withAddToAgent(s, priv, "id")
+	if len(added) != 0 {
+		t.Fatal("key added before use")
+	}
+	for range 2 {
+		if _, err := w.(ssh.AlgorithmSigner).SignWithAlgorithm(rand.Reader, []byte("data"),
+			ssh.KeyAlgoED25519); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(added) != 1 || added[0].LifetimeSecs != 60 || added[0].Comment != "id" {
+		t.Errorf("unexpected added keys: %+v", added)
+	}
+}
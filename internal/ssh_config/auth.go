@@ -0,0 +1,86 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// IsTooManyAuthFailures tells whether err is due to the server closing
+// the connection after too many authentication attempts, as happens with
+// a low MaxAuthTries if many keys are offered.
+func IsTooManyAuthFailures(err error) bool {
+	return err != nil && strings.Contains(strings.ToLower(err.Error()), "too many authentication failures")
+}
+
+// makeAuth builds the authentication methods to offer to the server, in the
+// order given by PreferredAuthentications. Methods that are unsupported or
+// lack the required credentials are skipped.
+func (sc *SSHConfig) makeAuth() ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+	var keyErr error
+
+	for _, m := range sc.PreferredAuths {
+		m = strings.TrimSpace(m)
+		switch m {
+		case "publickey":
+			sigs, err := sc.makeSigners()
+			if err != nil {
+				keyErr = err
+				continue
+			}
+			log.Debugf("Trying %d key file(s)", len(sigs))
+			auth = append(auth, ssh.PublicKeys(sigs...))
+		case "password":
+			if sc.Password == "" {
+				log.Debugf("%v: skipping password auth, no password set", sc.Alias)
+				continue
+			}
+			auth = append(auth, ssh.Password(sc.Password))
+		case "keyboard-interactive":
+			if sc.Password == "" {
+				log.Debugf("%v: skipping keyboard-interactive auth, no password set", sc.Alias)
+				continue
+			}
+			auth = append(auth, ssh.KeyboardInteractive(sc.answerPassword))
+		case "gssapi-with-mic", "hostbased":
+			log.Debugf("%v: skipping unsupported auth method %v", sc.Alias, m)
+		default:
+			am, ok, err := sc.registeredAuth(m)
+			if !ok {
+				log.Warningf("%v: unknown auth method %q", sc.Alias, m)
+			} else if err != nil {
+				log.Warningf("%v: %v", sc.Alias, err)
+				keyErr = err
+			} else if am == nil {
+				log.Debugf("%v: skipping auth method %v", sc.Alias, m)
+			} else {
+				auth = append(auth, am)
+			}
+		}
+	}
+
+	if len(auth) == 0 {
+		if keyErr != nil {
+			return nil, keyErr
+		}
+		return nil, fmt.Errorf("%s: no usable authentication methods in %v",
+			sc.Alias, sc.PreferredAuths)
+	}
+	return auth, nil
+}
+
+// answerPassword answers keyboard-interactive challenges by responding to
+// non-echoed prompts with the configured password.
+func (sc *SSHConfig) answerPassword(name, instr string, qs []string, echos []bool) ([]string, error) {
+	answers := make([]string, len(qs))
+	for i := range qs {
+		if echos[i] {
+			return nil, fmt.Errorf("cannot answer interactive prompt %q", qs[i])
+		}
+		answers[i] = sc.Password
+	}
+	return answers, nil
+}
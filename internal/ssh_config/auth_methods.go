@@ -0,0 +1,76 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AuthMethodFactory builds an authentication method from the resolved
+// configuration of a host. It may return a nil method to skip itself, e.g.,
+// if it does not apply to the host.
+type AuthMethodFactory func(sc *SSHConfig) (ssh.AuthMethod, error)
+
+// builtinAuthMethods are handled by makeAuth and cannot be registered
+var builtinAuthMethods = map[string]bool{
+	"publickey": true, "password": true, "keyboard-interactive": true,
+	"gssapi-with-mic": true, "hostbased": true,
+}
+
+var (
+	authMethodsMu sync.RWMutex
+	authMethods   = make(map[string]AuthMethodFactory)
+)
+
+// RegisterAuthMethod makes a custom authentication method available under
+// name, such that it can be listed in PreferredAuthentications. Methods are
+// offered to the server in the order listed there, registered and built-in
+// ones alike; unlisted methods are not used. The factory is called when the
+// configuration is resolved and again on each connection attempt. If it
+// fails, a warning is logged and the method is skipped, and its error is
+// reported if no other method is usable.
+// RegisterAuthMethod panics if name is a built-in method or registered
+// twice, and is meant to be called from init functions.
+func RegisterAuthMethod(name string, f AuthMethodFactory) {
+	if f == nil {
+		panic("ssh_config: nil auth method factory for " + name)
+	}
+	if builtinAuthMethods[name] {
+		panic("ssh_config: cannot register built-in auth method " + name)
+	}
+	authMethodsMu.Lock()
+	defer authMethodsMu.Unlock()
+	if _, ok := authMethods[name]; ok {
+		panic("ssh_config: auth method registered twice: " + name)
+	}
+	authMethods[name] = f
+}
+
+// registeredAuth builds the registered method name, if any. The method is
+// nil if it is not registered or skipped itself.
+func (sc *SSHConfig) registeredAuth(name string) (m ssh.AuthMethod, ok bool, err error) {
+	authMethodsMu.RLock()
+	f, ok := authMethods[name]
+	authMethodsMu.RUnlock()
+	if !ok {
+		return nil, false, nil
+	}
+	if m, err = f(sc); err != nil {
+		return nil, true, fmt.Errorf("auth method %v: %v", name, err)
+	}
+	return m, true, nil
+}
+
+// usesRegisteredAuth tells whether PreferredAuths lists a registered method
+func (sc *SSHConfig) usesRegisteredAuth() bool {
+	authMethodsMu.RLock()
+	defer authMethodsMu.RUnlock()
+	for _, m := range sc.PreferredAuths {
+		if _, ok := authMethods[strings.TrimSpace(m)]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestMakeAuthOrder(t *testing.T) {
+	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	sc := &SSHConfig{
+		Alias:          "test",
+		IdentityFiles:  []string{priv},
+		PreferredAuths: []string{"password", " publickey", "hostbased"},
+		Password:       "secret",
+	}
+	auth, err := sc.makeAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth) != 2 {
+		t.Fatalf("got %d auth methods, want 2", len(auth))
+	}
+}
+
+func TestMakeAuthSkipsWithoutPassword(t *testing.T) {
+	sc := &SSHConfig{
+		Alias:          "test",
+		PreferredAuths: []string{"password", "keyboard-interactive"},
+	}
+	if _, err := sc.makeAuth(); err == nil ||
+		!strings.Contains(err.Error(), "no usable authentication methods") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestMakeAuthKeyError(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	sc := &SSHConfig{Alias: "test", PreferredAuths: []string{"publickey"}}
+	if _, err := sc.makeAuth(); err == nil ||
+		!strings.Contains(err.Error(), "no key files found") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestAnswerPassword(t *testing.T) {
+	sc := &SSHConfig{Password: "secret"}
+	ans, err := sc.answerPassword("", "", []string{"Password: "}, []bool{false})
+	if err != nil || len(ans) != 1 || ans[0] != "secret" {
+		t.Fatalf("unexpected answers %v, err %v", ans, err)
+	}
+	if _, err := sc.answerPassword("", "", []string{"OTP: "}, []bool{true}); err == nil {
+		t.Fatal("expected error for echoed prompt")
+	}
+}
+
+// unregisterAuthMethods removes the named methods when the test ends, such
+// that it can run repeatedly
+func unregisterAuthMethods(t *testing.T, names ...string) {
+	t.Cleanup(func() {
+		authMethodsMu.Lock()
+		defer authMethodsMu.Unlock()
+		for _, name := range names {
+			delete(authMethods, name)
+		}
+	})
+}
+
+func TestRegisteredAuthMethod(t *testing.T) {
+	var got []string
+	unregisterAuthMethods(t, "test-token", "test-skip", "test-fail")
+	RegisterAuthMethod("test-token", func(sc *SSHConfig) (ssh.AuthMethod, error) {
+		got = append(got, sc.Alias)
+		return ssh.Password("token"), nil
+	})
+	RegisterAuthMethod("test-skip", func(*SSHConfig) (ssh.AuthMethod, error) {
+		return nil, nil
+	})
+	RegisterAuthMethod("test-fail", func(*SSHConfig) (ssh.AuthMethod, error) {
+		return nil, fmt.Errorf("exchange failed")
+	})
+
+	sc := &SSHConfig{
+		Alias:          "test",
+		PreferredAuths: []string{"test-skip", "test-fail", "test-token", "password"},
+		Password:       "secret",
+	}
+	auth, err := sc.makeAuth()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(auth) != 2 || len(got) != 1 || got[0] != "test" {
+		t.Fatalf("got %d auth methods and factory calls %v", len(auth), got)
+	}
+
+	sc.PreferredAuths = []string{"test-fail"}
+	if _, err := sc.makeAuth(); err == nil || !strings.Contains(err.Error(), "exchange failed") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, name := range []string{"publickey", "test-token"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %v did not panic", name)
+				}
+			}()
+			RegisterAuthMethod(name, func(*SSHConfig) (ssh.AuthMethod, error) { return nil, nil })
+		}()
+	}
+}
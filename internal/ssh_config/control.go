@@ -0,0 +1,54 @@
+package ssh_config
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var controlPathTokens = []string{
+	"%%", "%C", "%d", "%h", "%i", "%L", "%n", "%p", "%r", "%u",
+}
+
+// applyControl sets ControlPath and ControlPersist if connection sharing is
+// enabled through ControlMaster. All values other than "no" enable it, as
+// boring decides itself which tunnel establishes the shared connection.
+func (c *SSHConfig) applyControl(master, path, persist string, sub subst) error {
+	if master == "" || master == "no" || path == "" || path == "none" {
+		return nil
+	}
+	// %C is a hash of local host name, host, port and user
+	h := sha1.Sum([]byte(sub["%L"] + sub["%h"] + sub["%p"] + sub["%r"]))
+	sub["%C"] = hex.EncodeToString(h[:])
+	c.ControlPath = sub.apply(path, controlPathTokens)
+
+	var err error
+	if c.ControlPersist, err = ParseControlPersist(persist); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseControlPersist parses a ControlPersist value: "yes" keeps a shared
+// connection open indefinitely, which is denoted by a negative duration,
+// "no" closes it once unused. Otherwise, the connection is kept for the
+// given time, in seconds or as a duration like "10m".
+func ParseControlPersist(s string) (time.Duration, error) {
+	switch s = strings.TrimSpace(s); s {
+	case "", "no":
+		return 0, nil
+	case "yes":
+		return -1, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid ControlPersist value %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,67 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseControlPersist(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":    0,
+		"no":  0,
+		"yes": -1,
+		"30":  30 * time.Second,
+		"10m": 10 * time.Minute,
+	}
+	for in, want := range cases {
+		got, err := ParseControlPersist(in)
+		if err != nil || got != want {
+			t.Errorf("%q: got %v, %v, want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"sometimes", "-5", "-1m"} {
+		if _, err := ParseControlPersist(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
+
+func TestParseSSHConfigControl(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	conf := "Host shared\n\tControlMaster auto\n\tControlPath /tmp/cm-%r@%h:%p\n\tControlPersist 1m\n" +
+		"Host hashed\n\tControlMaster auto\n\tControlPath /tmp/cm-%C\n" +
+		"Host *\n\tHostName example.com\n\tUser bob\n\tPort 2222\n\tControlPath /tmp/cm-%h\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := overrideConfig
+	overrideConfig = cfg
+	t.Cleanup(func() { overrideConfig = old })
+
+	sc, err := ParseSSHConfig("shared", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.ControlPath != "/tmp/cm-bob@example.com:2222" || sc.ControlPersist != time.Minute {
+		t.Errorf("got path %q, persist %v", sc.ControlPath, sc.ControlPersist)
+	}
+
+	if sc, err = ParseSSHConfig("hashed", ""); err != nil {
+		t.Fatal(err)
+	}
+	if h := strings.TrimPrefix(sc.ControlPath, "/tmp/cm-"); len(h) != 40 {
+		t.Errorf("expected %%C to expand to a hash, got %q", sc.ControlPath)
+	}
+
+	// Without ControlMaster, connections are not shared
+	if sc, err = ParseSSHConfig("other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if sc.ControlPath != "" {
+		t.Errorf("got path %q, want none", sc.ControlPath)
+	}
+}
@@ -0,0 +1,62 @@
+package ssh_config
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// fallbackWarning warns once a key other than the configured identities
+// is used to authenticate, as some of them could not be loaded
+type fallbackWarning struct {
+	alias  string
+	failed []string
+	once   sync.Once
+}
+
+// fallbackSigner signs with a key used in place of failed identities.
+// Servers only ask for a signature once they accept a key.
+type fallbackSigner struct {
+	ssh.AlgorithmSigner
+	warn *fallbackWarning
+}
+
+func (w *fallbackWarning) wrap(s ssh.Signer) ssh.Signer {
+	as, ok := s.(ssh.AlgorithmSigner)
+	if !ok {
+		return s
+	}
+	return &fallbackSigner{AlgorithmSigner: as, warn: w}
+}
+
+// wrapOther wraps the signers of identities not configured
+func (w *fallbackWarning) wrapOther(groups ...[]identity) {
+	for _, ids := range groups {
+		for i := range ids {
+			if ids[i].other {
+				ids[i].signer = w.wrap(ids[i].signer)
+			}
+		}
+	}
+}
+
+func (w *fallbackWarning) emit(key ssh.PublicKey) {
+	w.once.Do(func() {
+		log.Warningf("%v%v: authenticating with key %v (%v) not configured as identity, "+
+			"since %v could not be loaded%v", log.Bold, w.alias, FingerprintOf(key), key.Type(),
+			strings.Join(w.failed, ", "), log.Reset)
+	})
+}
+
+func (s *fallbackSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.warn.emit(s.PublicKey())
+	return s.AlgorithmSigner.Sign(rand, data)
+}
+
+func (s *fallbackSigner) SignWithAlgorithm(rand io.Reader, data []byte, algo string) (*ssh.Signature, error) {
+	s.warn.emit(s.PublicKey())
+	return s.AlgorithmSigner.SignWithAlgorithm(rand, data, algo)
+}
@@ -0,0 +1,65 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alebeck/boring/internal/log"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestFallbackWarning(t *testing.T) {
+	var b strings.Builder
+	log.Init(&b, true, false)
+	t.Cleanup(func() { log.Init(io.Discard, false, false) })
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := &fallbackWarning{alias: "test", failed: []string{"~/.ssh/id_broken"}}
+	ws := w.wrap(s)
+	if _, ok := ws.(ssh.AlgorithmSigner); !ok {
+		t.Fatal("wrapped signer is no AlgorithmSigner")
+	}
+	if b.Len() != 0 {
+		t.Fatalf("warned before signing: %q", b.String())
+	}
+	for range 2 {
+		if _, err := ws.Sign(rand.Reader, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out := b.String()
+	if strings.Count(out, "WARNING") != 1 || !strings.Contains(out, FingerprintOf(s.PublicKey())) ||
+		!strings.Contains(out, "~/.ssh/id_broken") {
+		t.Errorf("unexpected warning: %q", out)
+	}
+}
+
+func TestLoadIDsFailed(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	broken := filepath.Join(t.TempDir(), "id_broken")
+	if err := os.WriteFile(broken, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	orig := defaultIdentityFiles
+	missingDefault := filepath.Join(t.TempDir(), "id_default")
+	defaultIdentityFiles = []string{missingDefault}
+	t.Cleanup(func() { defaultIdentityFiles = orig })
+
+	sc := &SSHConfig{Alias: "test", IdentityFiles: []string{broken, missingDefault}}
+	_, _, _, _, failed := sc.loadIDs()
+	if len(failed) != 1 || failed[0] != broken {
+		t.Errorf("got failed %v, want [%v]", failed, broken)
+	}
+}
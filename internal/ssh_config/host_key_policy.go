@@ -0,0 +1,162 @@
+package ssh_config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// knownHostsMu serializes additions to known_hosts files
+var knownHostsMu sync.Mutex
+
+// newHostsFile is the known_hosts file new keys are added to, if set
+var newHostsFile string
+
+// SetNewHostsFile designates the known_hosts file keys of new hosts are
+// added to, instead of the first UserKnownHostsFile. An empty path restores
+// the default. A warning is logged if the file is not writable.
+func SetNewHostsFile(path string) {
+	newHostsFile = paths.ReplaceTilde(path)
+	if newHostsFile == "" {
+		return
+	}
+	if err := checkWritable(newHostsFile); err != nil {
+		log.Warningf("known_hosts file %v is not writable: %v", newHostsFile, err)
+	}
+}
+
+// CheckNewHostsFile returns an error if keys of new hosts are to be added,
+// but NewHostsFile is missing or not writable.
+func (sc *SSHConfig) CheckNewHostsFile() error {
+	if sc.KeyCheck != acceptNew {
+		return nil
+	}
+	if sc.NewHostsFile == "" {
+		return fmt.Errorf("no UserKnownHostsFile")
+	}
+	return checkWritable(paths.ReplaceTilde(sc.NewHostsFile))
+}
+
+// checkWritable returns an error if path cannot be appended to, or, if it
+// does not exist, created. Missing parent directories are created on
+// demand, so only the nearest existing one is checked.
+func checkWritable(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		return f.Close()
+	}
+	dir := filepath.Dir(path)
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			break
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	f, err := os.CreateTemp(dir, ".boring-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// SetHostKeyPolicy overrides how host keys are verified, which is otherwise
+// determined by StrictHostKeyChecking and PinnedFingerprint. Policies are
+// "strict" (or "ask", as boring is not interactive), "accept-new",
+// "insecure" and "pinned", which requires PinnedFingerprint to be set.
+func (sc *SSHConfig) SetHostKeyPolicy(policy string) error {
+	switch policy {
+	case "":
+	case "strict", "ask":
+		sc.KeyCheck = strict
+	case "accept-new":
+		sc.KeyCheck = acceptNew
+	case "insecure":
+		sc.KeyCheck = off
+	case "pinned":
+		if sc.PinnedFingerprint == "" {
+			return fmt.Errorf("host key policy 'pinned' requires a fingerprint")
+		}
+	default:
+		return fmt.Errorf("unknown host key policy %q", policy)
+	}
+	return nil
+}
+
+// acceptNewCallback accepts the key of a host not in known_hosts and adds
+// it to NewHostsFile. Keys of known hosts are verified by known. As known
+// does not see keys added later, accepted keys are remembered and later
+// connections to the same host are verified against them.
+func (sc *SSHConfig) acceptNewCallback(known ssh.HostKeyCallback) ssh.HostKeyCallback {
+	var mu sync.Mutex
+	accepted := make(map[string]ssh.PublicKey)
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(host, remote, key)
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) || len(ke.Want) > 0 {
+			// Accepted, revoked, or a different key is known
+			return err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if k, ok := accepted[host]; ok {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+			return &knownhosts.KeyError{Want: []knownhosts.KnownKey{{Key: k, Filename: sc.NewHostsFile}}}
+		}
+		if err := sc.addKnownHost(host, key); err != nil {
+			return fmt.Errorf("could not add host key: %v", err)
+		}
+		accepted[host] = key
+		return nil
+	}
+}
+
+// addKnownHost appends an entry for host to NewHostsFile
+func (sc *SSHConfig) addKnownHost(host string, key ssh.PublicKey) error {
+	if sc.NewHostsFile == "" {
+		return fmt.Errorf("no UserKnownHostsFile")
+	}
+	path := paths.ReplaceTilde(sc.NewHostsFile)
+	name := knownhosts.Normalize(host)
+	if sc.HashKnownHosts {
+		name = knownhosts.HashHostname(name)
+	}
+
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(knownhosts.Line([]string{name}, key) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	log.Infof("%v: added %v key %v to %v", sc.Alias, key.Type(), FingerprintOf(key), path)
+	return nil
+}
@@ -0,0 +1,159 @@
+package ssh_config
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSetHostKeyPolicy(t *testing.T) {
+	cases := []struct {
+		policy string
+		pinned string
+		want   keyCheck
+		err    bool
+	}{
+		{"", "", strict, false},
+		{"strict", "", strict, false},
+		{"ask", "", strict, false},
+		{"accept-new", "", acceptNew, false},
+		{"insecure", "", off, false},
+		{"pinned", "SHA256:abc", strict, false},
+		{"pinned", "", strict, true},
+		{"yolo", "", strict, true},
+	}
+	for _, c := range cases {
+		sc := &SSHConfig{PinnedFingerprint: c.pinned}
+		err := sc.SetHostKeyPolicy(c.policy)
+		if (err != nil) != c.err {
+			t.Errorf("%q: got err %v, want error %v", c.policy, err, c.err)
+			continue
+		}
+		if err == nil && sc.KeyCheck != c.want {
+			t.Errorf("%q: got key check %v, want %v", c.policy, sc.KeyCheck, c.want)
+		}
+	}
+}
+
+func TestAcceptNew(t *testing.T) {
+	for _, hash := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "ssh", "known_hosts")
+		sc := &SSHConfig{
+			Alias:           "test",
+			HostName:        "127.0.0.1",
+			Port:            2222,
+			KeyCheck:        acceptNew,
+			KnownHostsFiles: []string{path},
+			NewHostsFile:    path,
+			HashKnownHosts:  hash,
+			HostKeyAlgos:    []string{"ssh-ed25519"},
+		}
+		addr, _ := net.ResolveTCPAddr("tcp", testHostPort)
+		key := edPub(t)
+
+		cb, _, err := sc.makeCallbackAndAlgos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cb(testHostPort, addr, key); err != nil {
+			t.Fatalf("new key rejected: %v", err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.HasPrefix(string(b), "|1|"); got != hash {
+			t.Errorf("hashed entry %v, want %v: %q", got, hash, b)
+		}
+
+		// The host is known now, so a different key must be rejected
+		cb, algs, err := sc.makeCallbackAndAlgos()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(algs) != 1 || algs[0] != "ssh-ed25519" {
+			t.Errorf("got algorithms %v", algs)
+		}
+		if err := cb(testHostPort, addr, key); err != nil {
+			t.Errorf("added key rejected: %v", err)
+		}
+		err = cb(testHostPort, addr, edPub(t))
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) || len(ke.Want) == 0 {
+			t.Errorf("changed key: got %v, want key mismatch", err)
+		}
+	}
+}
+
+func TestAcceptNewRemembersKey(t *testing.T) {
+	dir := t.TempDir()
+	sc := &SSHConfig{Alias: "test", NewHostsFile: filepath.Join(dir, "known_hosts")}
+	cb := sc.acceptNewCallback(callbackFor(t, ""))
+	remote := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 22}
+
+	first, second := edPub(t), edPub(t)
+	if err := cb(testHostPort, remote, first); err != nil {
+		t.Fatalf("new key rejected: %v", err)
+	}
+	if err := cb(testHostPort, remote, first); err != nil {
+		t.Errorf("accepted key rejected on reconnect: %v", err)
+	}
+	var ke *knownhosts.KeyError
+	if err := cb(testHostPort, remote, second); !errors.As(err, &ke) {
+		t.Errorf("expected key mismatch on reconnect, got %v", err)
+	}
+
+	data, err := os.ReadFile(sc.NewHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Errorf("expected one known_hosts line, got %d", n)
+	}
+}
+
+func TestNewHostsFile(t *testing.T) {
+	dir := t.TempDir()
+	cfg := filepath.Join(dir, "config")
+	global := filepath.Join(dir, "global_known_hosts")
+	conf := "Host *\n\tGlobalKnownHostsFile " + global +
+		"\n\tUserKnownHostsFile " + filepath.Join(dir, "user_known_hosts") + "\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := overrideConfig
+	overrideConfig = cfg
+	t.Cleanup(func() { overrideConfig = old })
+
+	designated := filepath.Join(dir, "new", "known_hosts")
+	SetNewHostsFile(designated)
+	t.Cleanup(func() { SetNewHostsFile("") })
+	sc, err := ParseSSHConfig("test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.NewHostsFile != designated || !slices.Contains(sc.KnownHostsFiles, designated) {
+		t.Errorf("got new hosts file %q, known hosts %v", sc.NewHostsFile, sc.KnownHostsFiles)
+	}
+	sc.KeyCheck = acceptNew
+	if err := sc.CheckNewHostsFile(); err != nil {
+		t.Errorf("missing file in writable dir: %v", err)
+	}
+
+	if os.Geteuid() == 0 {
+		t.Skip("root can write read-only files")
+	}
+	if err := os.WriteFile(global, nil, 0o400); err != nil {
+		t.Fatal(err)
+	}
+	sc.NewHostsFile = global
+	if err := sc.CheckNewHostsFile(); err == nil {
+		t.Error("read-only file reported writable")
+	}
+}
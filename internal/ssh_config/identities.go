@@ -0,0 +1,53 @@
+package ssh_config
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/alebeck/boring/internal/paths"
+	ossh_config "github.com/alebeck/ssh_config"
+)
+
+// builtinIdentityFiles are the key files returned by ssh_config for hosts
+// without an IdentityFile, mirroring OpenSSH.
+var builtinIdentityFiles = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_ecdsa_sk",
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_ed25519_sk",
+}
+
+var defaultIdentityFiles = builtinIdentityFiles
+
+// SetDefaultIdentityFiles sets the key files tried for hosts without an
+// IdentityFile, e.g., if keys were renamed. An empty list restores the
+// built-in defaults.
+func SetDefaultIdentityFiles(files []string) {
+	if len(files) == 0 {
+		defaultIdentityFiles = builtinIdentityFiles
+		return
+	}
+	defaultIdentityFiles = make([]string, len(files))
+	for i, f := range files {
+		defaultIdentityFiles[i] = paths.ReplaceTilde(f)
+	}
+}
+
+// identityFiles replaces the built-in defaults returned by ssh_config by
+// the configured ones.
+func identityFiles(files []string) []string {
+	if slices.Equal(files, builtinIdentityFiles) {
+		return defaultIdentityFiles
+	}
+	return files
+}
+
+// isDefault tells whether vals, as returned by ssh_config for key, are
+// its default rather than configured.
+func isDefault(key string, vals []string) bool {
+	if key == "IdentityFile" {
+		return slices.Equal(vals, builtinIdentityFiles)
+	}
+	return strings.Join(vals, " ") == ossh_config.Default(key)
+}
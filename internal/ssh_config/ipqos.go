@@ -0,0 +1,48 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ipQoSNames maps the names accepted by IPQoS to the type of service byte,
+// i.e., DSCP values shifted past the two ECN bits
+var ipQoSNames = map[string]int{
+	"af11": 0x28, "af12": 0x30, "af13": 0x38,
+	"af21": 0x48, "af22": 0x50, "af23": 0x58,
+	"af31": 0x68, "af32": 0x70, "af33": 0x78,
+	"af41": 0x88, "af42": 0x90, "af43": 0x98,
+	"cs0": 0x00, "cs1": 0x20, "cs2": 0x40, "cs3": 0x60,
+	"cs4": 0x80, "cs5": 0xa0, "cs6": 0xc0, "cs7": 0xe0,
+	"ef": 0xb8, "le": 0x04,
+	"lowdelay": 0x10, "throughput": 0x08, "reliability": 0x04,
+	"none": 0,
+}
+
+// ParseIPQoS parses an IPQoS value and returns the type of service to mark
+// packets with, 0 leaving it unchanged. Values are names like "ef" or
+// "cs1", or numbers. Of two values, the first applies to interactive
+// sessions and the second to others, like boring's tunnels.
+func ParseIPQoS(s string) (int, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return 0, nil
+	}
+	if len(f) > 2 {
+		return 0, fmt.Errorf("invalid IPQoS %q, expected at most two values", s)
+	}
+	var tos int
+	for _, v := range f {
+		var ok bool
+		if tos, ok = ipQoSNames[strings.ToLower(v)]; ok {
+			continue
+		}
+		n, err := strconv.ParseUint(v, 0, 8)
+		if err != nil {
+			return 0, fmt.Errorf("invalid IPQoS value %q", v)
+		}
+		tos = int(n)
+	}
+	return tos, nil
+}
@@ -0,0 +1,29 @@
+package ssh_config
+
+import "testing"
+
+func TestParseIPQoS(t *testing.T) {
+	cases := map[string]int{
+		"":              0,
+		"none":          0,
+		"ef":            0xb8,
+		"CS1":           0x20,
+		"af21 cs1":      0x20,
+		"lowdelay":      0x10,
+		"throughput":    0x08,
+		"0x10":          0x10,
+		"32":            32,
+		"lowdelay none": 0,
+	}
+	for in, want := range cases {
+		got, err := ParseIPQoS(in)
+		if err != nil || got != want {
+			t.Errorf("%q: got %#x, %v, want %#x", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"fast", "256", "ef cs1 cs2"} {
+		if _, err := ParseIPQoS(in); err == nil {
+			t.Errorf("%q: expected error", in)
+		}
+	}
+}
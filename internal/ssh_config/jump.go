@@ -2,6 +2,7 @@ package ssh_config
 
 import (
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 )
@@ -14,18 +15,37 @@ type jumpSpec struct {
 }
 
 func parseProxyJump(s string) (*jumpSpec, error) {
+	return parseDestination(s)
+}
+
+// ParseDestination splits a destination of the form [user@]host[:port] into
+// its components. IPv6 literals have to be enclosed in brackets if a port is
+// given. Omitted components are returned as zero values.
+func ParseDestination(s string) (host, user string, port int, err error) {
+	d, err := parseDestination(s)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return d.host, d.user, d.port, nil
+}
+
+func parseDestination(s string) (*jumpSpec, error) {
 	// Format: [user@]host[:port]
-	var portInt int
-	var err error
-	host, port, _ := strings.Cut(s, ":")
+	d := &jumpSpec{}
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		d.user, s = s[:i], s[i+1:]
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// No port given, which is fine. Strip brackets of IPv6 literals.
+		d.host = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		return d, nil
+	}
+	d.host = host
 	if port != "" {
-		if portInt, err = strconv.Atoi(port); err != nil {
+		if d.port, err = strconv.Atoi(port); err != nil {
 			return nil, fmt.Errorf("could not parse port: %v", err)
 		}
 	}
-	user, host, fnd := strings.Cut(host, "@")
-	if !fnd {
-		user, host = host, user
-	}
-	return &jumpSpec{host: host, user: user, port: portInt}, nil
+	return d, nil
 }
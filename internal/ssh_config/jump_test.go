@@ -0,0 +1,55 @@
+package ssh_config
+
+import "testing"
+
+func TestParseDestination(t *testing.T) {
+	cases := []struct {
+		in   string
+		want jumpSpec
+	}{
+		{"bastion", jumpSpec{host: "bastion"}},
+		{"alice@bastion", jumpSpec{host: "bastion", user: "alice"}},
+		{"bastion:2222", jumpSpec{host: "bastion", port: 2222}},
+		{"alice@bastion:2222", jumpSpec{host: "bastion", user: "alice", port: 2222}},
+		{"alice@[::1]:2222", jumpSpec{host: "::1", user: "alice", port: 2222}},
+		{"[::1]", jumpSpec{host: "::1"}},
+		{"fe80::1", jumpSpec{host: "fe80::1"}},
+		{"bob@fe80::1", jumpSpec{host: "fe80::1", user: "bob"}},
+		{"fe80::1%eth0", jumpSpec{host: "fe80::1%eth0"}},
+		{"bob@[fe80::1%eth0]:2222", jumpSpec{host: "fe80::1%eth0", user: "bob", port: 2222}},
+	}
+	for _, c := range cases {
+		host, user, port, err := ParseDestination(c.in)
+		if err != nil {
+			t.Errorf("ParseDestination(%q) error: %v", c.in, err)
+			continue
+		}
+		got := jumpSpec{host: host, user: user, port: port}
+		if got != c.want {
+			t.Errorf("ParseDestination(%q) = %+v, want %+v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestParseDestinationBadPort(t *testing.T) {
+	if _, _, _, err := ParseDestination("bastion:notaport"); err == nil {
+		t.Error("expected error for invalid port")
+	}
+}
+
+func TestHopAddr(t *testing.T) {
+	cases := []struct {
+		host string
+		want string
+	}{
+		{"example.com", "example.com:22"},
+		{"::1", "[::1]:22"},
+		{"fe80::1%eth0", "[fe80::1%eth0]:22"},
+	}
+	for _, c := range cases {
+		h := Hop{HostName: c.host, Port: 22}
+		if got := h.Addr(); got != c.want {
+			t.Errorf("Addr() = %q, want %q", got, c.want)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package ssh_config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var (
+	keyCommandTimeout = 30 * time.Second
+	keyCommandTokens  = []string{"%%", "%d", "%h", "%i", "%L", "%n", "%p", "%r", "%u"}
+)
+
+// loadKeyCommand runs KeyCommand and parses its stdout as a private key.
+// The output is key material and must never be logged.
+func (sc *SSHConfig) loadKeyCommand() (ssh.Signer, error) {
+	cmdline := sc.subst().apply(sc.KeyCommand, keyCommandTokens)
+
+	ctx, cancel := context.WithTimeout(context.Background(), keyCommandTimeout)
+	defer cancel()
+
+	cmd := shellCommand(ctx, cmdline)
+	// Children of the shell may keep stdout open after it is killed
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("key command timed out after %v", keyCommandTimeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key command failed: %v: %s", err,
+			strings.TrimSpace(stderr.String()))
+	}
+
+	key, err := ssh.ParseRawPrivateKey(out)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse output of key command: %v", keyParseError(out, err))
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse output of key command: %v", err)
+	}
+	return sc.withAddToAgent(signer, key, "key command"), nil
+}
+
+func shellCommand(ctx context.Context, cmdline string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", cmdline)
+	}
+	return exec.CommandContext(ctx, "/bin/sh", "-c", cmdline)
+}
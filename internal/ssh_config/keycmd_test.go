@@ -0,0 +1,54 @@
+package ssh_config
+
+import (
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadKeyCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	dir := t.TempDir()
+	writeKeyPair(t, dir, "example.com")
+
+	// %h must be expanded to the host name
+	sc := &SSHConfig{
+		Alias:      "test",
+		HostName:   "example.com",
+		KeyCommand: "cat " + filepath.Join(dir, "%h"),
+	}
+	if _, err := sc.loadKeyCommand(); err != nil {
+		t.Fatal(err)
+	}
+
+	sc.KeyCommand = "echo not a key"
+	if _, err := sc.loadKeyCommand(); err == nil ||
+		!strings.Contains(err.Error(), "could not parse") {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	sc.KeyCommand = "echo oops >&2; exit 1"
+	if _, err := sc.loadKeyCommand(); err == nil ||
+		!strings.Contains(err.Error(), "oops") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestLoadKeyCommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh")
+	}
+	orig := keyCommandTimeout
+	keyCommandTimeout = 50 * time.Millisecond
+	t.Cleanup(func() { keyCommandTimeout = orig })
+
+	sc := &SSHConfig{Alias: "test", KeyCommand: "sleep 5"}
+	if _, err := sc.loadKeyCommand(); err == nil ||
+		!strings.Contains(err.Error(), "timed out") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
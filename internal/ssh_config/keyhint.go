@@ -0,0 +1,41 @@
+package ssh_config
+
+import (
+	"bytes"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keyParseError explains why the private key data could not be parsed,
+// with advice for formats that can be converted
+func keyParseError(data []byte, err error) error {
+	trimmed := bytes.TrimSpace(data)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("PuTTY-User-Key-File-")):
+		return fmt.Errorf("PuTTY key files (.ppk) are not supported, convert it to OpenSSH format " +
+			"with 'puttygen <file> -O private-openssh -o <new file>' or via Conversions > Export OpenSSH key in PuTTYgen")
+	case bytes.HasPrefix(trimmed, []byte("SSH PRIVATE KEY FILE FORMAT 1.1")):
+		return fmt.Errorf("SSH1 keys are not supported, create a new key with ssh-keygen")
+	case errors.As(err, &missing):
+		return fmt.Errorf("key is protected by a passphrase, add it to ssh-agent with ssh-add")
+	}
+
+	block, _ := pem.Decode(trimmed)
+	if block == nil {
+		if len(trimmed) > 0 && trimmed[0] == 0x30 {
+			// An ASN.1 sequence, as DER-encoded keys start with
+			return fmt.Errorf("key appears to be DER-encoded, convert it to PEM "+
+				"with 'openssl pkey -inform DER -in <file> -out <new file>': %v", err)
+		}
+		return fmt.Errorf("not a PEM-encoded private key: %v", err)
+	}
+	if strings.Contains(err.Error(), "unsupported key type") {
+		return fmt.Errorf("unsupported key type %q, supported are RSA, ECDSA and Ed25519 keys", block.Type)
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestKeyParseError(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct {
+		data, want string
+	}{
+		{"PuTTY-User-Key-File-3: ssh-ed25519\nEncryption: none\n", "puttygen"},
+		{"SSH PRIVATE KEY FILE FORMAT 1.1\n", "SSH1"},
+		{"\x30\x82\x01\x0a\x02\x01", "openssl pkey -inform DER"},
+		{"-----BEGIN FOO KEY-----\nAAAA\n-----END FOO KEY-----\n", `"FOO KEY"`},
+		{"hello", "not a PEM-encoded"},
+	}
+	for i, c := range cases {
+		f := filepath.Join(dir, "key")
+		if err := os.WriteFile(f, []byte(c.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := loadPrivateKey(f)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("case %d: expected error containing %q, got %v", i, c.want, err)
+		}
+	}
+}
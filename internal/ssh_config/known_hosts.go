@@ -2,9 +2,14 @@ package ssh_config
 
 import (
 	"errors"
+	"fmt"
 	"net"
+	"path"
+	"slices"
+	"strconv"
 	"strings"
 
+	"github.com/alebeck/boring/internal/log"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/knownhosts"
 )
@@ -69,3 +74,163 @@ func extractHostKeyAlgos(cb ssh.HostKeyCallback, host string) (res []string) {
 	}
 	return
 }
+
+// hostCertCallback verifies host certificates against the authorities in
+// known, like OpenSSH: host names are matched case-insensitively and
+// principals may contain wildcards, e.g. "*.example.com". Plain keys are
+// verified by known.
+func hostCertCallback(known ssh.HostKeyCallback) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		host = strings.ToLower(host)
+		cert, ok := key.(*ssh.Certificate)
+		if !ok || cert.CertType != ssh.HostCert || !isHostAuthority(known, host, remote, cert.SignatureKey) {
+			return known(host, remote, key)
+		}
+		for _, k := range []ssh.PublicKey{cert.Key, cert.SignatureKey} {
+			var re *knownhosts.RevokedError
+			if err := known(host, remote, k); errors.As(err, &re) {
+				return err
+			}
+		}
+		name, _, err := net.SplitHostPort(host)
+		if err != nil {
+			return err
+		}
+		var checker ssh.CertChecker
+		return checker.CheckCert(matchPrincipal(name, cert.ValidPrincipals), cert)
+	}
+}
+
+// matchPrincipal returns the principal that name matches, or name itself
+// if none does
+func matchPrincipal(name string, principals []string) string {
+	for _, p := range principals {
+		if ok, _ := path.Match(strings.ToLower(p), name); ok {
+			return p
+		}
+	}
+	return name
+}
+
+// FingerprintOf returns the SHA256 fingerprint of a key in the format used
+// by OpenSSH. For certificates, the certified key is fingerprinted.
+func FingerprintOf(key ssh.PublicKey) string {
+	if c, ok := key.(*ssh.Certificate); ok {
+		key = c.Key
+	}
+	return ssh.FingerprintSHA256(key)
+}
+
+// pinnedCallback accepts only a host key matching the SHA256 fingerprint fp.
+func pinnedCallback(fp string) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		if got := FingerprintOf(key); got != fp {
+			return fmt.Errorf("host key fingerprint %v does not match pinned %v", got, fp)
+		}
+		return nil
+	}
+}
+
+// lookupHost is net.LookupHost, replaceable in tests
+var lookupHost = net.LookupHost
+
+// resolveHostPorts returns the addresses hostName resolves to, joined with
+// port
+func resolveHostPorts(hostName string, port int) []string {
+	ips, err := lookupHost(hostName)
+	if err != nil {
+		log.Debugf("could not resolve %v: %v", hostName, err)
+		return nil
+	}
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if ip != hostName {
+			addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(port)))
+		}
+	}
+	return addrs
+}
+
+// anyAddressCallback accepts a key not known for the host if known for any
+// of the addresses hostName resolves to. With DNS round-robin, each of them
+// may present its own key.
+func anyAddressCallback(known ssh.HostKeyCallback, hostName string, port int) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(host, remote, key)
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) {
+			// Accepted or revoked
+			return err
+		}
+		for _, a := range resolveHostPorts(hostName, port) {
+			if known(a, remote, key) == nil {
+				log.Debugf("%v: host key known for %v", host, a)
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// acceptedKeysCallback accepts keys with one of the SHA256 fingerprints fps
+// and verifies others by known. Keys revoked in known are rejected even if
+// accepted.
+func acceptedKeysCallback(known ssh.HostKeyCallback, fps []string) ssh.HostKeyCallback {
+	return func(host string, remote net.Addr, key ssh.PublicKey) error {
+		err := known(host, remote, key)
+		if err == nil || !slices.Contains(fps, FingerprintOf(key)) {
+			return err
+		}
+		var re *knownhosts.RevokedError
+		if errors.As(err, &re) {
+			return err
+		}
+		if c, ok := key.(*ssh.Certificate); ok {
+			// The fingerprint is that of the certified key
+			if err := known(host, remote, c.Key); errors.As(err, &re) {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// KnownHostKey is a key trusted for a host in a known_hosts file
+type KnownHostKey struct {
+	Type        string
+	Fingerprint string
+	// Authority is set for keys trusted to sign host certificates
+	Authority bool
+	File      string
+	Line      int
+}
+
+// KnownHostKeys returns the keys trusted for the host sc connects to,
+// matching hashed and plain entries alike, along with the host as looked
+// up in known_hosts.
+func (sc *SSHConfig) KnownHostKeys() (string, []KnownHostKey, error) {
+	hostPort := net.JoinHostPort(sc.HostName, strconv.Itoa(sc.Port))
+	cb, err := knownhosts.New(sc.existingKnownHosts()...)
+	if err != nil {
+		return "", nil, fmt.Errorf("knownhosts: %v", err)
+	}
+
+	var keys []KnownHostKey
+	addr := &net.TCPAddr{IP: net.IPv4zero}
+	var ke *knownhosts.KeyError
+	if err := cb(hostPort, addr, dummyKey{}); errors.As(err, &ke) {
+		for _, k := range ke.Want {
+			if k.Key == nil {
+				continue
+			}
+			keys = append(keys, KnownHostKey{
+				Type:        k.Key.Type(),
+				Fingerprint: FingerprintOf(k.Key),
+				Authority:   isHostAuthority(cb, hostPort, addr, k.Key),
+				File:        k.Filename,
+				Line:        k.Line,
+			})
+		}
+	}
+	return knownhosts.Normalize(hostPort), keys, nil
+}
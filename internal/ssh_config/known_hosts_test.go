@@ -4,7 +4,9 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"io"
+	"net"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -72,6 +74,61 @@ func TestExtractHostKeyAlgosCertAuthority(t *testing.T) {
 	}
 }
 
+func TestHostCertCallback(t *testing.T) {
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey := edPub(t)
+	certFor := func(principals ...string) *ssh.Certificate {
+		c := &ssh.Certificate{Key: hostKey, CertType: ssh.HostCert,
+			ValidPrincipals: principals, ValidBefore: ssh.CertTimeInfinity}
+		if err := c.SignCert(rand.Reader, ca); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	authority := "@cert-authority " + knownhosts.Line([]string{"*.example.test"}, ca.PublicKey()) + "\n"
+	cb := hostCertCallback(callbackFor(t, authority))
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 22}
+
+	cases := []struct {
+		host       string
+		principals []string
+		ok         bool
+	}{
+		{"host.example.test", []string{"host.example.test"}, true},
+		// Host names are matched case-insensitively, like OpenSSH does
+		{"Host.Example.TEST", []string{"host.example.test"}, true},
+		{"host.example.test", []string{"*.example.test"}, true},
+		{"host.example.test", nil, true},
+		{"host.example.test", []string{"other.example.test"}, false},
+		{"host.other.test", []string{"host.other.test"}, false},
+	}
+	for _, c := range cases {
+		err := cb(net.JoinHostPort(c.host, "22"), remote, certFor(c.principals...))
+		if (err == nil) != c.ok {
+			t.Errorf("%v with principals %v: got %v", c.host, c.principals, err)
+		}
+	}
+
+	// Certificate algorithms are offered despite the host name's case
+	if algos := extractHostKeyAlgos(cb, "HOST.example.test:22"); !reflect.DeepEqual(algos, allCertAlgos) {
+		t.Errorf("got algorithms %v", algos)
+	}
+
+	// Revoked authorities are rejected
+	revoked := authority + "@revoked " + knownhosts.Line([]string{"*"}, ca.PublicKey()) + "\n"
+	cb = hostCertCallback(callbackFor(t, revoked))
+	if err := cb("host.example.test:22", remote, certFor("host.example.test")); err == nil {
+		t.Error("accepted certificate of revoked authority")
+	}
+}
+
 // Plain (non-CA) known_hosts entries must yield only plain algorithms,
 // including the RSA SHA-2 expansions, and never a *-cert-v01 algorithm.
 func TestExtractHostKeyAlgosPlain(t *testing.T) {
@@ -126,3 +183,110 @@ func TestMakeCallbackAndAlgos(t *testing.T) {
 		t.Fatalf("not narrowed to pinned type: got %v, want %v", algs, want)
 	}
 }
+
+func TestPinnedCallback(t *testing.T) {
+	k := edPub(t)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+	cb := pinnedCallback(ssh.FingerprintSHA256(k))
+	if err := cb(testHostPort, addr, k); err != nil {
+		t.Errorf("pinned key rejected: %v", err)
+	}
+	if err := cb(testHostPort, addr, edPub(t)); err == nil {
+		t.Error("expected other key to be rejected")
+	}
+}
+
+func TestFingerprintOfCert(t *testing.T) {
+	k := edPub(t)
+	cert := &ssh.Certificate{Key: k, CertType: ssh.HostCert}
+	if FingerprintOf(cert) != ssh.FingerprintSHA256(k) {
+		t.Error("certificate fingerprint does not match certified key")
+	}
+}
+
+func TestKnownHostKeys(t *testing.T) {
+	plain, hashed, ca, other := edPub(t), rsaPub(t), edPub(t), edPub(t)
+	lines := knownhosts.Line([]string{testHostPort}, plain) + "\n" +
+		knownhosts.Line([]string{knownhosts.HashHostname(knownhosts.Normalize(testHostPort))}, hashed) + "\n" +
+		"# comment\n" +
+		"@cert-authority " + knownhosts.Line([]string{testHostPort}, ca) + "\n" +
+		knownhosts.Line([]string{"otherhost"}, other) + "\n"
+	p := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(p, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	sc := &SSHConfig{HostName: "127.0.0.1", Port: 2222, KnownHostsFiles: []string{p}}
+
+	host, keys, err := sc.KnownHostKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if host != "[127.0.0.1]:2222" {
+		t.Errorf("got host %q", host)
+	}
+	want := []KnownHostKey{
+		{Type: plain.Type(), Fingerprint: FingerprintOf(plain), File: p, Line: 1},
+		{Type: hashed.Type(), Fingerprint: FingerprintOf(hashed), File: p, Line: 2},
+		{Type: ca.Type(), Fingerprint: FingerprintOf(ca), Authority: true, File: p, Line: 4},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %+v, want %+v", keys, want)
+	}
+
+	sc.HostName = "unknown"
+	if _, keys, err = sc.KnownHostKeys(); err != nil || len(keys) != 0 {
+		t.Errorf("got %v, %v for unknown host", keys, err)
+	}
+}
+
+func TestAnyAddressCallback(t *testing.T) {
+	k1, k2, other := edPub(t), edPub(t), edPub(t)
+	cb := callbackFor(t,
+		knownhosts.Line([]string{"bastion.example.com"}, k1)+"\n"+
+			knownhosts.Line([]string{"10.0.0.2"}, k2)+"\n")
+	orig := lookupHost
+	lookupHost = func(string) ([]string, error) { return []string{"10.0.0.1", "10.0.0.2"}, nil }
+	t.Cleanup(func() { lookupHost = orig })
+
+	host := "bastion.example.com:22"
+	remote := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 22}
+	if err := cb(host, remote, k2); err == nil {
+		t.Fatal("expected plain callback to reject key of other address")
+	}
+	anyCb := anyAddressCallback(cb, "bastion.example.com", 22)
+	for _, k := range []ssh.PublicKey{k1, k2} {
+		if err := anyCb(host, remote, k); err != nil {
+			t.Errorf("expected key to be accepted: %v", err)
+		}
+	}
+	if err := anyCb(host, remote, other); err == nil {
+		t.Error("expected unknown key to be rejected")
+	}
+}
+
+func TestAcceptedKeysCallback(t *testing.T) {
+	known, accepted, other := edPub(t), edPub(t), edPub(t)
+	cb := acceptedKeysCallback(callbackFor(t, knownhosts.Line([]string{testHostPort}, known)+"\n"),
+		[]string{FingerprintOf(accepted)})
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+	for _, k := range []ssh.PublicKey{known, accepted} {
+		if err := cb(testHostPort, addr, k); err != nil {
+			t.Errorf("expected key to be accepted: %v", err)
+		}
+	}
+	if err := cb(testHostPort, addr, other); err == nil {
+		t.Error("expected other key to be rejected")
+	}
+
+	// Revoked keys are rejected even if accepted
+	revoked := "@revoked " + knownhosts.Line([]string{"*"}, accepted) + "\n"
+	cb = acceptedKeysCallback(callbackFor(t, revoked), []string{FingerprintOf(accepted)})
+	var re *knownhosts.RevokedError
+	if err := cb(testHostPort, addr, accepted); !errors.As(err, &re) {
+		t.Errorf("expected revoked key to be rejected, got %v", err)
+	}
+	cert := &ssh.Certificate{Key: accepted, CertType: ssh.HostCert}
+	if err := cb(testHostPort, addr, cert); !errors.As(err, &re) {
+		t.Errorf("expected certificate for revoked key to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,54 @@
+package ssh_config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/alebeck/boring/internal/log"
+)
+
+// ParseRekeyLimit parses the amount of data after which keys are
+// renegotiated, as in the first argument of the RekeyLimit option, e.g.,
+// "4G". Suffixes K, M and G denote binary multiples. "default" yields 0,
+// i.e., a threshold suitable for the chosen cipher.
+func ParseRekeyLimit(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "default") {
+		return 0, nil
+	}
+	mult := uint64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || n == 0 {
+		return 0, fmt.Errorf("invalid rekey limit %q", s)
+	}
+	return n * mult, nil
+}
+
+// parseRekeyOption parses the RekeyLimit option. Time-based rekeying, its
+// optional second argument, is not supported and ignored.
+func parseRekeyOption(s string) (uint64, error) {
+	f := strings.Fields(s)
+	if len(f) == 0 {
+		return 0, nil
+	}
+	if len(f) > 1 && f[1] != "none" {
+		log.Debugf("RekeyLimit interval %q not supported, ignoring", f[1])
+	}
+	n, err := ParseRekeyLimit(f[0])
+	if err != nil {
+		return 0, fmt.Errorf("unsupported RekeyLimit option '%v'", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,28 @@
+package ssh_config
+
+import "testing"
+
+func TestParseRekeyOption(t *testing.T) {
+	cases := []struct {
+		in   string
+		want uint64
+		err  bool
+	}{
+		{"", 0, false},
+		{"default none", 0, false},
+		{"1000", 1000, false},
+		{"512K", 512 << 10, false},
+		{"4G 1h", 4 << 30, false},
+		{"2m", 2 << 20, false},
+		{"0", 0, true},
+		{"lots", 0, true},
+		{"G", 0, true},
+	}
+	for _, c := range cases {
+		got, err := parseRekeyOption(c.in)
+		if (err != nil) != c.err || got != c.want {
+			t.Errorf("parseRekeyOption(%q) = %v, %v; want %v, error %v",
+				c.in, got, err, c.want, c.err)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package ssh_config
+
+import (
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	ossh_config "github.com/alebeck/ssh_config"
+)
+
+// resolvedKeys are the directives read by boring, in display order
+var resolvedKeys = []string{
+	"HostName", "User", "Port", "ProxyJump", "IdentityFile", "IdentitiesOnly",
+	"CertificateFile", "PreferredAuthentications", "AddKeysToAgent",
+	"StrictHostKeyChecking", "UserKnownHostsFile", "GlobalKnownHostsFile", "HashKnownHosts",
+	"Ciphers", "MACs", "HostKeyAlgorithms", "KexAlgorithms", "RekeyLimit", "IPQoS", "ExitOnForwardFailure",
+	"ControlMaster", "ControlPath", "ControlPersist", "Tag",
+}
+
+// multiKeys are directives that may be given multiple times
+var multiKeys = map[string]bool{
+	"IdentityFile": true, "CertificateFile": true,
+	"UserKnownHostsFile": true, "GlobalKnownHostsFile": true,
+}
+
+// Directive is an ssh config directive as it applies to an alias
+type Directive struct {
+	Key    string
+	Values []string
+	// Source is the config file the values were read from, including the
+	// files it includes, or "default"
+	Source string
+}
+
+// Resolution describes what the ssh config resolves to for an alias
+type Resolution struct {
+	// Config is the configuration used for connecting
+	Config *SSHConfig
+	// Directives are the raw values found, before token expansion
+	Directives []Directive
+}
+
+// Resolve returns the ssh config boring would use for alias, along with
+// the directives it was derived from and their origin. file, if set, is
+// read instead of the user's ssh config. No connection is made.
+func Resolve(alias, user, file string) (*Resolution, error) {
+	sc, err := ParseSSHConfigFile(alias, user, file)
+	if err != nil {
+		return nil, err
+	}
+	res := &Resolution{Config: sc}
+
+	files := configFiles(file)
+	all := userSettings(file)
+	for _, key := range resolvedKeys {
+		vals := lookup(all, alias, key, user)
+		if len(vals) == 0 {
+			continue
+		}
+		d := Directive{Key: key, Values: vals, Source: "default"}
+		if key == "IdentityFile" {
+			d.Values = identityFiles(vals)
+		}
+		for _, f := range files {
+			us := ossh_config.MakeDefaultUserSettings()
+			us.ConfigFinder(func() string { return f })
+			v := lookup(us, alias, key, user)
+			if len(v) > 0 && !isDefault(key, v) {
+				d.Source = f
+				break
+			}
+		}
+		res.Directives = append(res.Directives, d)
+	}
+	return res, nil
+}
+
+func lookup(us *ossh_config.UserSettings, alias, key, user string) []string {
+	if multiKeys[key] {
+		return us.GetAll(alias, key, user)
+	}
+	if v := us.Get(alias, key, user); v != "" {
+		return []string{v}
+	}
+	return nil
+}
+
+// configFiles returns the ssh config files consulted, in order of precedence
+func configFiles(file string) []string {
+	if file == "" && overrideConfig != "" {
+		return []string{overrideConfig}
+	}
+	var files []string
+	if file != "" {
+		files = append(files, file)
+	} else if u, err := user.Current(); err == nil {
+		files = append(files, filepath.Join(u.HomeDir, ".ssh", "config"))
+	}
+	return append(files, filepath.Join("/", "etc", "ssh", "ssh_config"))
+}
+
+// Override is a value given outside the ssh config, which takes precedence
+type Override struct {
+	Key, Value string
+	// Source names where the value is given, e.g., "tunnel"
+	Source string
+}
+
+// Setting is a value used for connecting, along with its source
+type Setting struct {
+	Key, Value, Source string
+}
+
+// Effective returns the host name, user, port and identity files used for
+// connecting, and the source providing each: an override, an ssh config
+// file, or "default". Overrides apply in the order given, later ones
+// taking precedence.
+func (r *Resolution) Effective(overrides ...Override) []Setting {
+	sc := r.Config
+	source := func(key string) string {
+		for _, d := range r.Directives {
+			if d.Key == key {
+				return d.Source
+			}
+		}
+		return "default"
+	}
+
+	user, userSrc := sc.User, source("User")
+	if user == "" {
+		// Like when connecting, see EnsureUser
+		tmp := &SSHConfig{}
+		tmp.EnsureUser()
+		user, userSrc = tmp.User, "default ($USER)"
+	}
+	hostName, hostSrc := sc.HostName, source("HostName")
+	if hostName == "" {
+		hostName, hostSrc = sc.Alias, "default (alias)"
+	}
+	idSrc := source("IdentityFile")
+	if idSrc == "default" && !slices.Equal(defaultIdentityFiles, builtinIdentityFiles) {
+		idSrc = "default_identity_files"
+	}
+
+	settings := []Setting{
+		{"HostName", hostName, hostSrc},
+		{"User", user, userSrc},
+		{"Port", strconv.Itoa(sc.Port), source("Port")},
+		{"IdentityFile", strings.Join(sc.IdentityFiles, " "), idSrc},
+	}
+	for _, o := range overrides {
+		for i := range settings {
+			if settings[i].Key == o.Key && o.Value != "" {
+				settings[i].Value, settings[i].Source = o.Value, o.Source
+			}
+		}
+	}
+	return settings
+}
@@ -0,0 +1,124 @@
+package ssh_config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	conf := "Host myhost\n\tHostName example.com\n\tUser bob\n" +
+		"\tIdentityFile ~/.ssh/id_a\n\tIdentityFile ~/.ssh/id_b\n\tProxyJump jump\n\tTag fleet\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := overrideConfig
+	overrideConfig = cfg
+	t.Cleanup(func() { overrideConfig = old })
+
+	res, err := Resolve("myhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Config.HostName != "example.com" || res.Config.User != "bob" || res.Config.Tag != "fleet" {
+		t.Errorf("unexpected config: %+v", res.Config)
+	}
+
+	got := make(map[string]Directive)
+	for _, d := range res.Directives {
+		got[d.Key] = d
+	}
+	for _, key := range []string{"HostName", "User", "ProxyJump", "Tag"} {
+		if got[key].Source != cfg {
+			t.Errorf("%v: got source %q, want %q", key, got[key].Source, cfg)
+		}
+	}
+	if d := got["IdentityFile"]; len(d.Values) != 2 || d.Source != cfg {
+		t.Errorf("unexpected IdentityFile directive: %+v", d)
+	}
+	if d := got["Port"]; len(d.Values) != 1 || d.Values[0] != "22" || d.Source != "default" {
+		t.Errorf("unexpected Port directive: %+v", d)
+	}
+}
+
+func TestDefaultIdentityFiles(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	conf := "Host a\n\tHostName example.com\nHost b\n\tIdentityFile ~/.ssh/id_b\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := overrideConfig
+	overrideConfig = cfg
+	t.Cleanup(func() { overrideConfig = old })
+
+	res, err := Resolve("a", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(res.Config.IdentityFiles, builtinIdentityFiles) {
+		t.Errorf("got %v, want built-in defaults", res.Config.IdentityFiles)
+	}
+	for _, d := range res.Directives {
+		if d.Key == "IdentityFile" && d.Source != "default" {
+			t.Errorf("got source %q for default identity files", d.Source)
+		}
+	}
+
+	SetDefaultIdentityFiles([]string{"/keys/id_dsa"})
+	t.Cleanup(func() { SetDefaultIdentityFiles(nil) })
+	sc, err := ParseSSHConfig("a", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sc.IdentityFiles, []string{"/keys/id_dsa"}) {
+		t.Errorf("got %v, want configured defaults", sc.IdentityFiles)
+	}
+
+	// Explicitly configured files are kept
+	if sc, err = ParseSSHConfig("b", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.IdentityFiles) != 1 || !strings.HasSuffix(sc.IdentityFiles[0], "id_b") {
+		t.Errorf("got %v, want configured IdentityFile", sc.IdentityFiles)
+	}
+}
+
+func TestEffective(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	conf := "Host myhost\n\tUser bob\n\tIdentityFile ~/.ssh/id_a\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := overrideConfig
+	overrideConfig = cfg
+	t.Cleanup(func() { overrideConfig = old })
+
+	res, err := Resolve("myhost", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]Setting)
+	for _, s := range res.Effective(
+		Override{Key: "User", Value: "alice", Source: "tunnel"},
+		Override{Key: "IdentityFile", Source: "tunnel"},
+		Override{Key: "User", Value: "carol", Source: "host"},
+	) {
+		got[s.Key] = s
+	}
+	want := map[string]Setting{
+		"HostName": {"HostName", "myhost", "default (alias)"},
+		"User":     {"User", "carol", "host"},
+		"Port":     {"Port", "22", "default"},
+	}
+	for k, w := range want {
+		if got[k] != w {
+			t.Errorf("%v: got %+v, want %+v", k, got[k], w)
+		}
+	}
+	if s := got["IdentityFile"]; s.Source != cfg || !strings.HasSuffix(s.Value, "id_a") {
+		t.Errorf("unexpected IdentityFile: %+v", s)
+	}
+}
@@ -0,0 +1,32 @@
+package ssh_config
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SignerProvider supplies signers from a custom source, e.g., a KMS or an
+// HSM service, in addition to or instead of key files and ssh-agent.
+type SignerProvider interface {
+	Signers() ([]ssh.Signer, error)
+}
+
+// SignersFunc adapts an ordinary function to a SignerProvider.
+type SignersFunc func() ([]ssh.Signer, error)
+
+func (f SignersFunc) Signers() ([]ssh.Signer, error) {
+	return f()
+}
+
+// providedSigners returns the signers of the custom provider, if any
+func (sc *SSHConfig) providedSigners() ([]ssh.Signer, error) {
+	if sc.Signers == nil {
+		return nil, nil
+	}
+	sigs, err := sc.Signers.Signers()
+	if err != nil {
+		return nil, fmt.Errorf("could not get signers from provider: %v", err)
+	}
+	return sigs, nil
+}
@@ -0,0 +1,65 @@
+package ssh_config
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestProvidedSigners(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	dir := t.TempDir()
+	priv, _ := writeKeyPair(t, dir, "id_test")
+	custom := testSigner(t)
+
+	sc := &SSHConfig{
+		Alias:         "test",
+		IdentityFiles: []string{priv},
+		Signers: SignersFunc(func() ([]ssh.Signer, error) {
+			return []ssh.Signer{custom}, nil
+		}),
+	}
+	sigs, err := sc.makeSigners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sigs) != 2 || keyFP(sigs[0].PublicKey()) != keyFP(custom.PublicKey()) {
+		t.Fatalf("expected provided signer first, got %v", sigs)
+	}
+
+	sc.SignersOnly = true
+	if sigs, err = sc.makeSigners(); err != nil || len(sigs) != 1 {
+		t.Fatalf("expected only the provided signer, got %v, %v", sigs, err)
+	}
+}
+
+func TestProvidedSignersError(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	sc := &SSHConfig{
+		Alias: "test",
+		Signers: SignersFunc(func() ([]ssh.Signer, error) {
+			return nil, errors.New("kms unavailable")
+		}),
+		SignersOnly: true,
+	}
+	if _, err := sc.makeSigners(); err == nil ||
+		!strings.Contains(err.Error(), "no signers provided") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
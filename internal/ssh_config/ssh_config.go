@@ -1,12 +1,16 @@
 package ssh_config
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"net"
 	"os"
 	"os/user"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/alebeck/boring/internal/agent"
@@ -24,6 +28,19 @@ const (
 
 var overrideConfig = os.Getenv("BORING_SSH_CONFIG")
 
+// userSettings returns the settings read from file, or else the user's ssh
+// config, along with the system-wide one
+func userSettings(file string) *ossh_config.UserSettings {
+	us := ossh_config.MakeDefaultUserSettings()
+	if file == "" {
+		file = overrideConfig
+	}
+	if file != "" {
+		us.ConfigFinder(func() string { return file })
+	}
+	return us
+}
+
 type keyCheck int
 
 const (
@@ -33,16 +50,33 @@ const (
 	strict keyCheck = iota
 	// Accepts all hosts, this corresponds to "no" and "off" options
 	off
-	// TODO: support "accept-new" option?
+	// Like strict, but keys of unknown hosts are added to known_hosts
+	acceptNew
 )
 
 // Hop holds information needed to establish a single SSH hop
 type Hop struct {
 	HostName string
 	Port     int
+	// TOS is the type of service packets to the hop are marked with, if
+	// not 0, as set by IPQoS
+	TOS int
+	// PrunedAuth, if set, returns authentication methods offering only the
+	// configured identities, to retry with after too many authentication
+	// failures
+	PrunedAuth func() ([]ssh.AuthMethod, error)
+	// Auth, if set, builds the authentication methods anew for each
+	// connection, as registered methods may hold short-lived credentials
+	Auth func() ([]ssh.AuthMethod, error)
 	*ssh.ClientConfig
 }
 
+// Addr returns the network address of the hop. IPv6 literals are bracketed,
+// keeping a zone (as in fe80::1%eth0) if present.
+func (h *Hop) Addr() string {
+	return net.JoinHostPort(h.HostName, strconv.Itoa(h.Port))
+}
+
 // SSHConfig represents an SSH config read from, e.g., ~/.ssh/config
 type SSHConfig struct {
 	Alias            string
@@ -58,7 +92,51 @@ type SSHConfig struct {
 	Macs             []string
 	HostKeyAlgos     []string
 	KexAlgos         []string
-	Jumps            []*jumpSpec
+	// ConfigFile is the ssh config file read instead of the user's, if set
+	ConfigFile string
+	// Tag is the configuration tag of the host, as set by Tag
+	Tag string
+	// NewHostsFile is where keys of new hosts are added with accept-new
+	NewHostsFile string
+	// HashKnownHosts hashes host names of entries added to known_hosts
+	HashKnownHosts bool
+	// ExitOnForwardFailure closes the connection if a forward cannot be
+	// established, rather than keeping it up without the forward
+	ExitOnForwardFailure bool
+	// ControlPath identifies the connection shared between tunnels to the
+	// host, it is empty if ControlMaster does not enable sharing
+	ControlPath string
+	// ControlPersist is how long a shared connection is kept open once no
+	// tunnel uses it anymore, negative values keep it open indefinitely
+	ControlPersist time.Duration
+	// IPQoS is the type of service to mark packets with, 0 if unset
+	IPQoS int
+	// RekeyThreshold is the number of bytes after which keys are
+	// renegotiated, 0 selects a default suitable for the cipher
+	RekeyThreshold uint64
+	Jumps          []*jumpSpec
+	// PreferredAuths lists authentication methods in order of preference
+	PreferredAuths []string
+	// Password is used for password and keyboard-interactive authentication
+	Password string
+	// PinnedFingerprint, if set, is the SHA256 fingerprint of the only host
+	// key accepted for this host, regardless of known_hosts
+	PinnedFingerprint string
+	// AcceptedHostKeys are SHA256 fingerprints of host keys accepted in
+	// addition to those in known_hosts
+	AcceptedHostKeys []string
+	// HostKeyAnyAddress accepts host keys known for any of the addresses
+	// HostName resolves to, as for DNS round-robin
+	HostKeyAnyAddress bool
+	// KeyCommand, if set, is run to obtain a private key on stdout
+	KeyCommand string
+	// AddKeysToAgent controls whether keys not obtained from ssh-agent
+	// are added to it once used
+	AddKeysToAgent addKeysPolicy
+	// Signers, if set, provides signers from a custom source, which are
+	// tried first. If SignersOnly is set, no other keys are loaded.
+	Signers     SignerProvider
+	SignersOnly bool
 }
 
 var (
@@ -71,12 +149,74 @@ var (
 )
 
 func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
+	return ParseSSHConfigFile(alias, user, "")
+}
+
+// parseKeyCheck parses a StrictHostKeyChecking option
+func parseKeyCheck(s string) (keyCheck, error) {
+	switch s {
+	case "no", "off":
+		return off, nil
+	case "accept-new":
+		return acceptNew, nil
+	case "yes", "ask":
+		return strict, nil
+	}
+	return strict, fmt.Errorf("unsupported StrictHostKeyChecking option '%v'", s)
+}
+
+// parseJumps parses the comma-separated jump hosts of ProxyJump
+func parseJumps(pj string) ([]*jumpSpec, error) {
+	if pj == "" {
+		return nil, nil
+	}
+	var jumps []*jumpSpec
+	for _, j := range split(pj) {
+		jump, err := parseProxyJump(j)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse jump host: %v", err)
+		}
+		jumps = append(jumps, jump)
+	}
+	return jumps, nil
+}
+
+// proxyCommandWarned holds the aliases warned about ProxyCommand, which
+// would otherwise be repeated on each connection attempt
+var proxyCommandWarned sync.Map
+
+func warnProxyCommand(alias string) {
+	if _, warned := proxyCommandWarned.LoadOrStore(alias, true); !warned {
+		log.Warningf("%v: ProxyCommand not supported, connecting directly", alias)
+	}
+}
+
+// setKnownHosts sets the known_hosts files to check, and the one to add
+// new keys to
+func (c *SSHConfig) setKnownHosts(global, user []string) {
+	for _, h := range append(global, user...) {
+		c.KnownHostsFiles = append(c.KnownHostsFiles, strings.Split(h, " ")...)
+	}
+	if len(user) > 0 {
+		// Like ssh(1), new keys go to the first user file
+		c.NewHostsFile = strings.Split(user[0], " ")[0]
+	}
+	if newHostsFile != "" {
+		c.NewHostsFile = newHostsFile
+		if !slices.Contains(c.KnownHostsFiles, newHostsFile) {
+			// Keys added must be found on the next connect
+			c.KnownHostsFiles = append(c.KnownHostsFiles, newHostsFile)
+		}
+	}
+}
+
+// ParseSSHConfigFile is like ParseSSHConfig, but reads file instead of the
+// user's ssh config if set. The system-wide config still applies. Jump
+// hosts are resolved against the same file.
+func ParseSSHConfigFile(alias, user, file string) (*SSHConfig, error) {
 	// We create a new ssh_config.UserSettings object at each connection so that
 	// config file changes are reflected immediately.
-	us := ossh_config.MakeDefaultUserSettings()
-	if overrideConfig != "" {
-		us.ConfigFinder(func() string { return overrideConfig })
-	}
+	us := userSettings(file)
 
 	// This is a "strict" dummy query to catch potential parsing errors early
 	if _, err := us.GetStrict(alias, "HostName", ""); err != nil {
@@ -87,7 +227,7 @@ func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
 	get := func(key string) string { return us.Get(alias, key, user) }
 	getAll := func(key string) []string { return us.GetAll(alias, key, user) }
 
-	c := &SSHConfig{Alias: alias}
+	c := &SSHConfig{Alias: alias, ConfigFile: file}
 	sub := makeSubst(alias)
 
 	if c.HostName = sub.apply(get("HostName"), hostnameTokens); c.HostName != "" {
@@ -99,44 +239,57 @@ func ParseSSHConfig(alias, user string) (*SSHConfig, error) {
 	c.Port, _ = strconv.Atoi(get("Port"))
 	sub["%p"] = fmt.Sprintf("%d", c.Port)
 
-	s := get("StrictHostKeyChecking")
-	if s == "no" || s == "off" {
-		c.KeyCheck = off
-	} else if s == "accept-new" {
-		log.Warningf(
-			"StrictHostKeyChecking 'accept-new' not supported, using 'yes'")
-	} else if s != "yes" && s != "ask" {
-		return nil, fmt.Errorf(
-			"unsupported StrictHostKeyChecking option '%v'", s)
+	var err error
+	if c.KeyCheck, err = parseKeyCheck(get("StrictHostKeyChecking")); err != nil {
+		return nil, err
 	}
 
 	c.Ciphers = split(get("Ciphers"))
 	c.Macs = split(get("MACs"))
 	c.HostKeyAlgos = split(get("HostKeyAlgorithms"))
 	c.KexAlgos = split(get("KexAlgorithms"))
+	c.Tag = get("Tag")
 
-	// Jump hosts
+	// Jump hosts. "none" overrides a more general setting and means direct.
 	pj := sub.apply(get("ProxyJump"), proxyTokens)
+	if pj == "none" {
+		pj = ""
+	}
 	sub["%j"] = pj
-	if pj != "" {
-		for _, j := range split(pj) {
-			jump, err := parseProxyJump(j)
-			if err != nil {
-				return nil, fmt.Errorf("could not parse jump host: %v", err)
-			}
-			c.Jumps = append(c.Jumps, jump)
-		}
+	if c.Jumps, err = parseJumps(pj); err != nil {
+		return nil, err
+	}
+
+	if pc := get("ProxyCommand"); pc != "" && pc != "none" {
+		warnProxyCommand(alias)
+	}
+
+	c.PreferredAuths = split(get("PreferredAuthentications"))
+
+	if c.AddKeysToAgent, err = parseAddKeys(get("AddKeysToAgent")); err != nil {
+		return nil, err
+	}
+
+	if c.RekeyThreshold, err = parseRekeyOption(get("RekeyLimit")); err != nil {
+		return nil, err
 	}
 
 	c.IdentitiesOnly = get("IdentitiesOnly") == "yes"
-	c.IdentityFiles = sub.applyAll(getAll("IdentityFile"), identFileTokens)
+	c.IdentityFiles = sub.applyAll(identityFiles(getAll("IdentityFile")), identFileTokens)
 	c.CertificateFiles = getAll("CertificateFile")
 
-	// Known hosts
-	hosts := getAll("GlobalKnownHostsFile")
-	hosts = append(hosts, sub.applyAll(getAll("UserKnownHostsFile"), identFileTokens)...)
-	for _, h := range hosts {
-		c.KnownHostsFiles = append(c.KnownHostsFiles, strings.Split(h, " ")...)
+	c.setKnownHosts(getAll("GlobalKnownHostsFile"),
+		sub.applyAll(getAll("UserKnownHostsFile"), identFileTokens))
+	c.HashKnownHosts = get("HashKnownHosts") == "yes"
+	c.ExitOnForwardFailure = get("ExitOnForwardFailure") == "yes"
+
+	if c.IPQoS, err = ParseIPQoS(get("IPQoS")); err != nil {
+		return nil, err
+	}
+
+	err = c.applyControl(get("ControlMaster"), get("ControlPath"), get("ControlPersist"), sub)
+	if err != nil {
+		return nil, err
 	}
 
 	return c, nil
@@ -162,7 +315,7 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 
 	var hops []Hop
 	for i, j := range sc.Jumps {
-		jc, err := ParseSSHConfig(j.host, j.user)
+		jc, err := ParseSSHConfigFile(j.host, j.user, sc.ConfigFile)
 		if err != nil {
 			return nil, fmt.Errorf("could not parse SSH config for %v: %v", j.host, err)
 		}
@@ -191,12 +344,10 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 		hops = append(hops, hs...)
 	}
 
-	sigs, err := sc.makeSigners()
+	auth, err := sc.makeAuth()
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("Trying %d key file(s)", len(sigs))
-	auth := []ssh.AuthMethod{ssh.PublicKeys(sigs...)}
 
 	keyCallback, keyAlgos, err := sc.makeCallbackAndAlgos()
 	if err != nil {
@@ -205,23 +356,43 @@ func (sc *SSHConfig) toHopsImpl(ignoreIntermediate bool, depth int) ([]Hop, erro
 
 	clientConf := &ssh.ClientConfig{
 		Config: ssh.Config{
-			Ciphers:      sc.Ciphers,
-			KeyExchanges: sc.KexAlgos,
-			MACs:         sc.Macs,
+			Ciphers:        sc.Ciphers,
+			KeyExchanges:   sc.KexAlgos,
+			MACs:           sc.Macs,
+			RekeyThreshold: sc.RekeyThreshold,
 		},
 		User:              sc.User,
 		Auth:              auth,
 		HostKeyAlgorithms: keyAlgos,
 		HostKeyCallback:   keyCallback,
+		BannerCallback:    logBanner(sc.Alias),
 		Timeout:           sshConnTimeout,
 	}
 
-	hop := Hop{HostName: sc.HostName, Port: sc.Port, ClientConfig: clientConf}
+	hop := Hop{HostName: sc.HostName, Port: sc.Port, TOS: sc.IPQoS, ClientConfig: clientConf}
+	if !sc.IdentitiesOnly && !sc.SignersOnly {
+		hop.PrunedAuth = func() ([]ssh.AuthMethod, error) {
+			pruned := *sc
+			pruned.IdentitiesOnly = true
+			return pruned.makeAuth()
+		}
+	}
+	if sc.usesRegisteredAuth() {
+		hop.Auth = sc.makeAuth
+	}
 	hops = append(hops, hop)
 
 	return hops, nil
 }
 
+// logBanner logs server banners at debug level, they are otherwise discarded
+func logBanner(alias string) ssh.BannerCallback {
+	return func(msg string) error {
+		log.Debugf("%s: server banner: %s", alias, strings.TrimSpace(msg))
+		return nil
+	}
+}
+
 func (sc *SSHConfig) loadCerts() (certs []*ssh.Certificate) {
 	for _, f := range sc.CertificateFiles {
 		cert, err := loadCert(f)
@@ -238,50 +409,102 @@ func (sc *SSHConfig) loadCerts() (certs []*ssh.Certificate) {
 type identity struct {
 	signer ssh.Signer
 	path   string // non-empty only for IdentityFiles
+	// other is set for agent keys not among the identities
+	other bool
+}
+
+// loadIDs loads the identities to try. failed are the identity files
+// that could not be loaded, except default ones that do not exist.
+func (sc *SSHConfig) loadIDs() (fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs []identity, failed []string) {
+	keys := configuredKeys{fps: make(map[string]struct{}, len(sc.IdentityFiles)), sha: make(map[string]bool)}
+	fileIDs, failed = sc.loadFileIDs(keys)
+
+	if agSigs, err := agent.GetSigners(); err != nil {
+		log.Warningf("Unable to get keys from ssh-agent: %v", err)
+	} else {
+		agentCertIDs, agentCfgIDs, agentOtherIDs = sc.sortAgentIDs(agSigs, keys)
+	}
+	// Configured keys held by the agent are tried as such
+	for _, id := range agentCfgIDs {
+		fp := keyFP(id.signer.PublicKey())
+		fileIDs = slices.DeleteFunc(fileIDs, func(fid identity) bool {
+			return keyFP(fid.signer.PublicKey()) == fp
+		})
+	}
+	for sha, found := range keys.sha {
+		if !found {
+			log.Warningf("no key with fingerprint %v in ssh-agent", sha)
+		}
+	}
+	return
+}
+
+// configuredKeys are the keys given as IdentityFile, by fingerprint of the
+// public key, and the SHA256 fingerprints given in its place, which select
+// agent keys only, along with whether the agent has them
+type configuredKeys struct {
+	fps map[string]struct{}
+	sha map[string]bool
 }
 
-func (sc *SSHConfig) loadIDs() (fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs []identity) {
-	cfgFP := make(map[string]struct{}, len(sc.IdentityFiles))
+// has tells whether k is among the configured keys
+func (c configuredKeys) has(k ssh.PublicKey) bool {
+	if _, ok := c.fps[keyFP(k)]; ok {
+		return true
+	}
+	sha := ssh.FingerprintSHA256(k)
+	if _, ok := c.sha[sha]; ok {
+		c.sha[sha] = true
+		return true
+	}
+	return false
+}
 
+// loadFileIDs loads the keys of IdentityFiles and records them in keys
+func (sc *SSHConfig) loadFileIDs(keys configuredKeys) (ids []identity, failed []string) {
 	for _, f := range sc.IdentityFiles {
-		s, fp, ok := loadIdentity(f)
-		if !ok {
-			log.Warningf("key file %q could not be added", f)
+		if strings.HasPrefix(f, "SHA256:") {
+			keys.sha[f] = false
 			continue
 		}
-		cfgFP[fp] = struct{}{}
-		if s != nil {
-			fileIDs = append(fileIDs, identity{signer: s, path: f})
+		s, fp, err := loadIdentity(f)
+		if err != nil {
+			log.Warningf("key file %q could not be added: %v", f, err)
+			if _, err := os.Stat(paths.ReplaceTilde(f)); err == nil || !slices.Contains(defaultIdentityFiles, f) {
+				failed = append(failed, f)
+			}
+			continue
 		}
-	}
-
-	if agSigs, err := agent.GetSigners(); err != nil {
-		log.Warningf("Unable to get keys from ssh-agent: %v", err)
-	} else {
-		for _, s := range agSigs {
-			// Agent may return certificate identities (public key is a cert)
-			if c, ok := s.PublicKey().(*ssh.Certificate); ok {
-				fp := keyFP(c.Key)
-				if _, ok := cfgFP[fp]; ok || !sc.IdentitiesOnly {
-					agentCertIDs = append(agentCertIDs, identity{signer: s})
-				}
-				continue
+		keys.fps[fp] = struct{}{}
+		if s == nil {
+			continue
+		}
+		if sc.AddKeysToAgent.enabled {
+			if key, err := loadRawPrivateKey(f); err == nil {
+				s = sc.withAddToAgent(s, key, f)
 			}
+		}
+		ids = append(ids, identity{signer: s, path: f})
+	}
+	return
+}
 
-			id := identity{signer: s}
-			fp := keyFP(s.PublicKey())
-			if _, ok := cfgFP[fp]; ok {
-				agentCfgIDs = append(agentCfgIDs, id)
-				// Remove id from fileIDs if existing
-				for i, fid := range fileIDs {
-					if keyFP(fid.signer.PublicKey()) == fp {
-						fileIDs = append(fileIDs[:i], fileIDs[i+1:]...)
-						break
-					}
-				}
-			} else if !sc.IdentitiesOnly {
-				agentOtherIDs = append(agentOtherIDs, id)
+// sortAgentIDs sorts the agent's signers into certificates, configured
+// keys and other keys. Keys not configured are left out with
+// IdentitiesOnly.
+func (sc *SSHConfig) sortAgentIDs(sigs []ssh.Signer, keys configuredKeys) (certIDs, cfgIDs, otherIDs []identity) {
+	for _, s := range sigs {
+		// Agent may return certificate identities (public key is a cert)
+		if c, ok := s.PublicKey().(*ssh.Certificate); ok {
+			if cfg := keys.has(c.Key); cfg || !sc.IdentitiesOnly {
+				certIDs = append(certIDs, identity{signer: s, other: !cfg})
 			}
+			continue
+		}
+		if keys.has(s.PublicKey()) {
+			cfgIDs = append(cfgIDs, identity{signer: s})
+		} else if !sc.IdentitiesOnly {
+			otherIDs = append(otherIDs, identity{signer: s, other: true})
 		}
 	}
 	return
@@ -296,16 +519,60 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 	// 4. IdentityFile keys
 	// + agent certificate identities (already certified signers)
 
+	provided, err := sc.providedSigners()
+	if err != nil {
+		log.Errorf("%s: %v", sc.Alias, err)
+	}
+	if sc.SignersOnly {
+		if len(provided) == 0 {
+			return nil, fmt.Errorf("%s: no signers provided", sc.Alias)
+		}
+		return provided, nil
+	}
+
 	// Load ID groups
-	fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs := sc.loadIDs()
+	fileIDs, agentCertIDs, agentCfgIDs, agentOtherIDs, failed := sc.loadIDs()
+	if len(failed) > 0 {
+		// Make it evident if other keys authenticate in their place
+		warn := &fallbackWarning{alias: sc.Alias, failed: failed}
+		warn.wrapOther(agentCertIDs, agentOtherIDs)
+	}
 
-	var sigs []ssh.Signer
-	idsForCert := append([]identity{}, agentCfgIDs...)
-	idsForCert = append(idsForCert, agentOtherIDs...)
-	idsForCert = append(idsForCert, fileIDs...)
+	sigs := sc.certSigners(slices.Concat(agentCfgIDs, agentOtherIDs, fileIDs))
+
+	// Provided signers and a key from KeyCommand were asked for
+	// explicitly, prefer them over agent and file keys
+	sigs = append(sigs, provided...)
+	sigs = append(sigs, sc.keyCommandSigners()...)
+
+	// Try already-certified agent identities (certificate signers), then
+	// plain keys as fallback (bucket order)
+	sigs = append(sigs, signersOf(agentCertIDs, agentCfgIDs, agentOtherIDs, fileIDs)...)
+
+	if len(sigs) == 0 {
+		if len(sc.IdentityFiles) == 0 {
+			return nil, fmt.Errorf("%s: no key files found", sc.Alias)
+		}
+		return nil, fmt.Errorf("%s: no key files found (tried %s)",
+			sc.Alias, strings.Join(sc.IdentityFiles, ", "))
+	}
+
+	sigs = dedupeSigners(sigs)
+
+	for _, sig := range sigs {
+		log.Debugf("%s: will try key %s", sc.Alias, sig)
+	}
+
+	return sigs, nil
+}
 
+// certSigners binds certificates to the first of ids they certify. These
+// are the CertificateFile certificates or, without any, the implicit
+// -cert.pub siblings of identity files.
+func (sc *SSHConfig) certSigners(ids []identity) []ssh.Signer {
+	var sigs []ssh.Signer
 	bind := func(c *ssh.Certificate) {
-		for _, id := range idsForCert {
+		for _, id := range ids {
 			if certSig, err := certify(c, id.signer); err == nil {
 				sigs = append(sigs, certSig)
 				return
@@ -314,65 +581,69 @@ func (sc *SSHConfig) makeSigners() ([]ssh.Signer, error) {
 	}
 
 	if certs := sc.loadCerts(); len(certs) > 0 {
-		// CertificateFile certs: bind each cert to first matching key
 		for _, c := range certs {
 			bind(c)
 		}
-	} else {
-		// Implicit certs
-		for _, path := range sc.IdentityFiles {
-			c, err := loadCert(path + "-cert.pub")
-			if err != nil {
-				continue
-			}
+		return sigs
+	}
+	for _, path := range sc.IdentityFiles {
+		if c, err := loadCert(path + "-cert.pub"); err == nil {
 			bind(c)
 		}
 	}
+	return sigs
+}
 
-	// Try already-certified agent identities (certificate signers)
-	for _, id := range agentCertIDs {
-		sigs = append(sigs, id.signer)
-	}
-
-	// Plain keys as fallback (bucket order)
-	for _, id := range agentCfgIDs {
-		sigs = append(sigs, id.signer)
+// keyCommandSigners returns the key printed by KeyCommand, if set
+func (sc *SSHConfig) keyCommandSigners() []ssh.Signer {
+	if sc.KeyCommand == "" {
+		return nil
 	}
-	for _, id := range agentOtherIDs {
-		sigs = append(sigs, id.signer)
-	}
-	for _, id := range fileIDs {
-		sigs = append(sigs, id.signer)
-	}
-
-	if len(sigs) == 0 {
-		return nil, fmt.Errorf("%s: no key files found", sc.Alias)
+	sig, err := sc.loadKeyCommand()
+	if err != nil {
+		log.Errorf("%s: %v", sc.Alias, err)
+		return nil
 	}
+	return []ssh.Signer{sig}
+}
 
-	sigs = dedupeSigners(sigs)
-
-	for _, sig := range sigs {
-		log.Debugf("%s: will try key %s", sc.Alias, sig)
+// signersOf returns the signers of groups of identities, in order
+func signersOf(groups ...[]identity) []ssh.Signer {
+	var sigs []ssh.Signer
+	for _, ids := range groups {
+		for _, id := range ids {
+			sigs = append(sigs, id.signer)
+		}
 	}
-
-	return sigs, nil
+	return sigs
 }
 
 func (sc *SSHConfig) makeCallbackAndAlgos() (cb ssh.HostKeyCallback, algs []string, err error) {
-	if sc.KeyCheck == strict {
-		var hosts []string
-		for _, k := range sc.KnownHostsFiles {
-			k = paths.ReplaceTilde(k)
-			if _, err := os.Stat(k); err != nil {
-				log.Debugf("could not open known hosts file %v: %v", k, err)
-				continue
-			}
-			hosts = append(hosts, k)
-		}
-		if cb, err = knownhosts.New(hosts...); err != nil {
+	if sc.PinnedFingerprint != "" {
+		log.Debugf("%v: pinning host key %v", sc.Alias, sc.PinnedFingerprint)
+		return pinnedCallback(sc.PinnedFingerprint), sc.HostKeyAlgos, nil
+	}
+	if sc.KeyCheck == strict || sc.KeyCheck == acceptNew {
+		if cb, err = knownhosts.New(sc.existingKnownHosts()...); err != nil {
 			return nil, nil, fmt.Errorf("knownhosts: %v", err)
 		}
+		cb = hostCertCallback(cb)
 		known := extractHostKeyAlgos(cb, net.JoinHostPort(sc.HostName, strconv.Itoa(sc.Port)))
+		if sc.HostKeyAnyAddress {
+			for _, a := range resolveHostPorts(sc.HostName, sc.Port) {
+				known = append(known, extractHostKeyAlgos(cb, a)...)
+			}
+			cb = anyAddressCallback(cb, sc.HostName, sc.Port)
+		}
+		if len(sc.AcceptedHostKeys) > 0 {
+			// Types of accepted keys are unknown, so offer all algorithms
+			log.Debugf("%v: accepting host keys %v", sc.Alias, sc.AcceptedHostKeys)
+			return acceptedKeysCallback(cb, sc.AcceptedHostKeys), sc.HostKeyAlgos, nil
+		}
+		if sc.KeyCheck == acceptNew && len(known) == 0 {
+			log.Debugf("%v: host not in known_hosts, accepting new key", sc.Alias)
+			return sc.acceptNewCallback(cb), sc.HostKeyAlgos, nil
+		}
 		algs = filter(sc.HostKeyAlgos, known)
 		if len(algs) == 0 {
 			return nil, nil, fmt.Errorf("%v: could not determine host key algorithms: default are %v, "+
@@ -388,6 +659,19 @@ func (sc *SSHConfig) makeCallbackAndAlgos() (cb ssh.HostKeyCallback, algs []stri
 	return
 }
 
+// existingKnownHosts returns the known_hosts files that exist
+func (sc *SSHConfig) existingKnownHosts() (files []string) {
+	for _, k := range sc.KnownHostsFiles {
+		k = paths.ReplaceTilde(k)
+		if _, err := os.Stat(k); err != nil {
+			log.Debugf("could not open known hosts file %v: %v", k, err)
+			continue
+		}
+		files = append(files, k)
+	}
+	return
+}
+
 func (sc *SSHConfig) validate() error {
 	if sc.HostName == "" {
 		return fmt.Errorf("no host specified")
@@ -415,25 +699,26 @@ func (sc *SSHConfig) EnsureUser() {
 // It tries f as a private key first; if that fails, it tries f itself as a
 // public key (OpenSSH allows `IdentityFile foo.pub` when the private key lives
 // in ssh-agent), then a sibling f+".pub". Certificate public keys are
-// fingerprinted by their underlying key.
-func loadIdentity(f string) (signer ssh.Signer, fp string, ok bool) {
-	if s, err := loadPrivateKey(f); err == nil {
-		return s, keyFP(s.PublicKey()), true
-	} else {
-		log.Debugf("private key %q could not be loaded: %v. "+
-			"Now trying as public key (including .pub sibling).", f, err)
-	}
+// fingerprinted by their underlying key. If none of these succeed, the
+// error from loading the private key is returned.
+func loadIdentity(f string) (signer ssh.Signer, fp string, err error) {
+	s, err := loadPrivateKey(f)
+	if err == nil {
+		return s, keyFP(s.PublicKey()), nil
+	}
+	log.Debugf("private key %q could not be loaded: %v. "+
+		"Now trying as public key (including .pub sibling).", f, err)
 	for _, p := range []string{f, f + ".pub"} {
-		pub, err := loadPublicKey(p)
-		if err != nil {
+		pub, perr := loadPublicKey(p)
+		if perr != nil {
 			continue
 		}
 		if c, ok := pub.(*ssh.Certificate); ok {
-			return nil, keyFP(c.Key), true
+			return nil, keyFP(c.Key), nil
 		}
-		return nil, keyFP(pub), true
+		return nil, keyFP(pub), nil
 	}
-	return nil, "", false
+	return nil, "", err
 }
 
 func loadPrivateKey(path string) (ssh.Signer, error) {
@@ -442,15 +727,35 @@ func loadPrivateKey(path string) (ssh.Signer, error) {
 	}
 	key, err := os.ReadFile(paths.ReplaceTilde(path))
 	if err != nil {
-		return nil, fmt.Errorf("could not read key: %v", err)
+		return nil, fmt.Errorf("could not read key: %v", readFailure(err))
 	}
 	signer, err := ssh.ParsePrivateKey(key)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse key: %v", err)
+		return nil, fmt.Errorf("could not parse key: %v", keyParseError(key, err))
 	}
 	return signer, nil
 }
 
+// readFailure describes why a file could not be read, singling out the
+// common cases of a missing file and of wrong permissions.
+func readFailure(err error) string {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "not found"
+	case errors.Is(err, fs.ErrPermission):
+		return "permission denied"
+	}
+	return err.Error()
+}
+
+func loadRawPrivateKey(path string) (any, error) {
+	key, err := os.ReadFile(paths.ReplaceTilde(path))
+	if err != nil {
+		return nil, err
+	}
+	return ssh.ParseRawPrivateKey(key)
+}
+
 func loadPublicKey(path string) (ssh.PublicKey, error) {
 	if path == "" {
 		return nil, fmt.Errorf("no key specified")
@@ -4,10 +4,14 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/pem"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
+	"github.com/alebeck/boring/internal/log"
 	"golang.org/x/crypto/ssh"
 )
 
@@ -40,9 +44,9 @@ func writeKeyPair(t *testing.T, dir, name string) (privPath, pubPath string) {
 func TestLoadIdentityPrivateKey(t *testing.T) {
 	priv, _ := writeKeyPair(t, t.TempDir(), "id_test")
 
-	s, fp, ok := loadIdentity(priv)
-	if !ok {
-		t.Fatal("expected loadIdentity to succeed")
+	s, fp, err := loadIdentity(priv)
+	if err != nil {
+		t.Fatalf("expected loadIdentity to succeed: %v", err)
 	}
 	if s == nil {
 		t.Fatal("expected non-nil signer for private key path")
@@ -64,9 +68,9 @@ func TestLoadIdentityPublicKeyOnly(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	s, fp, ok := loadIdentity(pub)
-	if !ok {
-		t.Fatal("expected loadIdentity to succeed for .pub-only IdentityFile")
+	s, fp, err := loadIdentity(pub)
+	if err != nil {
+		t.Fatalf("expected loadIdentity to succeed for .pub-only IdentityFile: %v", err)
 	}
 	if s != nil {
 		t.Fatal("expected nil signer when only public key is available")
@@ -81,12 +85,12 @@ func TestLoadIdentityPublicKeyOnly(t *testing.T) {
 func TestLoadIdentityFingerprintsMatch(t *testing.T) {
 	priv, pub := writeKeyPair(t, t.TempDir(), "id_test")
 
-	_, fpPriv, ok := loadIdentity(priv)
-	if !ok {
+	_, fpPriv, err := loadIdentity(priv)
+	if err != nil {
 		t.Fatal("private-key load failed")
 	}
-	_, fpPub, ok := loadIdentity(pub)
-	if !ok {
+	_, fpPub, err := loadIdentity(pub)
+	if err != nil {
 		t.Fatal("public-key load failed")
 	}
 	if fpPriv != fpPub {
@@ -107,9 +111,9 @@ func TestLoadIdentitySiblingPublicKey(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	s, fp, ok := loadIdentity(priv)
-	if !ok {
-		t.Fatal("expected sibling .pub to be loaded")
+	s, fp, err := loadIdentity(priv)
+	if err != nil {
+		t.Fatalf("expected sibling .pub to be loaded: %v", err)
 	}
 	if s != nil {
 		t.Fatal("expected nil signer when only sibling pub exists")
@@ -120,9 +124,34 @@ func TestLoadIdentitySiblingPublicKey(t *testing.T) {
 }
 
 func TestLoadIdentityMissing(t *testing.T) {
-	s, fp, ok := loadIdentity(filepath.Join(t.TempDir(), "does-not-exist"))
-	if ok || s != nil || fp != "" {
-		t.Fatalf("expected failure, got s=%v fp=%q ok=%v", s, fp, ok)
+	s, fp, err := loadIdentity(filepath.Join(t.TempDir(), "does-not-exist"))
+	if err == nil || s != nil || fp != "" {
+		t.Fatalf("expected failure, got s=%v fp=%q err=%v", s, fp, err)
+	}
+	if !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected the private key error, got %v", err)
+	}
+}
+
+func TestReadFailure(t *testing.T) {
+	_, err := os.ReadFile(filepath.Join(t.TempDir(), "does-not-exist"))
+	if got := readFailure(err); got != "not found" {
+		t.Errorf("got %q, want %q", got, "not found")
+	}
+	err = &fs.PathError{Op: "open", Path: "id_test", Err: fs.ErrPermission}
+	if got := readFailure(err); got != "permission denied" {
+		t.Errorf("got %q, want %q", got, "permission denied")
+	}
+}
+
+func TestNoKeyFilesListsPaths(t *testing.T) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	missing := filepath.Join(t.TempDir(), "id_missing")
+	sc := &SSHConfig{Alias: "test", IdentityFiles: []string{missing}}
+	_, err := sc.makeSigners()
+	if err == nil || !strings.Contains(err.Error(), "no key files found") ||
+		!strings.Contains(err.Error(), missing) {
+		t.Fatalf("unexpected error: %v", err)
 	}
 }
 
@@ -145,3 +174,130 @@ func TestParseSSHConfigDefaultPort(t *testing.T) {
 		t.Errorf("Port = %d, want 22", sc.Port)
 	}
 }
+
+// "none" for ProxyJump or ProxyCommand exempts a host from a global setting.
+func TestParseSSHConfigProxyNone(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	conf := "Host direct\n\tProxyJump none\n\tProxyCommand none\n" +
+		"Host *\n\tProxyJump jump\n\tProxyCommand ssh -W %h:%p jump\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := overrideConfig
+	overrideConfig = cfg
+	t.Cleanup(func() { overrideConfig = old })
+
+	sc, err := ParseSSHConfig("direct", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Jumps) != 0 {
+		t.Errorf("got jumps %v, want none", sc.Jumps)
+	}
+
+	if sc, err = ParseSSHConfig("other", ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(sc.Jumps) != 1 || sc.Jumps[0].host != "jump" {
+		t.Errorf("got jumps %v, want global jump host", sc.Jumps)
+	}
+}
+
+func TestProxyCommandWarnedOnce(t *testing.T) {
+	var b strings.Builder
+	log.Init(&b, true, false)
+	t.Cleanup(func() { log.Init(io.Discard, false, false) })
+
+	cfg := filepath.Join(t.TempDir(), "config")
+	conf := "Host proxied\n\tProxyCommand ssh -W %h:%p jump\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if _, err := ParseSSHConfigFile("proxied", "", cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := strings.Count(b.String(), "ProxyCommand not supported"); n != 1 {
+		t.Errorf("warned %d times, want once", n)
+	}
+}
+
+func TestParseSSHConfigExitOnForwardFailure(t *testing.T) {
+	cfg := filepath.Join(t.TempDir(), "config")
+	conf := "Host strict\n\tExitOnForwardFailure yes\nHost *\n\tExitOnForwardFailure no\n"
+	if err := os.WriteFile(cfg, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	old := overrideConfig
+	overrideConfig = cfg
+	t.Cleanup(func() { overrideConfig = old })
+
+	for alias, want := range map[string]bool{"strict": true, "other": false} {
+		sc, err := ParseSSHConfig(alias, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sc.ExitOnForwardFailure != want {
+			t.Errorf("%v: got %v, want %v", alias, sc.ExitOnForwardFailure, want)
+		}
+	}
+}
+
+func TestExpandAddress(t *testing.T) {
+	sc := &SSHConfig{Alias: "db", HostName: "db.example.com", User: "alice", Port: 2222}
+	cases := map[string]string{
+		"%h:5432":            "db.example.com:5432",
+		"/tmp/%n-%r.sock":    "/tmp/db-alice.sock",
+		"localhost:%p":       "localhost:2222",
+		"100%%:%d":           "100%:%d", // %d is not expanded in addresses
+		"[fe80::1%eth0]:80":  "[fe80::1%eth0]:80",
+		"[fe80::1%utun0]:80": "[fe80::1%utun0]:80",
+		"fe80::1%ppp0":       "fe80::1%ppp0",
+		"[%h]:%p":            "[db.example.com]:2222",
+		"%%h":                "%h",
+	}
+	for in, want := range cases {
+		if got := sc.ExpandAddress(in); got != want {
+			t.Errorf("ExpandAddress(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseSSHConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	project := filepath.Join(dir, "project_config")
+	key, _ := writeKeyPair(t, dir, "id")
+	conf := "Host app\n\tHostName app.internal\n\tProxyJump gw\n" +
+		"Host gw\n\tHostName gw.example.com\n\tUser jump\n" +
+		"Host *\n\tIdentityFile " + key + "\n\tStrictHostKeyChecking no\n"
+	if err := os.WriteFile(project, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "config")
+	if err := os.WriteFile(other, []byte("Host app\n\tHostName wrong\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	old := overrideConfig
+	overrideConfig = other
+	t.Cleanup(func() { overrideConfig = old })
+
+	sc, err := ParseSSHConfigFile("app", "", project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc.HostName != "app.internal" || sc.ConfigFile != project {
+		t.Errorf("got host name %q from %q", sc.HostName, sc.ConfigFile)
+	}
+	sc.EnsureUser()
+	hops, err := sc.ToHops()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The jump host must be resolved from the same file
+	if len(hops) != 2 || hops[0].Addr() != "gw.example.com:22" || hops[0].ClientConfig.User != "jump" {
+		t.Errorf("unexpected hops %+v", hops)
+	}
+}
@@ -1,11 +1,17 @@
 package ssh_config
 
 import (
+	"net/netip"
 	"os"
 	"os/user"
+	"slices"
+	"strconv"
 	"strings"
 )
 
+// addressTokens are the tokens expanded in tunnel addresses
+var addressTokens = []string{"%%", "%h", "%L", "%n", "%p", "%r", "%u"}
+
 type subst map[string]string
 
 func makeSubst(alias string) subst {
@@ -27,6 +33,56 @@ func makeSubst(alias string) subst {
 	return s
 }
 
+// subst returns the substitutions for the resolved configuration
+func (sc *SSHConfig) subst() subst {
+	sub := makeSubst(sc.Alias)
+	if sc.HostName != "" {
+		sub["%h"] = sc.HostName
+	}
+	sub["%r"] = sc.User
+	sub["%p"] = strconv.Itoa(sc.Port)
+	return sub
+}
+
+// ExpandAddress expands the tokens valid in tunnel addresses: %h (host
+// name), %n (alias), %p (port), %r (remote user), %u (local user), %L
+// (local host name) and %%. Zones of IPv6 addresses, as in
+// [fe80::1%utun0]:80, are left alone.
+func (sc *SSHConfig) ExpandAddress(addr string) string {
+	if _, err := netip.ParseAddr(addr); err == nil {
+		return addr
+	}
+	sub := sc.subst()
+	var b strings.Builder
+	inBrackets := false
+	for i := 0; i < len(addr); i++ {
+		c := addr[i]
+		switch {
+		case c == '[':
+			inBrackets = true
+		case c == ']':
+			inBrackets = false
+		case c == '%' && inBrackets && addr[i-1] != '[':
+			// Zone, up to the closing bracket
+			end := strings.IndexByte(addr[i:], ']')
+			if end < 0 {
+				end = len(addr) - i
+			}
+			b.WriteString(addr[i : i+end])
+			i += end - 1
+			continue
+		case c == '%' && i+1 < len(addr):
+			if r, ok := sub[addr[i:i+2]]; ok && slices.Contains(addressTokens, addr[i:i+2]) {
+				b.WriteString(r)
+				i++
+				continue
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
 func (s subst) apply(str string, keys []string) string {
 	if !strings.Contains(str, "%") {
 		return str
@@ -0,0 +1,84 @@
+package tunnel
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// acceptErrorWindow is the quiet time after which a series of
+	// temporary accept errors is considered over
+	acceptErrorWindow = 10 * time.Second
+	minAcceptWait     = 5 * time.Millisecond
+	maxAcceptWait     = time.Second
+
+	defaultAcceptErrorBackoff = 5
+	defaultAcceptErrorLimit   = 1000
+)
+
+// acceptErrors tracks a series of temporary accept errors. It is only used
+// by the goroutine accepting connections.
+type acceptErrors struct {
+	n      int
+	last   time.Time
+	gaveUp bool
+}
+
+// isTemporary reports whether err is expected to go away by itself, e.g.
+// when running out of file descriptors
+func isTemporary(err error) bool {
+	var te interface{ Temporary() bool }
+	return errors.As(err, &te) && te.Temporary()
+}
+
+// acceptBackoff handles a temporary accept error and reports whether
+// accepting should continue. After accept_error_backoff errors in a row, it
+// waits with increasing backoff before the next attempt, after
+// accept_error_limit errors, it gives up.
+func (t *Tunnel) acceptBackoff(err error, disconn <-chan struct{}) bool {
+	e := &t.acceptErrs
+	now := time.Now()
+	if now.Sub(e.last) > acceptErrorWindow {
+		e.n = 0
+	}
+	e.n++
+	e.last = now
+
+	if limit := t.acceptErrorLimit(); limit > 0 && e.n >= limit {
+		t.logger().Errorf("could not accept %d times in a row, giving up: %v", e.n, err)
+		e.gaveUp = true
+		return false
+	}
+	t.logger().Warningf("could not accept: %v", err)
+	after := t.acceptErrorBackoff()
+	if after <= 0 || e.n < after {
+		return true
+	}
+
+	wait := maxAcceptWait
+	if shift := e.n - after; shift < 8 {
+		wait = min(minAcceptWait<<shift, maxAcceptWait)
+	}
+	select {
+	case <-t.stop:
+		return false
+	case <-disconn:
+		return false
+	case <-time.After(wait):
+		return true
+	}
+}
+
+func (t *Tunnel) acceptErrorBackoff() int {
+	if t.AcceptErrorBackoff != nil {
+		return *t.AcceptErrorBackoff
+	}
+	return defaultAcceptErrorBackoff
+}
+
+func (t *Tunnel) acceptErrorLimit() int {
+	if t.AcceptErrorLimit != nil {
+		return *t.AcceptErrorLimit
+	}
+	return defaultAcceptErrorLimit
+}
@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAcceptBackoff(t *testing.T) {
+	err := &net.OpError{Op: "accept", Net: "tcp",
+		Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	if !isTemporary(err) {
+		t.Fatal("expected EMFILE to be temporary")
+	}
+	if isTemporary(net.ErrClosed) {
+		t.Fatal("expected closed listener not to be temporary")
+	}
+
+	backoff, limit := 2, 4
+	tun := &Tunnel{Desc: &Desc{AcceptErrorBackoff: &backoff, AcceptErrorLimit: &limit}}
+	disconn := make(chan struct{})
+	start := time.Now()
+	for i := 1; i < limit; i++ {
+		if !tun.acceptBackoff(err, disconn) {
+			t.Fatalf("gave up after %d errors", i)
+		}
+	}
+	// Waits 5ms and 10ms after the second and third error
+	if d := time.Since(start); d < 15*time.Millisecond {
+		t.Errorf("expected backoff, took %v", d)
+	}
+	if tun.acceptBackoff(err, disconn) || !tun.acceptErrs.gaveUp {
+		t.Error("expected to give up at the limit")
+	}
+
+	// Disconnecting interrupts the wait
+	tun.acceptErrs = acceptErrors{}
+	close(disconn)
+	if !tun.acceptBackoff(err, disconn) || tun.acceptBackoff(err, disconn) {
+		t.Error("expected to stop waiting once disconnected")
+	}
+}
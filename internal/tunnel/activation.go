@@ -0,0 +1,92 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemdScheme prefixes local addresses referring to sockets passed by
+// systemd socket activation, either by their name in LISTEN_FDNAMES or by
+// their index, as in "systemd://web" or "systemd://0". Without a name, the
+// socket named after the tunnel is used.
+const systemdScheme = "systemd://"
+
+// listenFDsStart is the first file descriptor passed, see sd_listen_fds(3)
+const listenFDsStart = 3
+
+type activatedSocket struct {
+	name string
+	f    *os.File
+}
+
+// activated holds the sockets taken over by InheritSockets
+var activated []activatedSocket
+
+// InheritSockets takes over the sockets passed by systemd socket activation,
+// for use by tunnels listening on systemd addresses, and returns how many
+// there are. It must be called at startup, before any child process is
+// started, as the environment describing them is cleared.
+func InheritSockets() int {
+	activated = inheritSockets()
+	return len(activated)
+}
+
+// activatedListener returns a listener on the socket passed by systemd
+// under name, or at the index given by name. The inherited socket stays
+// open, such that the listener can be recreated after being closed.
+func activatedListener(name string) (net.Listener, error) {
+	if len(activated) == 0 {
+		return nil, fmt.Errorf("no sockets passed by systemd")
+	}
+
+	var f *os.File
+	if i, err := strconv.Atoi(name); err == nil {
+		if i < 0 || i >= len(activated) {
+			return nil, fmt.Errorf("no socket passed by systemd at index %d", i)
+		}
+		f = activated[i].f
+	} else {
+		for _, s := range activated {
+			if s.name == name {
+				f = s.f
+				break
+			}
+		}
+		if f == nil {
+			return nil, fmt.Errorf("no socket named %v passed by systemd", name)
+		}
+	}
+	return net.FileListener(f)
+}
+
+// inheritSockets returns the sockets passed to this process by systemd
+func inheritSockets() []activatedSocket {
+	defer func() {
+		// Not meant for child processes
+		for _, v := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			os.Unsetenv(v)
+		}
+	}()
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	socks := make([]activatedSocket, n)
+	for i := range socks {
+		fd := listenFDsStart + i
+		closeOnExec(fd)
+		if i < len(names) {
+			socks[i].name = names[i]
+		}
+		socks[i].f = os.NewFile(uintptr(fd), socks[i].name)
+	}
+	return socks
+}
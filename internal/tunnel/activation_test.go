@@ -0,0 +1,74 @@
+package tunnel
+
+import (
+	"net"
+	"os"
+	"testing"
+)
+
+func TestActivatedListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	activated = []activatedSocket{{"web", f}}
+	t.Cleanup(func() { activated = nil })
+
+	for _, name := range []string{"web", "0"} {
+		l, err := activatedListener(name)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if l.Addr().String() != ln.Addr().String() {
+			t.Errorf("%v: got %v, want %v", name, l.Addr(), ln.Addr())
+		}
+		// Closing must not close the inherited socket
+		l.Close()
+	}
+	for _, name := range []string{"db", "1"} {
+		if _, err := activatedListener(name); err == nil {
+			t.Errorf("%v: expected error", name)
+		}
+	}
+
+	tun := FromDesc(&Desc{Name: "web", LocalAddress: "systemd://", Mode: Local})
+	if err := tun.parseLocalAddrs(true); err != nil {
+		t.Fatal(err)
+	}
+	l, err := listenAll(tun.localAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := l.Accept(); err != nil {
+		t.Error(err)
+	}
+
+	tun = FromDesc(&Desc{Name: "web", LocalAddress: "systemd://", Mode: Remote})
+	if err := tun.parseLocalAddrs(false); err == nil {
+		t.Error("expected error in remote mode")
+	}
+}
+
+func TestInheritSocketsOtherPID(t *testing.T) {
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	if socks := inheritSockets(); socks != nil {
+		t.Errorf("got %v, want none", socks)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("environment not cleared")
+	}
+}
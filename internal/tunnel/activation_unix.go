@@ -0,0 +1,9 @@
+//go:build !windows
+
+package tunnel
+
+import "syscall"
+
+func closeOnExec(fd int) {
+	syscall.CloseOnExec(fd)
+}
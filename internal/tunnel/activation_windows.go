@@ -0,0 +1,4 @@
+package tunnel
+
+// closeOnExec does nothing, as there is no socket activation on Windows
+func closeOnExec(fd int) {}
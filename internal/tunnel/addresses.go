@@ -0,0 +1,219 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Addresses holds one or more addresses, separated by commas. In the TOML
+// config, it can be given as a string, an integer or a list of both.
+type Addresses string
+
+func (a *Addresses) UnmarshalTOML(v any) error {
+	list, ok := v.([]any)
+	if !ok {
+		list = []any{v}
+	}
+	parts := make([]string, 0, len(list))
+	for _, e := range list {
+		var s StringOrInt
+		if err := s.UnmarshalTOML(e); err != nil {
+			return err
+		}
+		parts = append(parts, s.String())
+	}
+	*a = Addresses(strings.Join(parts, ","))
+	return nil
+}
+
+// UnmarshalJSON accepts the same values as the config file
+func (a *Addresses) UnmarshalJSON(data []byte) error {
+	v, err := jsonValue(data)
+	if err != nil || v == nil {
+		return err
+	}
+	return a.UnmarshalTOML(v)
+}
+
+func (a Addresses) String() string {
+	return string(a)
+}
+
+// Split returns the individual addresses
+func (a Addresses) Split() []string {
+	var addrs []string
+	for _, s := range strings.Split(string(a), ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			addrs = append(addrs, s)
+		}
+	}
+	return addrs
+}
+
+// First returns the first address, or an empty string if there is none
+func (a Addresses) First() string {
+	if addrs := a.Split(); len(addrs) > 0 {
+		return addrs[0]
+	}
+	return ""
+}
+
+// parseLocalAddrs parses the local addresses of the tunnel. Multiple
+// addresses are only supported where boring listens on them.
+func (t *Tunnel) parseLocalAddrs(allowShort bool) error {
+	addrs := t.LocalAddress.Split()
+	if len(addrs) == 0 {
+		addrs = []string{""}
+	}
+	if len(addrs) > 1 && (t.Mode == Remote || t.Mode == RemoteSocks) {
+		return fmt.Errorf("multiple addresses are only supported in local and socks modes")
+	}
+	t.localAddrs = nil
+	for _, s := range addrs {
+		a, err := parseAddr(t.expand(s), allowShort)
+		if err != nil {
+			return err
+		}
+		if a.net == "srv" {
+			return fmt.Errorf("SRV names are only supported as remote address")
+		}
+		if a.net == "systemd" {
+			if t.Mode == Remote || t.Mode == RemoteSocks {
+				return fmt.Errorf("systemd sockets are only supported in local and socks modes")
+			}
+			if a.addr == "" {
+				a.addr = t.Name
+			}
+		}
+		t.localAddrs = append(t.localAddrs, a)
+	}
+	t.localAddr = t.localAddrs[0]
+	return nil
+}
+
+// listenAll listens on all addresses. With more than one address, the
+// listeners are merged into one.
+func listenAll(addrs []*address) (net.Listener, error) {
+	if len(addrs) == 1 {
+		return listen(addrs[0])
+	}
+	m := &multiListener{
+		conns: make(chan acceptResult),
+		done:  make(chan struct{}),
+	}
+	for _, a := range addrs {
+		l, err := listen(a)
+		if err != nil {
+			m.Close()
+			return nil, err
+		}
+		m.ls = append(m.ls, l)
+	}
+	for _, l := range m.ls {
+		go m.serve(l)
+	}
+	return m, nil
+}
+
+// listen listens on a, using an inherited socket for systemd addresses
+func listen(a *address) (net.Listener, error) {
+	if a.net == "systemd" {
+		return activatedListener(a.addr)
+	}
+	l, err := net.Listen(a.net, a.addr)
+	if err != nil && a.net == "tcp" {
+		err = inUse(a.addr, err)
+	}
+	return l, err
+}
+
+// listenAddrs returns the addresses l listens on
+func listenAddrs(l net.Listener) []net.Addr {
+	m, ok := l.(*multiListener)
+	if !ok {
+		return []net.Addr{l.Addr()}
+	}
+	addrs := make([]net.Addr, len(m.ls))
+	for i, sub := range m.ls {
+		addrs[i] = sub.Addr()
+	}
+	return addrs
+}
+
+// setBound records the addresses l listens on, with port 0 resolved to the
+// one picked. listenerMu must be held.
+func (t *Tunnel) setBound(l net.Listener) {
+	t.bound = listenAddrs(l)
+	t.Bound = make([]string, len(t.bound))
+	for i, a := range t.bound {
+		t.Bound[i] = a.String()
+	}
+}
+
+// Addrs returns the addresses the tunnel listens on, with port 0 resolved
+// to the one picked, or nil if the tunnel was not opened. In remote modes,
+// these are addresses on the server.
+func (t *Tunnel) Addrs() []net.Addr {
+	t.listenerMu.Lock()
+	defer t.listenerMu.Unlock()
+	return t.bound
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+// multiListener accepts connections from several listeners. Closing it
+// closes all of them.
+type multiListener struct {
+	ls    []net.Listener
+	conns chan acceptResult
+	done  chan struct{}
+	once  sync.Once
+}
+
+func (m *multiListener) serve(l net.Listener) {
+	for {
+		c, err := l.Accept()
+		select {
+		case m.conns <- acceptResult{c, err}:
+		case <-m.done:
+			if c != nil {
+				c.Close()
+			}
+			return
+		}
+		// Temporary errors are handled by the caller, keep accepting
+		if err != nil && !isTemporary(err) {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.conns:
+		return r.conn, r.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	m.once.Do(func() { close(m.done) })
+	var err error
+	for _, l := range m.ls {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Addr returns the address of the first listener
+func (m *multiListener) Addr() net.Addr {
+	return m.ls[0].Addr()
+}
@@ -0,0 +1,37 @@
+package tunnel
+
+import "testing"
+
+func TestAddressesUnmarshal(t *testing.T) {
+	var a Addresses
+	if err := a.UnmarshalTOML([]any{int64(9000), "192.168.1.5:9000"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Split(); len(got) != 2 || got[0] != "9000" || got[1] != "192.168.1.5:9000" {
+		t.Errorf("unexpected addresses: %q", got)
+	}
+	if err := a.UnmarshalTOML(int64(9000)); err != nil || a != "9000" {
+		t.Errorf("unexpected result %q, %v", a, err)
+	}
+	if err := a.UnmarshalTOML([]any{struct{}{}}); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
+
+func TestAddressesUnmarshalJSON(t *testing.T) {
+	for in, want := range map[string]Addresses{
+		`9000`:                       "9000",
+		`"localhost:9000"`:           "localhost:9000",
+		`["a:1", "b:2"]`:             "a:1,b:2",
+		`[9000, "192.168.1.5:9000"]`: "9000,192.168.1.5:9000",
+	} {
+		var a Addresses
+		if err := a.UnmarshalJSON([]byte(in)); err != nil || a != want {
+			t.Errorf("%v: got %q, %v", in, a, err)
+		}
+	}
+	var a Addresses
+	if err := a.UnmarshalJSON([]byte(`[true]`)); err == nil {
+		t.Error("expected error for unsupported type")
+	}
+}
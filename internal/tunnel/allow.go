@@ -0,0 +1,142 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+)
+
+// checkLocalAllow restricts the local targets the remote side can reach
+// through a remote socks tunnel to those matching a LocalAllow pattern.
+// Patterns are matched against "host:port", as in "10.0.0.5:*". Remote
+// tunnels only ever connect to their configured local address.
+func (t *Tunnel) checkLocalAllow(addr string) error {
+	if t.Mode != RemoteSocks || len(t.LocalAllow) == 0 {
+		return nil
+	}
+	for _, p := range t.LocalAllow {
+		if ok, _ := path.Match(p, addr); ok {
+			return nil
+		}
+	}
+	t.logger().Warningf("rejected connection to %v, not in local_allow", addr)
+	return fmt.Errorf("target %v not allowed", addr)
+}
+
+// validateLocalAllow checks that all LocalAllow patterns are well-formed
+func (t *Tunnel) validateLocalAllow() error {
+	for _, p := range t.LocalAllow {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid local_allow pattern %q: %v", p, err)
+		}
+	}
+	return nil
+}
+
+// parseClientAllow parses ClientAllow entries, given as CIDRs or plain IPs
+func (t *Tunnel) parseClientAllow() error {
+	t.clientNets = nil
+	for _, s := range t.ClientAllow {
+		if !strings.Contains(s, "/") {
+			a, err := netip.ParseAddr(s)
+			if err != nil {
+				return fmt.Errorf("invalid client_allow entry %q: %v", s, err)
+			}
+			t.clientNets = append(t.clientNets, netip.PrefixFrom(a, a.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("invalid client_allow entry %q: %v", s, err)
+		}
+		t.clientNets = append(t.clientNets, p.Masked())
+	}
+	return nil
+}
+
+// validateClientIDs checks that ClientUIDs and ClientGIDs can be enforced,
+// which requires all local addresses to be Unix sockets
+func (t *Tunnel) validateClientIDs() error {
+	if len(t.ClientUIDs) == 0 && len(t.ClientGIDs) == 0 {
+		return nil
+	}
+	if t.Mode != Local && t.Mode != Socks {
+		return fmt.Errorf("client_uids and client_gids are only supported in local and socks modes")
+	}
+	if !peerCredSupported {
+		return fmt.Errorf("client_uids and client_gids are not supported on this platform")
+	}
+	for _, a := range t.localAddrs {
+		if a.net != "unix" {
+			return fmt.Errorf("client_uids and client_gids require local to be Unix sockets, got %v", a.addr)
+		}
+	}
+	return nil
+}
+
+// checkClientAllow tells whether a client connecting to the local listener
+// is allowed by ClientAllow or, for Unix sockets, by ClientUIDs and
+// ClientGIDs. Clients connecting via Unix sockets have no IP and are not
+// subject to ClientAllow.
+func (t *Tunnel) checkClientAllow(conn net.Conn) bool {
+	if t.Mode != Local && t.Mode != Socks {
+		return true
+	}
+	ta, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return t.checkClientIDs(conn)
+	}
+	if len(t.clientNets) == 0 {
+		return true
+	}
+	ip := ta.AddrPort().Addr().Unmap()
+	for _, p := range t.clientNets {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	t.logger().Warningf("rejected connection from %v, not in client_allow", ip)
+	return false
+}
+
+// checkClientIDs tells whether the process connected to c runs as one of
+// ClientUIDs or in one of ClientGIDs, as reported by SO_PEERCRED. Wrapped
+// connections, e.g. with TLS, are unwrapped to get to the socket.
+func (t *Tunnel) checkClientIDs(c net.Conn) bool {
+	if len(t.ClientUIDs) == 0 && len(t.ClientGIDs) == 0 {
+		return true
+	}
+	uc := unixConn(c)
+	if uc == nil {
+		t.logger().Warningf("rejected connection, could not get peer credentials of %T", c)
+		return false
+	}
+	uid, gid, err := peerCred(uc)
+	if err != nil {
+		t.logger().Warningf("rejected connection, could not get peer credentials: %v", err)
+		return false
+	}
+	if slices.Contains(t.ClientUIDs, uid) || slices.Contains(t.ClientGIDs, gid) {
+		return true
+	}
+	t.logger().Warningf("rejected connection from uid %d, gid %d, not in client_uids or client_gids", uid, gid)
+	return false
+}
+
+// unixConn returns the Unix socket connection c wraps, or nil if there is
+// none
+func unixConn(c net.Conn) *net.UnixConn {
+	for {
+		switch v := c.(type) {
+		case *net.UnixConn:
+			return v
+		case interface{ NetConn() net.Conn }:
+			c = v.NetConn()
+		default:
+			return nil
+		}
+	}
+}
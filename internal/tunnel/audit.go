@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alebeck/boring/internal/audit"
+)
+
+// auditConn records a forwarded connection in the audit log when it is
+// opened and when it is closed.
+type auditConn struct {
+	net.Conn
+	tunnel, peer, target string
+	start                time.Time
+	// read and written count bytes on this connection, which is on the
+	// target's side if reversed, and on the peer's side otherwise
+	read, written atomic.Uint64
+	reversed      bool
+	once          sync.Once
+}
+
+// audit wraps c, a connection accepted by the tunnel's listener and
+// forwarded to target, such that its lifecycle is audited. If auditing is
+// disabled, c is returned as is.
+func (t *Tunnel) audit(c net.Conn, target string) net.Conn {
+	if !audit.Enabled() {
+		return c
+	}
+	return t.newAuditConn(c, c.RemoteAddr().String(), target, false)
+}
+
+// auditDial wraps dial, which connects a socks client at peer to targets,
+// such that the connections it makes are audited.
+func (t *Tunnel) auditDial(dial func(context.Context, string, string) (net.Conn, error),
+	peer net.Addr) func(context.Context, string, string) (net.Conn, error) {
+	if !audit.Enabled() {
+		return dial
+	}
+	return func(ctx context.Context, netw, addr string) (net.Conn, error) {
+		c, err := dial(ctx, netw, addr)
+		if err != nil {
+			return nil, err
+		}
+		return t.newAuditConn(c, peer.String(), addr, true), nil
+	}
+}
+
+func (t *Tunnel) newAuditConn(c net.Conn, peer, target string, reversed bool) *auditConn {
+	a := &auditConn{Conn: c, tunnel: t.Name, peer: peer, target: target,
+		start: time.Now(), reversed: reversed}
+	audit.Record(audit.Event{Time: a.start, Event: audit.ConnOpen, Tunnel: t.Name,
+		Peer: peer, Target: target})
+	return a
+}
+
+func (a *auditConn) Read(p []byte) (int, error) {
+	n, err := a.Conn.Read(p)
+	a.read.Add(uint64(n))
+	return n, err
+}
+
+func (a *auditConn) Write(p []byte) (int, error) {
+	n, err := a.Conn.Write(p)
+	a.written.Add(uint64(n))
+	return n, err
+}
+
+func (a *auditConn) Close() error {
+	a.once.Do(func() {
+		in, out := a.read.Load(), a.written.Load()
+		if a.reversed {
+			in, out = out, in
+		}
+		audit.Record(audit.Event{Event: audit.ConnClose, Tunnel: a.tunnel, Peer: a.peer,
+			Target: a.target, BytesIn: in, BytesOut: out,
+			DurationMs: time.Since(a.start).Milliseconds()})
+	})
+	return a.Conn.Close()
+}
+
+// auditTunnel records the opening or closing of the tunnel
+func (t *Tunnel) auditTunnel(event string) {
+	e := audit.Event{Event: event, Tunnel: t.Name, Target: t.RemoteAddress.String()}
+	if event == audit.TunnelClose {
+		s := t.Stats()
+		e.BytesIn, e.BytesOut = s.BytesIn, s.BytesOut
+		e.DurationMs = time.Since(t.openedAt).Milliseconds()
+	}
+	audit.Record(e)
+}
@@ -0,0 +1,90 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+)
+
+// Categories of failures reported by Check
+const (
+	CategoryConfig  = "config"
+	CategoryDNS     = "dns"
+	CategoryNetwork = "network"
+	CategoryHostKey = "host key"
+	CategoryAuth    = "auth"
+	CategoryTimeout = "timeout"
+	CategoryOther   = "other"
+)
+
+// CheckError is returned by Check and categorizes the failure
+type CheckError struct {
+	Category string
+	Err      error
+}
+
+func (e *CheckError) Error() string { return e.Err.Error() }
+
+func (e *CheckError) Unwrap() error { return e.Err }
+
+// Check verifies that the tunnel's server can be reached and authenticated
+// against, by connecting and disconnecting right away. Nothing is forwarded.
+func (t *Tunnel) Check(ctx context.Context) error {
+	errc := make(chan error, 1)
+	go func() {
+		if !t.prepared {
+			if err := t.prepare(); err != nil {
+				c := categorize(err)
+				if c == CategoryOther {
+					c = CategoryConfig
+				}
+				errc <- &CheckError{c, err}
+				return
+			}
+		}
+		if err := t.makeClient(); err != nil {
+			errc <- &CheckError{categorize(err), err}
+			return
+		}
+		t.client.Close()
+		errc <- nil
+	}()
+
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+		return &CheckError{CategoryTimeout, ctx.Err()}
+	}
+}
+
+// categorize guesses the category of a connection error. Errors are
+// mostly formatted into strings on their way up, so we match on text.
+func categorize(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return CategoryDNS
+	}
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "no such host"):
+		return CategoryDNS
+	case strings.Contains(msg, "unable to authenticate"),
+		strings.Contains(msg, "no usable authentication methods"),
+		strings.Contains(msg, "no key files found"):
+		return CategoryAuth
+	case strings.Contains(msg, "knownhosts:"),
+		strings.Contains(msg, "host key"):
+		return CategoryHostKey
+	case strings.Contains(msg, "i/o timeout"):
+		return CategoryTimeout
+	case strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "unreachable"),
+		strings.Contains(msg, "no route to host"),
+		strings.Contains(msg, "connection reset"),
+		strings.Contains(msg, "proxy"):
+		return CategoryNetwork
+	}
+	return CategoryOther
+}
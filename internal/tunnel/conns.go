@@ -0,0 +1,66 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Conn describes a connection currently forwarded by a tunnel
+type Conn struct {
+	// Peer is the address of the client connecting to the listener
+	Peer string `json:"peer"`
+	// Target is the address forwarded to, empty while a socks client has
+	// not requested one yet
+	Target  string    `json:"target"`
+	Started time.Time `json:"started"`
+	// BytesIn and BytesOut count bytes received from and sent to the peer
+	BytesIn  uint64 `json:"bytes_in"`
+	BytesOut uint64 `json:"bytes_out"`
+}
+
+// stream holds what is known about a forwarded connection
+type stream struct {
+	peer    string
+	target  string // guarded by streamsMu
+	started time.Time
+	in, out atomic.Uint64
+}
+
+// Conns returns the connections currently forwarded, oldest first
+func (t *Tunnel) Conns() []Conn {
+	t.streamsMu.Lock()
+	conns := make([]Conn, 0, len(t.streams))
+	for _, s := range t.streams {
+		conns = append(conns, Conn{
+			Peer:     s.peer,
+			Target:   s.target,
+			Started:  s.started,
+			BytesIn:  s.in.Load(),
+			BytesOut: s.out.Load(),
+		})
+	}
+	t.streamsMu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Started.Before(conns[j].Started) })
+	return conns
+}
+
+// setTarget records the address s is forwarded to
+func (t *Tunnel) setTarget(s *stream, target string) {
+	t.streamsMu.Lock()
+	s.target = target
+	t.streamsMu.Unlock()
+}
+
+// targetDial wraps dial, which connects a socks client to targets, such
+// that the target is recorded for s and span.
+func (t *Tunnel) targetDial(dial func(context.Context, string, string) (net.Conn, error),
+	s *stream, span Span) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, netw, addr string) (net.Conn, error) {
+		t.setTarget(s, addr)
+		span.SetAttribute("boring.target", addr)
+		return dial(ctx, netw, addr)
+	}
+}
@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// deadlineConn fails reads and writes on a forwarded stream that do not
+// complete in time. Unlike the SSH keep-alive, this detects a half-open
+// connection on the forwarded stream itself.
+type deadlineConn struct {
+	net.Conn
+	read, write time.Duration
+	act         *activity
+}
+
+// activity records when data last moved in either direction of a stream,
+// such that a one-way transfer does not trip the read deadline of the
+// idle direction
+type activity struct {
+	last atomic.Int64
+}
+
+func (a *activity) touch() {
+	a.last.Store(time.Now().UnixNano())
+}
+
+func (a *activity) at() time.Time {
+	return time.Unix(0, a.last.Load())
+}
+
+// withDeadlines wraps c such that a read or write fails if it does not
+// complete within ReadTimeout or WriteTimeout seconds, respectively. The
+// stream is then torn down. A read is only failed if no data was written
+// either. If both are unset, or c does not support deadlines, as is the
+// case for SSH channels, c is returned as is.
+func (t *Tunnel) withDeadlines(c net.Conn) net.Conn {
+	return t.withActivity(c, &activity{})
+}
+
+// withPairedDeadlines wraps both sides of a forwarded stream like
+// withDeadlines, with activity on either side refreshing the read deadlines
+// of both.
+func (t *Tunnel) withPairedDeadlines(a, b net.Conn) (net.Conn, net.Conn) {
+	act := &activity{}
+	return t.withActivity(a, act), t.withActivity(b, act)
+}
+
+func (t *Tunnel) withActivity(c net.Conn, act *activity) net.Conn {
+	if t.ReadTimeout <= 0 && t.WriteTimeout <= 0 {
+		return c
+	}
+	if err := c.SetDeadline(time.Time{}); err != nil {
+		return c
+	}
+	return &deadlineConn{
+		Conn:  c,
+		read:  time.Duration(t.ReadTimeout) * time.Second,
+		write: time.Duration(t.WriteTimeout) * time.Second,
+		act:   act,
+	}
+}
+
+func (d *deadlineConn) Read(p []byte) (int, error) {
+	if d.read > 0 {
+		d.Conn.SetReadDeadline(time.Now().Add(d.read))
+	}
+	for {
+		n, err := d.Conn.Read(p)
+		if n > 0 {
+			d.act.touch()
+		}
+		if n > 0 || d.read <= 0 || !os.IsTimeout(err) {
+			return n, err
+		}
+		// Data moved meanwhile, wait until it has been idle long enough
+		next := d.act.at().Add(d.read)
+		if !next.After(time.Now()) {
+			return n, err
+		}
+		d.Conn.SetReadDeadline(next)
+	}
+}
+
+func (d *deadlineConn) Write(p []byte) (int, error) {
+	if d.write > 0 {
+		d.Conn.SetWriteDeadline(time.Now().Add(d.write))
+	}
+	n, err := d.Conn.Write(p)
+	if n > 0 {
+		d.act.touch()
+	}
+	return n, err
+}
@@ -0,0 +1,128 @@
+package tunnel
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"syscall"
+	"time"
+
+	xproxy "golang.org/x/net/proxy"
+)
+
+// happyEyeballsDelay is the head start of IPv6 over IPv4 when dialing
+// dual-stack hosts, as recommended by RFC 8305
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// proxyEnvVars are consulted, in order, if no proxy is set explicitly
+var proxyEnvVars = []string{"https_proxy", "HTTPS_PROXY", "all_proxy", "ALL_PROXY"}
+
+// resolveProxy determines the proxy, if any, through which the first hop
+// is dialed. An explicitly set proxy takes precedence over the environment.
+func (t *Tunnel) resolveProxy() error {
+	if t.Proxy != "" {
+		u, err := parseProxyURL(t.Proxy)
+		if err != nil {
+			return err
+		}
+		t.proxyURL = u
+		return nil
+	}
+	for _, k := range proxyEnvVars {
+		v := os.Getenv(k)
+		if v == "" {
+			continue
+		}
+		u, err := parseProxyURL(v)
+		if err != nil {
+			t.logger().Debugf("ignoring $%v: %v", k, err)
+			continue
+		}
+		t.proxyURL = u
+		t.proxyFromEnv = true
+		return nil
+	}
+	return nil
+}
+
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", u.Scheme)
+	}
+	return u, nil
+}
+
+// dialServer opens the transport connection to the first hop, going
+// through the proxy if one is configured. Packets are marked with tos,
+// unless it is 0.
+func (t *Tunnel) dialServer(addr string, timeout time.Duration, tos int) (net.Conn, error) {
+	if t.DialFunc != nil {
+		ctx := context.Background()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		return t.DialFunc(ctx, "tcp", addr)
+	}
+	direct := &net.Dialer{
+		Timeout:       timeout,
+		FallbackDelay: t.fallbackDelay(),
+		Control:       t.tosControl(tos),
+	}
+	if t.proxyURL == nil {
+		return direct.Dial("tcp", addr)
+	}
+
+	d, err := xproxy.FromURL(t.proxyURL, direct)
+	if err != nil {
+		return nil, err
+	}
+	if t.proxyFromEnv {
+		// Like other tools, respect $NO_PROXY for proxies from the environment
+		ph := xproxy.NewPerHost(d, direct)
+		ph.AddFromString(noProxy())
+		d = ph
+	}
+	t.logger().Debugf("dialing %v via proxy %v", addr, t.proxyURL.Redacted())
+	return d.Dial("tcp", addr)
+}
+
+// fallbackDelay returns the delay after which an address of the other
+// family is dialed in parallel, or a negative one if HappyEyeballs is off,
+// in which case addresses are tried one after the other.
+func (t *Tunnel) fallbackDelay() time.Duration {
+	if t.HappyEyeballs != nil && !*t.HappyEyeballs {
+		return -1
+	}
+	return happyEyeballsDelay
+}
+
+// tosControl returns a dialer control function marking packets with tos,
+// or nil if tos is 0. Failing to do so is not fatal.
+func (t *Tunnel) tosControl(tos int) func(string, string, syscall.RawConn) error {
+	if tos == 0 {
+		return nil
+	}
+	return func(network, _ string, c syscall.RawConn) error {
+		if err := setTOS(network, c, tos); err != nil {
+			t.logger().Debugf("could not set type of service: %v", err)
+		}
+		return nil
+	}
+}
+
+func noProxy() string {
+	if v := os.Getenv("no_proxy"); v != "" {
+		return v
+	}
+	return os.Getenv("NO_PROXY")
+}
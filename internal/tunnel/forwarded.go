@@ -0,0 +1,96 @@
+package tunnel
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// maxHeadSize bounds the request head read for X-Forwarded-For, larger
+// heads are forwarded unchanged
+const maxHeadSize = 64 << 10
+
+// checkForwardedFor validates x_forwarded_for against the tunnel mode
+func (t *Tunnel) checkForwardedFor() error {
+	if !t.ForwardedFor {
+		return nil
+	}
+	if t.Mode != Local && t.Mode != Remote {
+		return fmt.Errorf("x_forwarded_for is only supported in local and remote modes")
+	}
+	if len(t.SNIRoutes) > 0 {
+		return fmt.Errorf("x_forwarded_for cannot be combined with sni_routes")
+	}
+	return nil
+}
+
+// forwardedConn adds an X-Forwarded-For header with the client IP to the
+// first HTTP request read from Conn. Later requests on the same connection
+// are forwarded unchanged.
+type forwardedConn struct {
+	net.Conn
+	ip string
+	r  io.Reader
+}
+
+// forwardedFor wraps c if its client has an IP address
+func forwardedFor(c net.Conn) net.Conn {
+	a, ok := c.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return c
+	}
+	return &forwardedConn{Conn: c, ip: a.IP.String()}
+}
+
+func (c *forwardedConn) Read(b []byte) (int, error) {
+	// Parse lazily, so the target is dialed before the client sends
+	if c.r == nil {
+		c.r = injectForwardedFor(c.Conn, c.ip)
+	}
+	return c.r.Read(b)
+}
+
+// injectForwardedFor reads the request head from r and returns a reader
+// with the header added, or appended to an existing one. Data that does not
+// look like an HTTP/1 request is passed through as read.
+func injectForwardedFor(r io.Reader, ip string) io.Reader {
+	br := bufio.NewReader(r)
+	var head bytes.Buffer
+	passThrough := func() io.Reader {
+		return io.MultiReader(bytes.NewReader(head.Bytes()), br)
+	}
+
+	line, err := br.ReadSlice('\n')
+	head.Write(line)
+	if err != nil || !strings.Contains(string(line), " HTTP/1.") {
+		return passThrough()
+	}
+	var out bytes.Buffer
+	out.Write(line)
+	found := false
+	for {
+		line, err = br.ReadSlice('\n')
+		head.Write(line)
+		if err != nil || head.Len() > maxHeadSize {
+			return passThrough()
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			// End of the header block
+			if !found {
+				fmt.Fprintf(&out, "X-Forwarded-For: %s\r\n", ip)
+			}
+			out.Write(line)
+			return io.MultiReader(&out, br)
+		}
+		name, _, _ := bytes.Cut(line, []byte(":"))
+		if strings.EqualFold(string(bytes.TrimSpace(name)), "X-Forwarded-For") && !found {
+			found = true
+			fmt.Fprintf(&out, "%s, %s\r\n", bytes.TrimRight(line, "\r\n"), ip)
+			continue
+		}
+		out.Write(line)
+	}
+}
@@ -0,0 +1,49 @@
+package tunnel
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestInjectForwardedFor(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{
+			"GET / HTTP/1.1\r\nHost: a\r\n\r\nbody",
+			"GET / HTTP/1.1\r\nHost: a\r\nX-Forwarded-For: 10.0.0.1\r\n\r\nbody",
+		},
+		{
+			"GET / HTTP/1.1\r\nx-forwarded-for: 1.2.3.4\r\n\r\n",
+			"GET / HTTP/1.1\r\nx-forwarded-for: 1.2.3.4, 10.0.0.1\r\n\r\n",
+		},
+		// Only the first request is changed
+		{
+			"GET / HTTP/1.1\r\n\r\nGET /b HTTP/1.1\r\n\r\n",
+			"GET / HTTP/1.1\r\nX-Forwarded-For: 10.0.0.1\r\n\r\nGET /b HTTP/1.1\r\n\r\n",
+		},
+		{"SSH-2.0-OpenSSH\r\n", "SSH-2.0-OpenSSH\r\n"},
+		{"GET / HTTP/1.1\r\nHost: a", "GET / HTTP/1.1\r\nHost: a"},
+	}
+	for _, c := range cases {
+		b, err := io.ReadAll(injectForwardedFor(strings.NewReader(c.in), "10.0.0.1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != c.want {
+			t.Errorf("got %q, want %q", b, c.want)
+		}
+	}
+}
+
+func TestForwardedForMode(t *testing.T) {
+	tun := &Tunnel{Desc: &Desc{ForwardedFor: true, Mode: Socks}}
+	if err := tun.checkForwardedFor(); err == nil {
+		t.Error("expected error in socks mode")
+	}
+	tun.Mode = Local
+	if err := tun.checkForwardedFor(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,102 @@
+package tunnel
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const defaultIdleScanInterval = 30 * time.Second
+
+// idleConn records when bytes were last read from or written to a
+// forwarded stream, such that the reaper can close it once idle for a
+// while, which reaps connections leaked by clients.
+type idleConn struct {
+	net.Conn
+	t    *Tunnel
+	last atomic.Int64 // unix nanoseconds
+}
+
+// reapIdle wraps c such that it is closed after IdleTimeout seconds without
+// activity in either direction. As closing one side of a stream tears down
+// the other, wrapping the local side suffices. If unset, c is returned as is.
+func (t *Tunnel) reapIdle(c net.Conn) net.Conn {
+	if t.IdleTimeout <= 0 {
+		return c
+	}
+	i := &idleConn{Conn: c, t: t}
+	i.last.Store(time.Now().UnixNano())
+	t.idleMu.Lock()
+	if t.idleConns == nil {
+		t.idleConns = make(map[*idleConn]struct{})
+	}
+	t.idleConns[i] = struct{}{}
+	t.idleMu.Unlock()
+	return i
+}
+
+func (i *idleConn) Read(p []byte) (int, error) {
+	n, err := i.Conn.Read(p)
+	if n > 0 {
+		i.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (i *idleConn) Write(p []byte) (int, error) {
+	n, err := i.Conn.Write(p)
+	if n > 0 {
+		i.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (i *idleConn) Close() error {
+	i.t.idleMu.Lock()
+	delete(i.t.idleConns, i)
+	i.t.idleMu.Unlock()
+	return i.Conn.Close()
+}
+
+// idleScanInterval returns how often streams are checked for inactivity,
+// which is never less often than the idle timeout itself
+func (t *Tunnel) idleScanInterval() time.Duration {
+	d := defaultIdleScanInterval
+	if t.IdleScanInterval > 0 {
+		d = time.Duration(t.IdleScanInterval) * time.Second
+	}
+	return min(d, time.Duration(t.IdleTimeout)*time.Second)
+}
+
+// reaper periodically closes idle streams until cancel is closed. A single
+// reaper per tunnel replaces a timer per stream.
+func (t *Tunnel) reaper(cancel <-chan struct{}) {
+	tick := time.NewTicker(t.idleScanInterval())
+	defer tick.Stop()
+	for {
+		select {
+		case <-cancel:
+			return
+		case now := <-tick.C:
+			t.scanIdle(now)
+		}
+	}
+}
+
+// scanIdle closes the streams idle for longer than the timeout at now.
+// They are closed outside of the lock, as closing takes it.
+func (t *Tunnel) scanIdle(now time.Time) {
+	timeout := time.Duration(t.IdleTimeout) * time.Second
+	var idle []*idleConn
+	t.idleMu.Lock()
+	for i := range t.idleConns {
+		if now.Sub(time.Unix(0, i.last.Load())) > timeout {
+			idle = append(idle, i)
+		}
+	}
+	t.idleMu.Unlock()
+	for _, i := range idle {
+		t.logger().Debugf("closing stream from %v after %v of inactivity", i.RemoteAddr(), timeout)
+		i.Close()
+	}
+}
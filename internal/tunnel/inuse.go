@@ -0,0 +1,72 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// PortInUseError is returned if a local address of a tunnel is already
+// bound, e.g., by another tunnel
+type PortInUseError struct {
+	Port int
+	// PID and Command identify the process listening on the port, if known
+	PID     int
+	Command string
+	Err     error
+}
+
+func (e *PortInUseError) Error() string {
+	msg := fmt.Sprintf("port %d already in use", e.Port)
+	if e.PID != 0 {
+		msg += fmt.Sprintf(" by PID %d", e.PID)
+		if e.Command != "" {
+			msg += fmt.Sprintf(" (%v)", e.Command)
+		}
+	}
+	return msg
+}
+
+func (e *PortInUseError) Unwrap() error { return e.Err }
+
+// inUse turns err, as returned by listening on the TCP address addr, into a
+// PortInUseError if the address is already bound
+func inUse(addr string, err error) error {
+	if !errors.Is(err, addrInUse) {
+		return err
+	}
+	_, p, serr := net.SplitHostPort(addr)
+	port, perr := strconv.Atoi(p)
+	if serr != nil || perr != nil {
+		return err
+	}
+	e := &PortInUseError{Port: port, Err: err}
+	e.PID, e.Command = portOwner(port)
+	return e
+}
+
+// CheckLocal returns a PortInUseError if a TCP address the tunnel is to
+// listen on locally is already bound. The addresses are bound briefly to
+// find out. Tunnels in remote modes do not listen locally.
+func (t *Tunnel) CheckLocal() error {
+	if t.Mode == Remote || t.Mode == RemoteSocks {
+		return nil
+	}
+	if !t.prepared {
+		if err := t.parseLocalAddrs(true); err != nil {
+			return err
+		}
+	}
+	for _, a := range t.localAddrs {
+		if a.net != "tcp" {
+			continue
+		}
+		l, err := listen(a)
+		if err != nil {
+			return err
+		}
+		l.Close()
+	}
+	return nil
+}
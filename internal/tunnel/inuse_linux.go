@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const addrInUse = syscall.EADDRINUSE
+
+// portOwner returns the PID and command of the process listening on the
+// TCP port, found through /proc. Processes of other users are not found.
+func portOwner(port int) (int, string) {
+	inodes := make(map[string]bool)
+	for _, f := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		listeningInodes(f, port, inodes)
+	}
+	if len(inodes) == 0 {
+		return 0, ""
+	}
+	fds, _ := filepath.Glob("/proc/[0-9]*/fd/*")
+	for _, fd := range fds {
+		link, err := os.Readlink(fd)
+		if err != nil || !strings.HasPrefix(link, "socket:[") {
+			continue
+		}
+		if !inodes[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] {
+			continue
+		}
+		dir := filepath.Dir(filepath.Dir(fd))
+		pid, _ := strconv.Atoi(filepath.Base(dir))
+		comm, _ := os.ReadFile(filepath.Join(dir, "comm"))
+		return pid, strings.TrimSpace(string(comm))
+	}
+	return 0, ""
+}
+
+// listeningInodes adds the inodes of sockets listening on port, as listed
+// in the /proc/net table file, to inodes
+func listeningInodes(file string, port int, inodes map[string]bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	local := fmt.Sprintf(":%04X", port)
+	sc := bufio.NewScanner(f)
+	sc.Scan() // header
+	for sc.Scan() {
+		// sl local_address rem_address st ... uid timeout inode
+		fields := strings.Fields(sc.Text())
+		if len(fields) < 10 || fields[3] != "0A" || !strings.HasSuffix(fields[1], local) {
+			continue
+		}
+		inodes[fields[9]] = true
+	}
+}
@@ -0,0 +1,37 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestPortInUse(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	port := l.Addr().(*net.TCPAddr).Port
+
+	tun := FromDesc(&Desc{Name: "test", Mode: Local, LocalAddress: Addresses(l.Addr().String())})
+	err = tun.CheckLocal()
+	var e *PortInUseError
+	if !errors.As(err, &e) || e.Port != port {
+		t.Fatalf("expected port in use error, got %v", err)
+	}
+	if runtime.GOOS == "linux" && e.PID != os.Getpid() {
+		t.Errorf("got PID %d, want %d", e.PID, os.Getpid())
+	}
+	if !strings.HasPrefix(err.Error(), "port ") {
+		t.Errorf("unexpected message %q", err)
+	}
+
+	tun = FromDesc(&Desc{Name: "test", Mode: Local, LocalAddress: "127.0.0.1:0"})
+	if err := tun.CheckLocal(); err != nil {
+		t.Errorf("free address reported: %v", err)
+	}
+}
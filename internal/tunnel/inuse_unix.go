@@ -0,0 +1,10 @@
+//go:build !linux && !windows
+
+package tunnel
+
+import "syscall"
+
+const addrInUse = syscall.EADDRINUSE
+
+// portOwner is not supported on this platform
+func portOwner(int) (int, string) { return 0, "" }
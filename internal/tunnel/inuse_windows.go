@@ -0,0 +1,9 @@
+package tunnel
+
+import "syscall"
+
+// addrInUse is WSAEADDRINUSE
+const addrInUse = syscall.Errno(10048)
+
+// portOwner is not supported on this platform
+func portOwner(int) (int, string) { return 0, "" }
@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const defaultConnectLimitWindow = time.Minute
+
+// attemptLimiter limits connection attempts per host within a sliding
+// window. It is shared by all tunnels, so that tunnels connecting to the
+// same host draw from the same budget.
+type attemptLimiter struct {
+	mu     sync.Mutex
+	limit  int
+	window time.Duration
+	// attempts holds the times of attempts per host, in ascending order.
+	// Throttled attempts are recorded at the time they are made.
+	attempts map[string][]time.Time
+}
+
+var connectLimiter attemptLimiter
+
+// SetConnectLimit allows at most limit connection attempts per host and
+// port within window, across all tunnels. A zero limit disables limiting.
+func SetConnectLimit(limit int, window time.Duration) {
+	connectLimiter.mu.Lock()
+	defer connectLimiter.mu.Unlock()
+	if window <= 0 {
+		window = defaultConnectLimitWindow
+	}
+	connectLimiter.limit, connectLimiter.window = limit, window
+}
+
+// reserve records an attempt to connect to key and returns how long to
+// wait before making it.
+func (l *attemptLimiter) reserve(key string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= 0 {
+		return 0
+	}
+	if l.attempts == nil {
+		l.attempts = make(map[string][]time.Time)
+	}
+
+	// Forget attempts that left the window
+	ts := l.attempts[key]
+	i := sort.Search(len(ts), func(i int) bool { return ts[i].After(now.Add(-l.window)) })
+	ts = ts[i:]
+
+	at := now
+	if len(ts) >= l.limit {
+		at = ts[len(ts)-l.limit].Add(l.window)
+	}
+	i = sort.Search(len(ts), func(i int) bool { return ts[i].After(at) })
+	ts = append(ts[:i], append([]time.Time{at}, ts[i:]...)...)
+	l.attempts[key] = ts
+	return at.Sub(now)
+}
+
+// throttle waits until connecting to addr, the first hop, is within the
+// budget set by SetConnectLimit.
+func (t *Tunnel) throttle(addr string) error {
+	wait := connectLimiter.reserve(addr, time.Now())
+	if wait <= 0 {
+		return nil
+	}
+	t.logger().Warningf("too many connection attempts to %v, waiting %v",
+		addr, wait.Round(time.Second))
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-t.stop:
+		return fmt.Errorf("interrupted by stop signal")
+	}
+}
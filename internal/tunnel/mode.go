@@ -1,6 +1,7 @@
 package tunnel
 
 import (
+	"encoding/json"
 	"errors"
 	"strings"
 )
@@ -36,6 +37,21 @@ func (m *Mode) UnmarshalTOML(data any) error {
 	return nil
 }
 
+// UnmarshalJSON accepts mode names as in the config file, as well as the
+// numeric representation used internally.
+func (m *Mode) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return m.UnmarshalTOML(s)
+	}
+	var i int
+	if err := json.Unmarshal(data, &i); err != nil {
+		return errors.New("invalid mode type")
+	}
+	*m = Mode(i)
+	return nil
+}
+
 func (m Mode) String() string {
 	if m == Local || m == Socks {
 		return "->"
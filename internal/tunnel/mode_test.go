@@ -17,3 +17,16 @@ func TestModeUnmarshalInvalidType(t *testing.T) {
 		t.Errorf("incorrect error: %v", err)
 	}
 }
+
+func TestModeUnmarshalJSON(t *testing.T) {
+	var m Mode
+	if err := m.UnmarshalJSON([]byte(`"socks-remote"`)); err != nil || m != RemoteSocks {
+		t.Errorf("got %v, %v", m, err)
+	}
+	if err := m.UnmarshalJSON([]byte(`1`)); err != nil || m != Remote {
+		t.Errorf("got %v, %v", m, err)
+	}
+	if err := m.UnmarshalJSON([]byte(`true`)); err == nil {
+		t.Error("expected error for invalid type")
+	}
+}
@@ -0,0 +1,56 @@
+package tunnel
+
+import (
+	"net"
+	"syscall"
+	"testing"
+)
+
+func noDelayOf(t *testing.T, c *net.TCPConn) bool {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	raw.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_NODELAY)
+	})
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v != 0
+}
+
+func TestSetNoDelay(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			defer c.Close()
+			c.Read(make([]byte, 1))
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	tc := c.(*net.TCPConn)
+
+	off := false
+	tun := FromDesc(&Desc{Name: "test", NoDelay: &off})
+	tun.setNoDelay(c)
+	if noDelayOf(t, tc) {
+		t.Error("TCP_NODELAY still set after disabling")
+	}
+
+	tun.NoDelay = nil
+	tun.setNoDelay(c)
+	if !noDelayOf(t, tc) {
+		t.Error("TCP_NODELAY not set by default")
+	}
+}
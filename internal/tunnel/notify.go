@@ -0,0 +1,89 @@
+package tunnel
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/notify"
+)
+
+// sendNotification shows a desktop notification, replaced in tests
+var sendNotification = notify.Send
+
+// downNotifier reports outages of a tunnel. Only outages lasting longer
+// than the threshold are reported, which suppresses noise from quick
+// re-connects. Once reported, recovery is reported as well.
+type downNotifier struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	since     time.Time
+	timer     *time.Timer
+	notified  bool
+}
+
+func (t *Tunnel) markDown() {
+	d := &t.down
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.threshold = time.Duration(t.DownNotifyAfter) * time.Second
+	d.since = time.Now()
+	d.notified = false
+	if d.threshold == 0 {
+		d.notified = true
+		t.logger().Warningf("disconnected")
+		t.notify("disconnected")
+		return
+	}
+	since := d.since
+	d.timer = time.AfterFunc(d.threshold, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if d.since != since {
+			// Recovered in the meantime
+			return
+		}
+		d.notified = true
+		t.logger().Warningf("down for more than %v", d.threshold)
+		t.notify(fmt.Sprintf("down for more than %v", d.threshold))
+	})
+}
+
+// markUp ends an outage, reporting recovery if the outage was reported.
+func (t *Tunnel) markUp(recovered bool) {
+	d := &t.down
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+	if recovered && d.notified {
+		msg := fmt.Sprintf("recovered after %v", time.Since(d.since).Round(time.Second))
+		t.logger().Infof("%v", msg)
+		t.notify(msg)
+	}
+	d.since = time.Time{}
+	d.notified = false
+}
+
+// outageNotified reports whether the current outage has been reported,
+// in which case details about it are worth logging.
+func (t *Tunnel) outageNotified() bool {
+	t.down.mu.Lock()
+	defer t.down.mu.Unlock()
+	return t.down.notified
+}
+
+// notify shows a desktop notification about the tunnel if Notify is set.
+// It does not block, as notifiers may be slow.
+func (t *Tunnel) notify(msg string) {
+	if !t.Notify {
+		return
+	}
+	title := fmt.Sprintf("Tunnel '%v'", t.Name)
+	go func() {
+		if err := sendNotification(title, msg); err != nil {
+			t.logger().Warningf("could not show notification: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Pause stops forwarding new connections while keeping the SSH connection
+// alive. If drop is set, established connections are closed as well.
+func (t *Tunnel) Pause(drop bool) error {
+	if t.Status != Open && t.Status != Paused {
+		return fmt.Errorf("tunnel not open")
+	}
+	if !t.paused.CompareAndSwap(false, true) {
+		return fmt.Errorf("tunnel already paused")
+	}
+	t.Status = Paused
+	if drop {
+		t.dropStreams()
+	}
+	t.logger().Infof("paused tunnel")
+	return nil
+}
+
+// Resume undoes Pause, new connections are forwarded again.
+func (t *Tunnel) Resume() error {
+	if !t.paused.Swap(false) {
+		return fmt.Errorf("tunnel not paused")
+	}
+	if t.Status == Paused {
+		t.Status = Open
+	}
+	t.logger().Infof("resumed tunnel")
+	return nil
+}
+
+// admit registers an accepted connection, or closes it right away and
+// returns nil if the tunnel is paused or the client is not allowed.
+func (t *Tunnel) admit(conn net.Conn) *stream {
+	if t.paused.Load() {
+		t.logger().Debugf("paused, rejecting connection from %v", conn.RemoteAddr())
+		conn.Close()
+		return nil
+	}
+	if !t.checkClientAllow(conn) {
+		conn.Close()
+		return nil
+	}
+	s := &stream{peer: conn.RemoteAddr().String(), started: time.Now()}
+	t.streamsMu.Lock()
+	defer t.streamsMu.Unlock()
+	if t.streams == nil {
+		t.streams = make(map[net.Conn]*stream)
+	}
+	t.streams[conn] = s
+	return s
+}
+
+func (t *Tunnel) release(conn net.Conn) {
+	t.streamsMu.Lock()
+	delete(t.streams, conn)
+	t.streamsMu.Unlock()
+}
+
+func (t *Tunnel) dropStreams() {
+	t.streamsMu.Lock()
+	defer t.streamsMu.Unlock()
+	for c := range t.streams {
+		c.Close()
+	}
+	t.logger().Debugf("dropped %d active connections", len(t.streams))
+}
@@ -0,0 +1,87 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckClientIDs(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "s"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	uid, gid := os.Getuid(), os.Getgid()
+	cases := []struct {
+		uids, gids []int
+		want       bool
+	}{
+		{nil, nil, true},
+		{[]int{uid}, nil, true},
+		{[]int{uid + 1}, nil, false},
+		{[]int{uid + 1}, []int{gid}, true},
+		{nil, []int{gid + 1}, false},
+	}
+	for _, c := range cases {
+		tun := FromDesc(&Desc{Name: "test", Mode: Local, ClientUIDs: c.uids, ClientGIDs: c.gids})
+		if got := tun.checkClientAllow(conn); got != c.want {
+			t.Errorf("uids %v, gids %v: got %v, want %v", c.uids, c.gids, got, c.want)
+		}
+	}
+
+	// Connections wrapped by TLS are checked as well
+	tun := FromDesc(&Desc{Name: "test", Mode: Local, ClientUIDs: []int{uid + 1}})
+	if tun.checkClientAllow(tls.Server(conn, &tls.Config{})) {
+		t.Error("TLS connection bypassed client_uids")
+	}
+	tun.ClientUIDs = []int{uid}
+	if !tun.checkClientAllow(tls.Server(conn, &tls.Config{})) {
+		t.Error("allowed TLS connection rejected")
+	}
+
+	// Without a socket to get credentials from, connections are rejected
+	p1, p2 := net.Pipe()
+	defer p1.Close()
+	defer p2.Close()
+	if tun.checkClientAllow(p1) {
+		t.Error("connection without peer credentials allowed")
+	}
+
+	tun = FromDesc(&Desc{Name: "test", Mode: Remote, ClientUIDs: []int{uid}})
+	if err := tun.validateClientIDs(); err == nil {
+		t.Error("expected error in remote mode")
+	}
+}
+
+// Client IDs can only be enforced for Unix sockets, so TCP listeners are
+// rejected rather than left open
+func TestValidateClientIDsUnixOnly(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "s")
+	for local, ok := range map[string]bool{
+		sock:                     true,
+		"127.0.0.1:9000":         false,
+		sock + ",127.0.0.1:9000": false,
+	} {
+		tun := FromDesc(&Desc{Name: "test", Mode: Local, LocalAddress: Addresses(local), ClientUIDs: []int{0}})
+		if err := tun.parseLocalAddrs(true); err != nil {
+			t.Fatal(err)
+		}
+		if err := tun.validateClientIDs(); (err == nil) != ok {
+			t.Errorf("%v: got %v", local, err)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package tunnel
+
+import (
+	"errors"
+	"net"
+)
+
+const peerCredSupported = false
+
+// peerCred is not supported on this platform
+func peerCred(*net.UnixConn) (int, int, error) {
+	return 0, 0, errors.New("peer credentials not supported")
+}
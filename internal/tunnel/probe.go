@@ -0,0 +1,40 @@
+package tunnel
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	remoteProbeTimeout = 30 * time.Second
+	maxRemoteProbeWait = 5 * time.Second
+)
+
+// waitForRemote blocks until the remote address accepts connections through
+// the SSH connection, retrying with backoff. This keeps clients from hitting
+// the tunnel before a slowly starting service is listening.
+func (t *Tunnel) waitForRemote() error {
+	timeout := time.After(remoteProbeTimeout)
+	waitTime := 100 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		c, err := t.dialTarget(t.remoteAddr)
+		if err == nil {
+			c.Close()
+			t.logger().Debugf("remote %v is ready", t.remoteAddr.addr)
+			return nil
+		}
+		t.logger().Debugf("remote not ready (attempt %d): %v. Retrying in %v...",
+			attempt, err, waitTime)
+
+		select {
+		case <-timeout:
+			return fmt.Errorf("remote %v not ready after %v: %v",
+				t.remoteAddr.addr, remoteProbeTimeout, err)
+		case <-t.stop:
+			return fmt.Errorf("interrupted by stop signal")
+		case <-time.After(waitTime):
+		}
+		waitTime = min(2*waitTime, maxRemoteProbeWait)
+	}
+}
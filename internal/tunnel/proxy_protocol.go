@@ -0,0 +1,109 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ProxyProtocol selects the PROXY protocol version, if any, that is used to
+// announce the original client address to the forwarding target.
+type ProxyProtocol int
+
+const (
+	ProxyNone ProxyProtocol = iota
+	ProxyV1
+	ProxyV2
+)
+
+var proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+func (p *ProxyProtocol) UnmarshalTOML(data any) error {
+	s, ok := data.(string)
+	if !ok {
+		return errors.New("invalid proxy protocol type")
+	}
+
+	switch strings.ToLower(s) {
+	case "", "none", "off":
+		*p = ProxyNone
+	case "v1", "1":
+		*p = ProxyV1
+	case "v2", "2":
+		*p = ProxyV2
+	default:
+		return errors.New("invalid proxy protocol version")
+	}
+
+	return nil
+}
+
+// UnmarshalJSON accepts versions as in the config file, as well as the
+// numeric representation used internally.
+func (p *ProxyProtocol) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		return p.UnmarshalTOML(s)
+	}
+	var i int
+	if err := json.Unmarshal(data, &i); err != nil {
+		return errors.New("invalid proxy protocol type")
+	}
+	*p = ProxyProtocol(i)
+	return nil
+}
+
+// proxyHeader builds a PROXY protocol header describing a connection from
+// src to dst. Non-TCP connections are announced as unknown (v1) or local (v2).
+func proxyHeader(p ProxyProtocol, src, dst net.Addr) []byte {
+	s, sok := src.(*net.TCPAddr)
+	d, dok := dst.(*net.TCPAddr)
+	tcp := sok && dok
+
+	// Both addresses need to be of the same family
+	s4, d4 := tcp && s.IP.To4() != nil, tcp && d.IP.To4() != nil
+	if s4 != d4 {
+		tcp = false
+	}
+
+	if p == ProxyV1 {
+		if !tcp {
+			return []byte("PROXY UNKNOWN\r\n")
+		}
+		fam := "TCP6"
+		if s4 {
+			fam = "TCP4"
+		}
+		return fmt.Appendf(nil, "PROXY %s %s %s %d %d\r\n", fam, s.IP, d.IP, s.Port, d.Port)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(proxyV2Sig)
+	if !tcp {
+		// Version 2, LOCAL command, unspecified family, no addresses
+		buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		return buf.Bytes()
+	}
+	var addrs []byte
+	fam := byte(0x21) // TCP over IPv6
+	if s4 {
+		fam = 0x11 // TCP over IPv4
+		addrs = append(addrs, s.IP.To4()...)
+		addrs = append(addrs, d.IP.To4()...)
+	} else {
+		addrs = append(addrs, s.IP.To16()...)
+		addrs = append(addrs, d.IP.To16()...)
+	}
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(s.Port))
+	addrs = binary.BigEndian.AppendUint16(addrs, uint16(d.Port))
+
+	// Version 2, PROXY command
+	buf.Write([]byte{0x21, fam})
+	binary.Write(&buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
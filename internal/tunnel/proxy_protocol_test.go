@@ -0,0 +1,71 @@
+package tunnel
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestProxyHeaderV1(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 9000}
+	want := "PROXY TCP4 192.168.1.10 127.0.0.1 51234 9000\r\n"
+	if got := string(proxyHeader(ProxyV1, src, dst)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProxyHeaderV1IPv6(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 51234}
+	dst := &net.TCPAddr{IP: net.ParseIP("::1"), Port: 9000}
+	want := "PROXY TCP6 ::1 ::1 51234 9000\r\n"
+	if got := string(proxyHeader(ProxyV1, src, dst)); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestProxyHeaderV1Unix(t *testing.T) {
+	src := &net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"}
+	if got := string(proxyHeader(ProxyV1, src, src)); got != "PROXY UNKNOWN\r\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestProxyHeaderV2(t *testing.T) {
+	src := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 0x1234}
+	dst := &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0x2328}
+	want := append([]byte{}, proxyV2Sig...)
+	want = append(want, 0x21, 0x11, 0x00, 0x0c,
+		192, 168, 1, 10, 127, 0, 0, 1, 0x12, 0x34, 0x23, 0x28)
+	if got := proxyHeader(ProxyV2, src, dst); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestProxyHeaderV2Unix(t *testing.T) {
+	src := &net.UnixAddr{Name: "/tmp/a.sock", Net: "unix"}
+	want := append(append([]byte{}, proxyV2Sig...), 0x20, 0x00, 0x00, 0x00)
+	if got := proxyHeader(ProxyV2, src, src); !bytes.Equal(got, want) {
+		t.Errorf("got %x, want %x", got, want)
+	}
+}
+
+func TestProxyProtocolUnmarshalInvalid(t *testing.T) {
+	var p ProxyProtocol
+	if err := p.UnmarshalTOML("v3"); err == nil {
+		t.Error("expected error for invalid version")
+	}
+}
+
+func TestProxyProtocolUnmarshalJSON(t *testing.T) {
+	for in, want := range map[string]ProxyProtocol{`"v1"`: ProxyV1, `"2"`: ProxyV2, `2`: ProxyV2, `"none"`: ProxyNone} {
+		var p ProxyProtocol
+		if err := p.UnmarshalJSON([]byte(in)); err != nil || p != want {
+			t.Errorf("%v: got %v, %v", in, p, err)
+		}
+	}
+	var p ProxyProtocol
+	if err := p.UnmarshalJSON([]byte(`true`)); err == nil {
+		t.Error("expected error for invalid type")
+	}
+}
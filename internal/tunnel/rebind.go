@@ -0,0 +1,82 @@
+package tunnel
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// errListen is returned by Open if the forward's listener cannot be set up
+var errListen = errors.New("cannot listen")
+
+// acceptFailed handles an error returned by Accept and reports whether
+// accepting should continue. A local listener failing for reasons other
+// than being closed is rebound, keeping the SSH connection alive, unless
+// the tunnel is to exit on forward failure.
+func (t *Tunnel) acceptFailed(err error, disconn <-chan struct{}) bool {
+	if isTemporary(err) {
+		return t.acceptBackoff(err, disconn)
+	}
+	if t.exitOnFailure && t.listensLocally() && !errors.Is(err, net.ErrClosed) {
+		t.logger().Errorf("listener failed: %v", err)
+		t.forwardFailed.Store(true)
+		return false
+	}
+	if errors.Is(err, net.ErrClosed) || !t.canRebind() {
+		t.logger().Errorf("could not accept: %v", err)
+		return false
+	}
+	t.logger().Warningf("listener failed: %v", err)
+	return t.rebindListener(disconn)
+}
+
+// canRebind reports whether the listener is on our side and rebinding is
+// not turned off
+func (t *Tunnel) canRebind() bool {
+	return t.listensLocally() && (t.RebindListener == nil || *t.RebindListener)
+}
+
+// listensLocally reports whether the listener is on our side. Errors of
+// remote listeners mean that the SSH connection is gone.
+func (t *Tunnel) listensLocally() bool {
+	return t.Mode == Local || t.Mode == Socks
+}
+
+// rebindListener replaces the local listener, retrying with backoff. It
+// returns false if the tunnel is stopped or disconnected in the meantime.
+func (t *Tunnel) rebindListener(disconn <-chan struct{}) bool {
+	t.listener.Close()
+	waitTime := initReconnectWait
+
+	for attempt := 1; ; attempt++ {
+		l, err := listenAll(t.localAddrs)
+		if err == nil {
+			t.listenerMu.Lock()
+			defer t.listenerMu.Unlock()
+			// run() closes the listener once stopped or disconnected,
+			// make sure not to install a new one afterwards
+			select {
+			case <-t.stop:
+			case <-disconn:
+			default:
+				t.setBound(l)
+				t.listener = t.secure(l)
+				t.logger().Infof("rebound listener on %v", l.Addr())
+				return true
+			}
+			l.Close()
+			return false
+		}
+
+		t.logger().Warningf("could not rebind listener (attempt %d): %v. Retrying in %v...",
+			attempt, err, waitTime)
+		select {
+		case <-t.stop:
+			return false
+		case <-disconn:
+			return false
+		case <-time.After(waitTime):
+		}
+		waitTime = min(waitTime*2, maxReconnectWait)
+	}
+}
@@ -0,0 +1,119 @@
+package tunnel
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const drainPoll = 100 * time.Millisecond
+
+// rotation hands a new client to the running tunnel, along with a function
+// waiting for the clients of all its hops to close
+type rotation struct {
+	client *ssh.Client
+	wait   func()
+}
+
+// Rotate replaces the SSH connection without interrupting the tunnel, e.g.,
+// to pick up a renewed certificate. Keys and certificates are loaded again
+// and host keys verified afresh. A new connection is established first,
+// new tunnel connections then use it, and the old one is closed once the
+// connections made through it are done. Remote listeners are bound to the
+// SSH connection, so only local and socks tunnels can be rotated, as long as
+// they do not share their connection.
+func (t *Tunnel) Rotate() error {
+	if t.Mode == Remote || t.Mode == RemoteSocks {
+		return fmt.Errorf("cannot rotate %v tunnel", t.Mode)
+	}
+	if t.Status != Open && t.Status != Paused {
+		return fmt.Errorf("tunnel not open")
+	}
+	if t.shared() {
+		return fmt.Errorf("cannot rotate shared SSH connection")
+	}
+	bastions, err := t.resolveBastions()
+	if err != nil {
+		return err
+	}
+	c, wait, err := t.dialBastions(bastions)
+	if err != nil {
+		return fmt.Errorf("could not connect: %v", err)
+	}
+	// Re-connects use the renewed credentials as well
+	t.setBastions(bastions)
+	select {
+	case t.rotate <- rotation{c, wait}:
+		t.logger().Infof("rotated SSH connection (%v)", t.Fingerprint())
+		return nil
+	case <-t.Closed:
+		c.Close()
+		wait()
+		return fmt.Errorf("tunnel closed")
+	}
+}
+
+func (t *Tunnel) currentClient() *ssh.Client {
+	t.clientMu.Lock()
+	defer t.clientMu.Unlock()
+	return t.client
+}
+
+// setClient makes c the current client and returns the previous one
+func (t *Tunnel) setClient(c *ssh.Client) (old *ssh.Client) {
+	t.clientMu.Lock()
+	defer t.clientMu.Unlock()
+	old, t.client = t.client, c
+	return
+}
+
+// clientClosed returns a channel closed once c is closed
+func clientClosed(c *ssh.Client) <-chan struct{} {
+	closed := make(chan struct{})
+	go func() {
+		c.Wait()
+		close(closed)
+	}()
+	return closed
+}
+
+func (t *Tunnel) activeStreams() []net.Conn {
+	t.streamsMu.Lock()
+	defer t.streamsMu.Unlock()
+	conns := make([]net.Conn, 0, len(t.streams))
+	for c := range t.streams {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// drain closes old once all pending connections have been released, or
+// right away if the tunnel is stopped or disconnected.
+func (t *Tunnel) drain(old io.Closer, pending []net.Conn, cancel <-chan struct{}) {
+	defer old.Close()
+	t.logger().Debugf("draining %d connection(s) of previous SSH connection", len(pending))
+	tick := time.NewTicker(drainPoll)
+	defer tick.Stop()
+	for {
+		t.streamsMu.Lock()
+		for len(pending) > 0 {
+			if _, ok := t.streams[pending[0]]; ok {
+				break
+			}
+			pending = pending[1:]
+		}
+		t.streamsMu.Unlock()
+		if len(pending) == 0 {
+			t.logger().Debugf("closing previous SSH connection")
+			return
+		}
+		select {
+		case <-cancel:
+			return
+		case <-tick.C:
+		}
+	}
+}
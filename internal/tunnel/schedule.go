@@ -0,0 +1,113 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// Schedule is a daily time window, optionally on some days of the week
+// only, during which a tunnel is kept open, e.g., "Mon-Fri 09:00-18:00".
+// Windows may span midnight, as in "22:00-06:00", in which case the days
+// refer to the start of the window. Times are local.
+type Schedule struct {
+	days [7]bool
+	// start and end are minutes since midnight
+	start, end int
+}
+
+// ParseSchedule parses a schedule like "Mon-Fri 09:00-18:00". Days are
+// given as a comma-separated list of days and day ranges, and default to
+// every day. An empty string yields a nil schedule.
+func ParseSchedule(s string) (*Schedule, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	if len(fields) > 2 {
+		return nil, fmt.Errorf("invalid schedule %q, expected '[days] HH:MM-HH:MM'", s)
+	}
+
+	sch := &Schedule{}
+	if len(fields) == 2 {
+		if err := sch.parseDays(fields[0]); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", s, err)
+		}
+	} else {
+		sch.days = [7]bool{true, true, true, true, true, true, true}
+	}
+
+	from, to, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid schedule %q, expected '[days] HH:MM-HH:MM'", s)
+	}
+	var err error
+	if sch.start, err = parseClock(from); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %v", s, err)
+	}
+	if sch.end, err = parseClock(to); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %v", s, err)
+	}
+	if sch.start == sch.end {
+		return nil, fmt.Errorf("invalid schedule %q: empty window", s)
+	}
+	return sch, nil
+}
+
+func (s *Schedule) parseDays(spec string) error {
+	for _, part := range strings.Split(spec, ",") {
+		from, to, isRange := strings.Cut(part, "-")
+		first, err := parseWeekday(from)
+		if err != nil {
+			return err
+		}
+		last := first
+		if isRange {
+			if last, err = parseWeekday(to); err != nil {
+				return err
+			}
+		}
+		// Ranges may wrap around, as in "Fri-Mon"
+		for d := first; ; d = (d + 1) % 7 {
+			s.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseWeekday(s string) (int, error) {
+	for i, d := range weekdays {
+		if strings.EqualFold(s, d) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown day %q", s)
+}
+
+// parseClock parses "HH:MM" into minutes since midnight
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Active tells whether at lies within the schedule
+func (s *Schedule) Active(at time.Time) bool {
+	m := at.Hour()*60 + at.Minute()
+	day := int(at.Weekday())
+	if s.start < s.end {
+		return s.days[day] && s.start <= m && m < s.end
+	}
+	// The window spans midnight
+	if m >= s.start {
+		return s.days[day]
+	}
+	return m < s.end && s.days[(day+6)%7]
+}
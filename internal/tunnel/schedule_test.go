@@ -0,0 +1,52 @@
+package tunnel
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	// 2024-01-01 was a Monday
+	at := func(day int, clock string) time.Time {
+		c, _ := time.Parse("15:04", clock)
+		return time.Date(2024, 1, day, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+	cases := []struct {
+		sched string
+		at    time.Time
+		want  bool
+	}{
+		{"09:00-18:00", at(1, "09:00"), true},
+		{"09:00-18:00", at(1, "18:00"), false},
+		{"09:00-18:00", at(6, "12:00"), true},
+		{"Mon-Fri 09:00-18:00", at(5, "12:00"), true},
+		{"Mon-Fri 09:00-18:00", at(6, "12:00"), false},
+		{"sat,sun 10:00-12:00", at(7, "11:59"), true},
+		{"Fri-Mon 10:00-12:00", at(1, "11:00"), true},
+		{"Fri-Mon 10:00-12:00", at(3, "11:00"), false},
+		// Overnight windows belong to the day they start
+		{"Mon 22:00-06:00", at(1, "23:00"), true},
+		{"Mon 22:00-06:00", at(2, "05:59"), true},
+		{"Mon 22:00-06:00", at(1, "05:00"), false},
+		{"Mon 22:00-06:00", at(2, "12:00"), false},
+	}
+	for _, c := range cases {
+		s, err := ParseSchedule(c.sched)
+		if err != nil {
+			t.Fatalf("%q: %v", c.sched, err)
+		}
+		if got := s.Active(c.at); got != c.want {
+			t.Errorf("%q at %v: got %v, want %v", c.sched, c.at, got, c.want)
+		}
+	}
+
+	if s, err := ParseSchedule(""); s != nil || err != nil {
+		t.Errorf("got %v, %v for empty schedule", s, err)
+	}
+	for _, bad := range []string{"9-17", "Mon-Fri", "Mon-Fun 09:00-17:00", "09:00-09:00",
+		"09:00-25:00", "Mon 09:00-17:00 extra"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("%q: expected error", bad)
+		}
+	}
+}
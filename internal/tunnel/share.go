@@ -0,0 +1,244 @@
+package tunnel
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+// Tunnels may share their SSH connection, like with ControlMaster in
+// ssh(1): the first tunnel to connect establishes it, others open their
+// channels over it. Once no tunnel uses it anymore, the connection is
+// closed, or kept for ControlPersist.
+var (
+	shared   = map[string]*sharedConn{}
+	sharedMu sync.Mutex
+)
+
+// shareSpec describes under which key a connection is shared
+type shareSpec struct {
+	key     string
+	persist time.Duration
+}
+
+// sharing returns how the connection through hops, whose destination has
+// ssh config sc, is shared, or nil if it is not. Remote listeners are
+// bound to the SSH connection, so only local and socks tunnels share.
+func (t *Tunnel) sharing(sc *ssh_config.SSHConfig, hops []ssh_config.Hop) (*shareSpec, error) {
+	on := sc.ControlPath != ""
+	if t.ShareConnection != nil {
+		on = *t.ShareConnection
+	}
+	if !on {
+		return nil, nil
+	}
+	if t.Mode == Remote || t.Mode == RemoteSocks {
+		if t.ShareConnection != nil {
+			return nil, fmt.Errorf("connection sharing is not supported in %v mode", t.Mode)
+		}
+		return nil, nil
+	}
+	key := sc.ControlPath
+	if key == "" {
+		parts := make([]string, len(hops))
+		for i, h := range hops {
+			parts[i] = h.User + "@" + h.Addr()
+		}
+		key = strings.Join(parts, ",")
+	}
+	return &shareSpec{key, sc.ControlPersist}, nil
+}
+
+// shared reports whether the tunnel's current connection is shared
+func (t *Tunnel) shared() bool {
+	bastions := t.currentBastions()
+	k := int(t.bastion.Load())
+	return k < len(bastions) && bastions[k].share != nil
+}
+
+type sharedConn struct {
+	key     string
+	ready   chan struct{} // closed once connected or failed
+	done    chan struct{} // closed once the connection is closed
+	client  *ssh.Client
+	hostKey ssh.PublicKey
+	err     error
+	refs    int
+	persist time.Duration
+	timer   *time.Timer
+}
+
+// dialShared returns a client for the connection shared under b's key,
+// connecting to b if there is none yet.
+func (t *Tunnel) dialShared(b bastion) (*ssh.Client, error) {
+	sharedMu.Lock()
+	s, found := shared[b.share.key]
+	if !found {
+		s = &sharedConn{
+			key:   b.share.key,
+			ready: make(chan struct{}),
+			done:  make(chan struct{}),
+		}
+		shared[s.key] = s
+	}
+	s.refs++
+	s.persist = b.share.persist
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	sharedMu.Unlock()
+
+	if !found {
+		s.client, _, s.err = t.dialChain(b.hops)
+		if s.err != nil {
+			sharedMu.Lock()
+			delete(shared, s.key)
+			sharedMu.Unlock()
+		} else {
+			s.hostKey = t.ServerHostKey()
+			go s.watch()
+		}
+		close(s.ready)
+	}
+	<-s.ready
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	if found {
+		// The connection may have been set up by a tunnel with a laxer
+		// host key policy, verify the key like this tunnel would
+		last := b.hops[len(b.hops)-1]
+		if err := last.ClientConfig.HostKeyCallback(last.Addr(), s.client.RemoteAddr(), s.hostKey); err != nil {
+			s.release()
+			return nil, fmt.Errorf("host key of shared SSH connection %v rejected: %v", s.key, err)
+		}
+		t.logger().Debugf("using shared SSH connection %v", s.key)
+		t.hostKeyMu.Lock()
+		t.hostKey = s.hostKey
+		t.hostKeyMu.Unlock()
+		t.recordServerVersion(s.client)
+	}
+	v := &sharedView{
+		Conn:   s.client,
+		s:      s,
+		chans:  map[ssh.Channel]struct{}{},
+		closed: make(chan struct{}),
+	}
+	// Channels opened by the server go to the client owning the connection
+	nc := make(chan ssh.NewChannel)
+	close(nc)
+	reqs := make(chan *ssh.Request)
+	close(reqs)
+	return ssh.NewClient(v, nc, reqs), nil
+}
+
+// watch forgets the connection once it is closed
+func (s *sharedConn) watch() {
+	s.client.Wait()
+	sharedMu.Lock()
+	if shared[s.key] == s {
+		delete(shared, s.key)
+	}
+	sharedMu.Unlock()
+	close(s.done)
+}
+
+// release drops a reference to the connection, closing it right away or
+// after the persist time once it is unused.
+func (s *sharedConn) release() {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if s.refs--; s.refs > 0 || s.persist < 0 {
+		return
+	}
+	if s.persist == 0 {
+		s.closeLocked()
+		return
+	}
+	s.timer = time.AfterFunc(s.persist, func() {
+		sharedMu.Lock()
+		defer sharedMu.Unlock()
+		if s.refs == 0 {
+			s.closeLocked()
+		}
+	})
+}
+
+func (s *sharedConn) closeLocked() {
+	if shared[s.key] == s {
+		delete(shared, s.key)
+	}
+	s.client.Close()
+}
+
+// sharedView is a tunnel's handle on a shared connection. Closing it closes
+// the channels opened through it and releases the connection.
+type sharedView struct {
+	ssh.Conn
+	s      *sharedConn
+	chans  map[ssh.Channel]struct{}
+	mu     sync.Mutex
+	closed chan struct{}
+	once   sync.Once
+}
+
+func (v *sharedView) OpenChannel(name string, data []byte) (ssh.Channel, <-chan *ssh.Request, error) {
+	ch, reqs, err := v.Conn.OpenChannel(name, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	select {
+	case <-v.closed:
+		ch.Close()
+		return nil, nil, fmt.Errorf("connection closed")
+	default:
+	}
+	vc := &viewChannel{Channel: ch, v: v}
+	v.chans[vc] = struct{}{}
+	return vc, reqs, nil
+}
+
+func (v *sharedView) Close() error {
+	v.once.Do(func() {
+		v.mu.Lock()
+		close(v.closed)
+		chans := v.chans
+		v.chans = nil
+		v.mu.Unlock()
+		for ch := range chans {
+			ch.Close()
+		}
+		v.s.release()
+	})
+	return nil
+}
+
+func (v *sharedView) Wait() error {
+	select {
+	case <-v.closed:
+		return nil
+	case <-v.s.done:
+		return v.s.client.Wait()
+	}
+}
+
+// viewChannel is a channel opened through a sharedView
+type viewChannel struct {
+	ssh.Channel
+	v *sharedView
+}
+
+func (c *viewChannel) Close() error {
+	c.v.mu.Lock()
+	delete(c.v.chans, c)
+	c.v.mu.Unlock()
+	return c.Channel.Close()
+}
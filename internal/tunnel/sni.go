@@ -0,0 +1,98 @@
+package tunnel
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// sniTimeout bounds waiting for a TLS ClientHello, after which connections
+// go to the default target
+const sniTimeout = 5 * time.Second
+
+var errPeeked = errors.New("client hello peeked")
+
+// prepareSNI parses the targets of SNI routes. Server names are matched
+// case-insensitively, "*.example.com" matches any subdomain.
+func (t *Tunnel) prepareSNI() error {
+	t.sniRoutes = nil
+	if len(t.SNIRoutes) == 0 {
+		return nil
+	}
+	if t.Mode != Local {
+		return fmt.Errorf("SNI routes are only supported in local mode")
+	}
+	if t.tlsConfig != nil {
+		return fmt.Errorf("SNI routes cannot be combined with tls")
+	}
+	t.sniRoutes = make(map[string]*address, len(t.SNIRoutes))
+	for name, target := range t.SNIRoutes {
+		a, err := parseAddr(t.expand(target), false)
+		if err != nil {
+			return fmt.Errorf("SNI route %v: %v", name, err)
+		}
+		t.sniRoutes[strings.ToLower(name)] = a
+	}
+	return nil
+}
+
+// route peeks the server name c asks for in its TLS ClientHello and
+// returns the target routed to, or the remote address if c is not TLS or
+// the name has no route. The returned conn replays the bytes peeked.
+func (t *Tunnel) route(c net.Conn) (net.Conn, *address) {
+	name, peeked := peekSNI(c)
+	c = &prefixConn{c, io.MultiReader(bytes.NewReader(peeked), c)}
+	if name == "" {
+		return c, t.remoteAddr
+	}
+	name = strings.ToLower(name)
+	a, ok := t.sniRoutes[name]
+	if i := strings.IndexByte(name, '.'); !ok && i > 0 {
+		a, ok = t.sniRoutes["*"+name[i:]]
+	}
+	if !ok {
+		return c, t.remoteAddr
+	}
+	t.logger().Debugf("routing %v to %v", name, a.addr)
+	return c, a
+}
+
+// peekSNI returns the server name of the TLS ClientHello c starts with, or
+// an empty string, along with the bytes read from c
+func peekSNI(c net.Conn) (string, []byte) {
+	var buf bytes.Buffer
+	var name string
+	c.SetReadDeadline(time.Now().Add(sniTimeout))
+	defer c.SetReadDeadline(time.Time{})
+	conf := &tls.Config{
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			name = h.ServerName
+			return nil, errPeeked
+		},
+	}
+	// The handshake stops once the ClientHello is read, nothing is written
+	tls.Server(readOnlyConn{io.TeeReader(c, &buf), c}, conf).Handshake()
+	return name, buf.Bytes()
+}
+
+// readOnlyConn reads from r and discards writes
+type readOnlyConn struct {
+	r io.Reader
+	net.Conn
+}
+
+func (c readOnlyConn) Read(b []byte) (int, error)  { return c.r.Read(b) }
+func (c readOnlyConn) Write(b []byte) (int, error) { return len(b), nil }
+
+// prefixConn reads from r, which replays bytes already read from Conn
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) { return c.r.Read(b) }
@@ -0,0 +1,59 @@
+package tunnel
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestRouteSNI(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", Mode: Local, SNIRoutes: map[string]string{
+		"db.example.com":  "db:5432",
+		"*.apps.internal": "ingress:443",
+	}})
+	tun.remoteAddr = &address{"default:443", "tcp"}
+	if err := tun.prepareSNI(); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"DB.example.com":    "db:5432",
+		"web.apps.internal": "ingress:443",
+		"other.example.com": "default:443",
+		"":                  "default:443",
+	}
+	for name, want := range cases {
+		client, server := net.Pipe()
+		go tls.Client(client, &tls.Config{ServerName: name, InsecureSkipVerify: true}).Handshake()
+		c, a := tun.route(server)
+		if a.addr != want {
+			t.Errorf("%q: routed to %v, want %v", name, a.addr, want)
+		}
+		// The ClientHello must be passed on untouched
+		b := make([]byte, 1)
+		if _, err := io.ReadFull(c, b); err != nil || b[0] != 0x16 {
+			t.Errorf("%q: got %x, %v, want handshake record", name, b, err)
+		}
+		client.Close()
+		server.Close()
+	}
+
+	// Connections not speaking TLS go to the default target
+	client, server := net.Pipe()
+	defer client.Close()
+	go client.Write([]byte("GET / HTTP/1.1\r\n\r\n"))
+	c, a := tun.route(server)
+	if a.addr != "default:443" {
+		t.Errorf("plain connection routed to %v", a.addr)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(c, b); err != nil || string(b) != "GET /" {
+		t.Errorf("got %q, %v", b, err)
+	}
+
+	tun.Mode = Remote
+	if err := tun.prepareSNI(); err == nil {
+		t.Error("expected error in remote mode")
+	}
+}
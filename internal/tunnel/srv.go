@@ -0,0 +1,44 @@
+package tunnel
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// srvScheme prefixes remote addresses given as DNS SRV names, as in
+// "srv://_postgres._tcp.example.com"
+const srvScheme = "srv://"
+
+// lookupSRV is net.LookupSRV, replaceable in tests
+var lookupSRV = net.LookupSRV
+
+// resolveSRV looks up the SRV name and returns the address of a target. The
+// records are ordered by priority and shuffled by weight by net.LookupSRV,
+// so the first one is picked as described in RFC 2782.
+func resolveSRV(name string) (string, error) {
+	_, recs, err := lookupSRV("", "", name)
+	if err != nil {
+		return "", fmt.Errorf("SRV lookup of %v failed: %v", name, err)
+	}
+	if len(recs) == 0 || recs[0].Target == "." {
+		return "", fmt.Errorf("no service available at %v", name)
+	}
+	host := strings.TrimSuffix(recs[0].Target, ".")
+	return net.JoinHostPort(host, strconv.Itoa(int(recs[0].Port))), nil
+}
+
+// dialTarget dials a forwarding target, resolving SRV names anew on each
+// call, such that tunnels follow services that move.
+func (t *Tunnel) dialTarget(a *address) (net.Conn, error) {
+	if a.net != "srv" {
+		return t.dial(a.net, a.addr)
+	}
+	addr, err := resolveSRV(a.addr)
+	if err != nil {
+		return nil, err
+	}
+	t.logger().Debugf("resolved %v to %v", a.addr, addr)
+	return t.dial("tcp", addr)
+}
@@ -0,0 +1,73 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// stallConn tracks writes to a connection to detect streams that stall
+// with data pending, e.g., due to MTU issues on the path. Such streams make
+// progress first, then hang without any error.
+type stallConn struct {
+	net.Conn
+	t       *Tunnel
+	mu      sync.Mutex
+	pending time.Time // start of the write in progress, if any
+	written int64
+	warned  bool
+	done    chan struct{}
+	once    sync.Once
+}
+
+// watchStalls wraps c such that a warning is logged once a write to it has
+// been pending for longer than StallWarning seconds. If unset, c is
+// returned as is.
+func (t *Tunnel) watchStalls(c net.Conn) net.Conn {
+	if t.StallWarning <= 0 {
+		return c
+	}
+	s := &stallConn{Conn: c, t: t, done: make(chan struct{})}
+	go s.watch(time.Duration(t.StallWarning) * time.Second)
+	return s
+}
+
+func (s *stallConn) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	s.pending = time.Now()
+	s.mu.Unlock()
+
+	n, err := s.Conn.Write(p)
+
+	s.mu.Lock()
+	s.pending = time.Time{}
+	s.written += int64(n)
+	s.warned = false
+	s.mu.Unlock()
+	return n, err
+}
+
+func (s *stallConn) Close() error {
+	s.once.Do(func() { close(s.done) })
+	return s.Conn.Close()
+}
+
+func (s *stallConn) watch(threshold time.Duration) {
+	tick := time.NewTicker(threshold / 4)
+	defer tick.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick.C:
+		}
+		s.mu.Lock()
+		if !s.pending.IsZero() && !s.warned && time.Since(s.pending) >= threshold {
+			s.warned = true
+			s.t.logger().Warningf("stream to %v stalled with data pending for %v "+
+				"after %d bytes, this may indicate an MTU problem",
+				s.RemoteAddr(), time.Since(s.pending).Round(time.Second), s.written)
+		}
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,104 @@
+package tunnel
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Stats are counters of a tunnel, kept across re-connects
+type Stats struct {
+	// BytesIn and BytesOut count bytes received from and sent to clients
+	// of the tunnel's listener
+	BytesIn, BytesOut uint64
+	// ActiveConns is the number of connections currently forwarded
+	ActiveConns int
+	// Reconnects counts successful re-connects
+	Reconnects uint64
+}
+
+func (t *Tunnel) Stats() Stats {
+	t.streamsMu.Lock()
+	active := len(t.streams)
+	t.streamsMu.Unlock()
+	return Stats{
+		BytesIn:     t.bytesIn.Load(),
+		BytesOut:    t.bytesOut.Load(),
+		ActiveConns: active,
+		Reconnects:  t.reconnects.Load(),
+	}
+}
+
+// StatsDeltas keeps a baseline of a tunnel's counters, such that a caller
+// can collect per-interval deltas without resetting the counters, which
+// other callers may be reading as well.
+type StatsDeltas struct {
+	t    *Tunnel
+	mu   sync.Mutex
+	last Stats
+}
+
+// NewStatsDeltas starts from a zero baseline, so that the first call to
+// Next returns the counters as they are.
+func (t *Tunnel) NewStatsDeltas() *StatsDeltas {
+	return &StatsDeltas{t: t}
+}
+
+// Next returns the change in counters since the previous call. ActiveConns
+// is not a counter and is returned as is. Counters lowered by RestoreStats
+// are taken to have started over.
+func (d *StatsDeltas) Next() Stats {
+	cur := d.t.Stats()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delta := Stats{
+		BytesIn:     since(cur.BytesIn, d.last.BytesIn),
+		BytesOut:    since(cur.BytesOut, d.last.BytesOut),
+		ActiveConns: cur.ActiveConns,
+		Reconnects:  since(cur.Reconnects, d.last.Reconnects),
+	}
+	d.last = cur
+	return delta
+}
+
+func since(cur, last uint64) uint64 {
+	if cur < last {
+		return cur
+	}
+	return cur - last
+}
+
+// countConn counts the bytes transferred over a client connection
+type countConn struct {
+	net.Conn
+	in, out *atomic.Uint64
+	s       *stream
+}
+
+// count wraps a connection accepted by the tunnel's listener, such that
+// its traffic is reflected in the tunnel's stats and those of s.
+func (t *Tunnel) count(c net.Conn, s *stream) net.Conn {
+	return &countConn{Conn: c, in: &t.bytesIn, out: &t.bytesOut, s: s}
+}
+
+func (c *countConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.in.Add(uint64(n))
+	c.s.in.Add(uint64(n))
+	return n, err
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.out.Add(uint64(n))
+	c.s.out.Add(uint64(n))
+	return n, err
+}
+
+// RestoreStats continues the counters from s, e.g., after a daemon restart.
+// ActiveConns is ignored.
+func (t *Tunnel) RestoreStats(s Stats) {
+	t.bytesIn.Store(s.BytesIn)
+	t.bytesOut.Store(s.BytesOut)
+	t.reconnects.Store(s.Reconnects)
+}
@@ -1,6 +1,8 @@
 package tunnel
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"strconv"
 )
@@ -21,6 +23,44 @@ func (s *StringOrInt) UnmarshalTOML(v any) error {
 	return nil
 }
 
+// UnmarshalJSON accepts the same values as the config file
+func (s *StringOrInt) UnmarshalJSON(data []byte) error {
+	v, err := jsonValue(data)
+	if err != nil || v == nil {
+		return err
+	}
+	return s.UnmarshalTOML(v)
+}
+
+// jsonValue decodes data into the types the TOML decoder produces, such
+// that UnmarshalTOML can be reused for JSON. Integers become int64; null
+// becomes nil.
+func jsonValue(data []byte) (any, error) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	return tomlTypes(v), nil
+}
+
+func tomlTypes(v any) any {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []any:
+		for i := range v {
+			v[i] = tomlTypes(v[i])
+		}
+	}
+	return v
+}
+
 func (s StringOrInt) String() string {
 	return string(s)
 }
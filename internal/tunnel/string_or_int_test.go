@@ -12,3 +12,18 @@ func TestStringOrIntInvalidType(t *testing.T) {
 		t.Errorf("incorrect error: %v", err)
 	}
 }
+
+func TestStringOrIntUnmarshalJSON(t *testing.T) {
+	for in, want := range map[string]StringOrInt{`22`: "22", `"22"`: "22", `null`: ""} {
+		var s StringOrInt
+		if err := s.UnmarshalJSON([]byte(in)); err != nil || s != want {
+			t.Errorf("%v: got %q, %v", in, s, err)
+		}
+	}
+	for _, in := range []string{`2.5`, `true`, `["22"]`} {
+		var s StringOrInt
+		if err := s.UnmarshalJSON([]byte(in)); err == nil {
+			t.Errorf("%v: expected error", in)
+		}
+	}
+}
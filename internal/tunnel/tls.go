@@ -0,0 +1,126 @@
+package tunnel
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	// selfSignedValidity is how long generated certificates are valid
+	selfSignedValidity = 365 * 24 * time.Hour
+	// handshakeTimeout bounds TLS handshakes with local clients
+	handshakeTimeout = 10 * time.Second
+)
+
+// prepareTLS sets up TLS termination on the local listener, with the
+// configured certificate or a self-signed one.
+func (t *Tunnel) prepareTLS() error {
+	t.tlsConfig = nil
+	if !t.TLS && t.TLSCert == "" && t.TLSKey == "" {
+		return nil
+	}
+	if t.Mode != Local {
+		return fmt.Errorf("TLS is only supported in local mode")
+	}
+	if (t.TLSCert == "") != (t.TLSKey == "") {
+		return fmt.Errorf("tls_cert and tls_key must be given together")
+	}
+
+	var cert tls.Certificate
+	var err error
+	if t.TLSCert != "" {
+		if cert, err = tls.LoadX509KeyPair(t.TLSCert, t.TLSKey); err != nil {
+			return fmt.Errorf("could not load TLS certificate: %v", err)
+		}
+	} else {
+		if cert, err = selfSigned(t.localHosts()); err != nil {
+			return fmt.Errorf("could not generate TLS certificate: %v", err)
+		}
+		t.logger().Infof("generated self-signed TLS certificate (SHA256 %x)",
+			sha256.Sum256(cert.Certificate[0]))
+	}
+	t.tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	return nil
+}
+
+// secure wraps l to terminate TLS, if enabled
+func (t *Tunnel) secure(l net.Listener) net.Listener {
+	if t.tlsConfig == nil {
+		return l
+	}
+	return tls.NewListener(l, t.tlsConfig)
+}
+
+// handshake completes the TLS handshake with a client, if TLS is enabled,
+// such that the target is only dialed for clients speaking TLS. It reports
+// whether c can be forwarded.
+func (t *Tunnel) handshake(c net.Conn) bool {
+	tc, ok := c.(*tls.Conn)
+	if !ok {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handshakeTimeout)
+	defer cancel()
+	if err := tc.HandshakeContext(ctx); err != nil {
+		t.logger().Debugf("TLS handshake with %v failed: %v", c.RemoteAddr(), err)
+		c.Close()
+		return false
+	}
+	return true
+}
+
+// localHosts returns the names the local listener is reached by
+func (t *Tunnel) localHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	for _, a := range t.localAddrs {
+		if h, _, err := net.SplitHostPort(a.addr); err == nil && h != "" {
+			hosts = append(hosts, strings.SplitN(h, "%", 2)[0])
+		}
+	}
+	return hosts
+}
+
+// selfSigned generates a certificate valid for hosts, which are names or
+// IP addresses
+func selfSigned(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "boring"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(selfSignedValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,35 @@
+package tunnel
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestPrepareTLS(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "t", TLS: true, Mode: Local})
+	tun.localAddrs = []*address{{"192.168.1.5:8443", "tcp"}}
+	if err := tun.prepareTLS(); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(tun.tlsConfig.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"localhost", "127.0.0.1", "192.168.1.5"} {
+		if err := cert.VerifyHostname(h); err != nil {
+			t.Errorf("%v: %v", h, err)
+		}
+	}
+
+	for _, d := range []*Desc{
+		{TLS: true, Mode: Remote},
+		{TLSCert: "cert.pem", Mode: Local},
+	} {
+		if err := FromDesc(d).prepareTLS(); err == nil {
+			t.Errorf("%+v: expected error", d)
+		}
+	}
+	if err := FromDesc(&Desc{Mode: Remote}).prepareTLS(); err != nil {
+		t.Errorf("unexpected error without TLS: %v", err)
+	}
+}
@@ -0,0 +1,39 @@
+package tunnel
+
+import (
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDialServerTOS(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	tun := FromDesc(&Desc{Name: "test"})
+	c, err := tun.dialServer(l.Addr().String(), time.Second, 0xb8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	raw, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tos int
+	var serr error
+	raw.Control(func(fd uintptr) {
+		tos, serr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS)
+	})
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	if tos != 0xb8 {
+		t.Errorf("got type of service %#x, want 0xb8", tos)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build linux || darwin
+
+package tunnel
+
+import (
+	"strings"
+	"syscall"
+)
+
+// setTOS sets the type of service of packets sent over the socket, using
+// the traffic class for IPv6
+func setTOS(network string, c syscall.RawConn, tos int) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		if strings.HasSuffix(network, "6") {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS, tos)
+		} else {
+			serr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_TOS, tos)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
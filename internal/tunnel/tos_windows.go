@@ -0,0 +1,14 @@
+//go:build windows
+
+package tunnel
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setTOS is not supported, Windows ignores the type of service set by
+// applications, it is controlled by QoS policies instead
+func setTOS(string, syscall.RawConn, int) error {
+	return fmt.Errorf("not supported on Windows")
+}
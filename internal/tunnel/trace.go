@@ -0,0 +1,79 @@
+package tunnel
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+)
+
+// Tracer starts spans describing the lifecycle of a tunnel, such as
+// connecting to the server and forwarding a connection. It is shaped
+// such that an OpenTelemetry tracer is easily adapted to it.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span)
+}
+
+// Span is a unit of work started by a Tracer
+type Span interface {
+	SetAttribute(key, value string)
+	// End finishes the span, with err as its outcome if not nil
+	End(err error)
+}
+
+type noopTracer struct{}
+
+type noopSpan struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ map[string]string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+func (noopSpan) SetAttribute(string, string) {}
+
+func (noopSpan) End(error) {}
+
+// defaultTracer is used by tunnels without a Tracer of their own
+var defaultTracer atomic.Pointer[Tracer]
+
+// SetTracer makes tunnels without a Tracer of their own, such as those
+// opened by the daemon, report spans to tr. A nil tr turns tracing off.
+func SetTracer(tr Tracer) {
+	if tr == nil {
+		defaultTracer.Store(nil)
+		return
+	}
+	defaultTracer.Store(&tr)
+}
+
+func (t *Tunnel) tracer() Tracer {
+	if t.Tracer != nil {
+		return t.Tracer
+	}
+	if p := defaultTracer.Load(); p != nil {
+		return *p
+	}
+	return noopTracer{}
+}
+
+// startConnectSpan starts the span of connecting to the server
+func (t *Tunnel) startConnectSpan() (context.Context, Span) {
+	return t.tracer().Start(context.Background(), "boring.connect", map[string]string{
+		"boring.tunnel": t.Name,
+		"boring.mode":   t.Mode.String(),
+		"boring.host":   t.Host.String(),
+	})
+}
+
+// startForwardSpan starts the span of forwarding a connection from peer.
+// It is a child of the span of the connection it is forwarded over.
+func (t *Tunnel) startForwardSpan(peer net.Addr) Span {
+	ctx := context.Background()
+	if p := t.traceCtx.Load(); p != nil {
+		ctx = *p
+	}
+	_, s := t.tracer().Start(ctx, "boring.forward", map[string]string{
+		"boring.tunnel": t.Name,
+		"boring.peer":   peer.String(),
+	})
+	return s
+}
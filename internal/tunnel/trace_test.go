@@ -0,0 +1,94 @@
+package tunnel
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+type testSpan struct {
+	name  string
+	attrs map[string]string
+	err   error
+	ended bool
+}
+
+func (s *testSpan) SetAttribute(k, v string) { s.attrs[k] = v }
+func (s *testSpan) End(err error)            { s.err, s.ended = err, true }
+
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type spanKey struct{}
+
+func (tr *testTracer) Start(ctx context.Context, name string, attrs map[string]string) (context.Context, Span) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	s := &testSpan{name: name, attrs: attrs}
+	if p, ok := ctx.Value(spanKey{}).(*testSpan); ok {
+		s.attrs["parent"] = p.name
+	}
+	tr.spans = append(tr.spans, s)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func TestTracer(t *testing.T) {
+	tr := &testTracer{}
+	tun := FromDesc(&Desc{Name: "test", Host: "example.com"})
+	tun.Tracer = tr
+
+	// A failing connect ends its span with the error
+	dialErr := errors.New("no route")
+	tun.hops = []ssh_config.Hop{{HostName: "example.com", Port: 22,
+		ClientConfig: &ssh.ClientConfig{HostKeyCallback: ssh.InsecureIgnoreHostKey()}}}
+	tun.DialFunc = func(context.Context, string, string) (net.Conn, error) { return nil, dialErr }
+	err := tun.makeClient()
+	if err == nil {
+		t.Fatal("expected connect to fail")
+	}
+	// Forward spans are children of the last connect
+	ctx, span := tun.startConnectSpan()
+	span.End(nil)
+	tun.traceCtx.Store(&ctx)
+
+	fwd := tun.startForwardSpan(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234})
+	fwd.SetAttribute("boring.target", "db:5432")
+	fwd.End(nil)
+
+	if len(tr.spans) != 3 {
+		t.Fatalf("got %d spans", len(tr.spans))
+	}
+	c, f := tr.spans[0], tr.spans[2]
+	if c.name != "boring.connect" || c.attrs["boring.host"] != "example.com" || c.err != err {
+		t.Errorf("unexpected connect span %+v", c)
+	}
+	if f.name != "boring.forward" || f.attrs["boring.peer"] != "127.0.0.1:1234" ||
+		f.attrs["boring.target"] != "db:5432" || f.attrs["parent"] != "boring.connect" || !f.ended {
+		t.Errorf("unexpected forward span %+v", f)
+	}
+
+	// Without a tracer, spans are no-ops
+	if _, s := FromDesc(&Desc{}).startConnectSpan(); s != (noopSpan{}) {
+		t.Error("expected no-op span")
+	}
+
+	// Tunnels without a tracer use the default one
+	def := &testTracer{}
+	SetTracer(def)
+	defer SetTracer(nil)
+	FromDesc(&Desc{Name: "other"}).startConnectSpan()
+	if len(def.spans) != 1 || def.spans[0].attrs["boring.tunnel"] != "other" {
+		t.Errorf("unexpected spans of default tracer: %+v", def.spans)
+	}
+	tun.startConnectSpan()
+	if len(def.spans) != 1 {
+		t.Error("tunnel tracer not preferred over default")
+	}
+}
@@ -2,15 +2,23 @@ package tunnel
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/alebeck/boring/internal/audit"
+	"github.com/alebeck/boring/internal/buildinfo"
 	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/paths"
 	"github.com/alebeck/boring/internal/proxy"
 	"github.com/alebeck/boring/internal/ssh_config"
 	"golang.org/x/crypto/ssh"
@@ -20,40 +28,149 @@ const (
 	initReconnectWait = 500 * time.Millisecond
 	maxReconnectWait  = 1 * time.Minute
 	reconnectTimeout  = 15 * time.Minute
+	// Re-connect backoff is reset after the connection has been up this long
+	backoffReset = 2 * time.Minute
+	// The first keep-alive is delayed by this, in addition to the interval
+	keepAliveDelay = 5 * time.Second
 )
 
 // Desc describes a tunnel for user-facing purposes, e.g., in the config file
 // and in the TUI.
 type Desc struct {
-	Name          string      `toml:"name" json:"name"`
-	LocalAddress  StringOrInt `toml:"local" json:"local"`
-	RemoteAddress StringOrInt `toml:"remote" json:"remote"`
-	Host          string      `toml:"host" json:"host"`
-	User          string      `toml:"user" json:"user"`
-	IdentityFile  string      `toml:"identity" json:"identity"`
-	Port          StringOrInt `toml:"port" json:"port"`
-	KeepAlive     *int        `toml:"keep_alive" json:"keep_alive"`
-	Group         string      `toml:"group" json:"group"`
-	Mode          Mode        `toml:"mode" json:"mode"`
-	Status        Status      `toml:"-" json:"status"`
-	LastConn      time.Time   `toml:"-" json:"last_conn"`
+	Name                 string            `toml:"name" json:"name"`
+	LocalAddress         Addresses         `toml:"local" json:"local"`
+	RemoteAddress        StringOrInt       `toml:"remote" json:"remote"`
+	Host                 Addresses         `toml:"host" json:"host"`
+	User                 string            `toml:"user" json:"user"`
+	IdentityFile         string            `toml:"identity" json:"identity"`
+	Port                 StringOrInt       `toml:"port" json:"port"`
+	KeepAlive            *int              `toml:"keep_alive" json:"keep_alive"`
+	Group                string            `toml:"group" json:"group"`
+	Mode                 Mode              `toml:"mode" json:"mode"`
+	PinnedFingerprint    string            `toml:"fingerprint" json:"fingerprint"`
+	ProxyProtocol        ProxyProtocol     `toml:"proxy_protocol" json:"proxy_protocol"`
+	PreferredAuths       string            `toml:"preferred_authentications" json:"preferred_authentications"`
+	Password             string            `toml:"password" json:"password"`
+	PasswordFile         string            `toml:"password_file" json:"password_file"`
+	GatewayPorts         *bool             `toml:"gateway_ports" json:"gateway_ports"`
+	Proxy                string            `toml:"proxy" json:"proxy"`
+	KeyCommand           string            `toml:"key_command" json:"key_command"`
+	DownNotifyAfter      int               `toml:"down_notify_after" json:"down_notify_after"`
+	NoDelay              *bool             `toml:"no_delay" json:"no_delay"`
+	ExpectBanner         string            `toml:"expect_banner" json:"expect_banner"`
+	RebindListener       *bool             `toml:"rebind_listener" json:"rebind_listener"`
+	ClientVersion        string            `toml:"client_version" json:"client_version"`
+	BackoffReset         int               `toml:"backoff_reset" json:"backoff_reset"`
+	WaitForRemote        bool              `toml:"wait_for_remote" json:"wait_for_remote"`
+	LocalAllow           []string          `toml:"local_allow" json:"local_allow"`
+	StallWarning         int               `toml:"stall_warning" json:"stall_warning"`
+	IdleTimeout          int               `toml:"idle_timeout" json:"idle_timeout"`
+	MaxReconnectAttempts int               `toml:"max_reconnect_attempts" json:"max_reconnect_attempts"`
+	Bound                []string          `toml:"-" json:"bound,omitempty"`
+	Bastion              string            `toml:"-" json:"bastion,omitempty"`
+	Server               string            `toml:"-" json:"server,omitempty"`
+	ClientAllow          []string          `toml:"client_allow" json:"client_allow"`
+	RekeyThreshold       StringOrInt       `toml:"rekey_threshold" json:"rekey_threshold"`
+	InitialRetries       int               `toml:"initial_retries" json:"initial_retries"`
+	LogLevel             string            `toml:"log_level" json:"log_level"`
+	Tags                 []string          `toml:"tags" json:"tags"`
+	HappyEyeballs        *bool             `toml:"happy_eyeballs" json:"happy_eyeballs"`
+	Notify               bool              `toml:"notify" json:"notify"`
+	HostKeyPolicy        string            `toml:"host_key_policy" json:"host_key_policy"`
+	ReadTimeout          int               `toml:"read_timeout" json:"read_timeout"`
+	WriteTimeout         int               `toml:"write_timeout" json:"write_timeout"`
+	Schedule             string            `toml:"schedule" json:"schedule"`
+	ExitOnForwardFailure *bool             `toml:"exit_on_forward_failure" json:"exit_on_forward_failure"`
+	ShareConnection      *bool             `toml:"share_connection" json:"share_connection"`
+	IPQoS                string            `toml:"ip_qos" json:"ip_qos"`
+	HostKeys             []string          `toml:"host_keys" json:"host_keys"`
+	HostKeyAnyAddress    bool              `toml:"host_key_any_address" json:"host_key_any_address"`
+	KeepAliveDelay       *int              `toml:"keep_alive_delay" json:"keep_alive_delay"`
+	Override             bool              `toml:"override" json:"override"`
+	TLS                  bool              `toml:"tls" json:"tls"`
+	TLSCert              string            `toml:"tls_cert" json:"tls_cert"`
+	TLSKey               string            `toml:"tls_key" json:"tls_key"`
+	Extends              string            `toml:"extends" json:"extends"`
+	SSHConfigFile        string            `toml:"ssh_config" json:"ssh_config"`
+	SNIRoutes            map[string]string `toml:"sni_routes" json:"sni_routes"`
+	ForwardedFor         bool              `toml:"x_forwarded_for" json:"x_forwarded_for"`
+	AcceptErrorBackoff   *int              `toml:"accept_error_backoff" json:"accept_error_backoff"`
+	AcceptErrorLimit     *int              `toml:"accept_error_limit" json:"accept_error_limit"`
+	IdleScanInterval     int               `toml:"idle_scan_interval" json:"idle_scan_interval"`
+	ClientUIDs           []int             `toml:"client_uids" json:"client_uids"`
+	ClientGIDs           []int             `toml:"client_gids" json:"client_gids"`
+	Status               Status            `toml:"-" json:"status"`
+	LastConn             time.Time         `toml:"-" json:"last_conn"`
 }
 
 // Tunnel is a representation internal to the tunnel and daemon packages,
 // describing a tunnel that is running or about to be run.
 type Tunnel struct {
-	prepared   bool
-	hops       []ssh_config.Hop
-	Closed     chan struct{}
-	stop       chan struct{}
-	listener   net.Listener
-	wg         sync.WaitGroup
-	client     *ssh.Client
-	localAddr  *address
-	remoteAddr *address
+	prepared      bool
+	hops          []ssh_config.Hop
+	bastions      []bastion
+	hopsMu        sync.Mutex
+	bastion       atomic.Int32
+	Closed        chan struct{}
+	stop          chan struct{}
+	listener      net.Listener
+	listenerMu    sync.Mutex
+	bound         []net.Addr
+	wg            sync.WaitGroup
+	client        *ssh.Client
+	clientMu      sync.Mutex
+	rotate        chan rotation
+	localAddr     *address
+	localAddrs    []*address
+	remoteAddr    *address
+	proxyURL      *url.URL
+	proxyFromEnv  bool
+	hostKey       ssh.PublicKey
+	serverVersion string
+	bastionHost   string
+	hostKeyMu     sync.Mutex
+	paused        atomic.Bool
+	streams       map[net.Conn]*stream
+	streamsMu     sync.Mutex
+	down          downNotifier
+	bannerMatched atomic.Bool
+	backoff       time.Duration
+	clientNets    []netip.Prefix
+	bytesIn       atomic.Uint64
+	bytesOut      atomic.Uint64
+	reconnects    atomic.Uint64
+	openedAt      time.Time
+	exitOnFailure bool
+	forwardFailed atomic.Bool
+	tlsConfig     *tls.Config
+	sniRoutes     map[string]*address
+	acceptErrs    acceptErrors
+	idleConns     map[*idleConn]struct{}
+	idleMu        sync.Mutex
+	traceCtx      atomic.Pointer[context.Context]
+	// expandAddr expands tokens in addresses, if set
+	expandAddr func(string) string
+	// Signers, if set, provides signers from a custom source, tried before
+	// other keys. If SignersOnly is set, no other keys are used.
+	Signers     ssh_config.SignerProvider
+	SignersOnly bool
+	// DialFunc, if set, opens the transport connection to the first hop in
+	// place of a TCP dial, e.g., to carry SSH over WebSocket or QUIC. Proxy
+	// and type of service settings do not apply then.
+	DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+	// Tracer, if set, receives spans for connecting to the server and for
+	// forwarded connections, e.g., to export them to OpenTelemetry.
+	Tracer Tracer
 	*Desc
 }
 
+// bastion is a host the tunnel may connect to, with the hops leading to it
+type bastion struct {
+	host  string
+	hops  []ssh_config.Hop
+	share *shareSpec
+}
+
 type address struct {
 	addr, net string
 }
@@ -62,49 +179,226 @@ func FromDesc(desc *Desc) *Tunnel {
 	return &Tunnel{Desc: desc}
 }
 
+// logger returns a logger tagging messages with the tunnel name
+func (t *Tunnel) logger() *log.Logger {
+	return log.With(t.Name)
+}
+
 func (t *Tunnel) Open() (err error) {
 	if !t.prepared {
 		if err = t.prepare(); err != nil {
 			return err
 		}
 	}
+	if t.stop == nil {
+		// Fail early rather than after connecting
+		if err = t.CheckLocal(); err != nil {
+			return fmt.Errorf("%w: %v", errListen, err)
+		}
+	}
 
-	if err = t.makeClient(); err != nil {
+	if err = t.connect(); err != nil {
 		return err
 	}
-	log.Debugf("%v: connected to server", t.Name)
+	t.logger().Debugf("connected to server (%v)", t.Fingerprint())
+
+	if t.WaitForRemote && t.Mode == Local {
+		if err = t.waitForRemote(); err != nil {
+			t.client.Close()
+			return err
+		}
+	}
 
 	if err = t.makeListener(); err != nil {
 		t.client.Close()
-		return fmt.Errorf("cannot listen: %v", err)
+		return fmt.Errorf("%w: %v", errListen, err)
 	}
-	log.Debugf("%v: listening on %v", t.Name, t.listener.Addr())
+	t.logger().Debugf("listening on %v", t.listener.Addr())
 
 	if t.stop == nil {
 		t.stop = make(chan struct{})
+		t.rotate = make(chan rotation)
 		t.Closed = make(chan struct{})
+		t.openedAt = time.Now()
+		t.auditTunnel(audit.TunnelOpen)
 	}
 
 	go t.run()
 
-	log.Infof("%v: opened tunnel", t.Name)
+	t.logger().Infof("opened tunnel")
 	t.Status = Open
+	if t.paused.Load() {
+		t.Status = Paused
+	}
 	t.LastConn = time.Now()
 	return
 }
 
 func (t *Tunnel) prepare() error {
+	steps := []func() error{
+		t.applyLogLevel,
+		t.resolveProxy,
+		t.prepareBastions,
+		t.prepareAddrs,
+		t.validateLocalAllow,
+		t.parseClientAllow,
+		t.validateClientIDs,
+		t.prepareTLS,
+		t.prepareSNI,
+		t.checkForwardedFor,
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+	t.prepared = true
+	return nil
+}
+
+// prepareBastions resolves the hosts and starts with the first one
+func (t *Tunnel) prepareBastions() error {
+	bastions, err := t.resolveBastions()
+	if err != nil {
+		return err
+	}
+	t.setBastions(bastions)
+	t.bastion.Store(0)
+	return nil
+}
+
+// prepareAddrs parses the remote and local addresses
+func (t *Tunnel) prepareAddrs() error {
+	var err error
+	allowShort := t.Mode == Remote || t.Mode == RemoteSocks
+	t.remoteAddr, err = parseAddr(t.expand(string(t.RemoteAddress)), allowShort)
+	if err != nil {
+		return fmt.Errorf("remote address: %v", err)
+	}
+	if t.remoteAddr.net == "srv" && t.Mode != Local {
+		return fmt.Errorf("remote address: SRV names are only supported in local mode")
+	}
+
+	if err = t.parseLocalAddrs(!allowShort); err != nil {
+		return fmt.Errorf("local address: %v", err)
+	}
+	if err = t.applyGatewayPorts(); err != nil {
+		return fmt.Errorf("local address: %v", err)
+	}
+	return nil
+}
+
+// resolveBastions resolves the hops leading to each host, building their
+// authentication methods and host key callbacks afresh
+func (t *Tunnel) resolveBastions() ([]bastion, error) {
+	hosts := t.Host.Split()
+	if len(hosts) == 0 {
+		hosts = []string{""}
+	}
+	var bastions []bastion
+	for _, h := range hosts {
+		hops, sc, err := t.resolveHops(h)
+		if err != nil {
+			if len(hosts) > 1 {
+				return nil, fmt.Errorf("%v: %v", h, err)
+			}
+			return nil, err
+		}
+		if err = t.applyClientVersion(hops); err != nil {
+			return nil, err
+		}
+		t.recordHostKey(&hops[len(hops)-1])
+		t.expectBanner(&hops[len(hops)-1])
+		share, err := t.sharing(sc, hops)
+		if err != nil {
+			return nil, err
+		}
+		if len(bastions) == 0 {
+			// Addresses refer to the first host
+			t.expandAddr = sc.ExpandAddress
+		}
+		bastions = append(bastions, bastion{host: h, hops: hops, share: share})
+	}
+	return bastions, nil
+}
+
+// setBastions makes the tunnel connect to bastions from now on
+func (t *Tunnel) setBastions(bastions []bastion) {
+	t.hopsMu.Lock()
+	defer t.hopsMu.Unlock()
+	t.bastions = bastions
+	t.hops = bastions[0].hops
+}
+
+// currentBastions returns the hosts the tunnel connects to
+func (t *Tunnel) currentBastions() []bastion {
+	t.hopsMu.Lock()
+	defer t.hopsMu.Unlock()
+	if len(t.bastions) == 0 {
+		return []bastion{{hops: t.hops}}
+	}
+	return t.bastions
+}
+
+// resolveHops determines the series of hops leading to dest, which is an
+// ssh config alias or a destination like user@host:port. It also returns
+// the ssh config of dest.
+func (t *Tunnel) resolveHops(dest string) ([]ssh_config.Hop, *ssh_config.SSHConfig, error) {
+	// Host may specify user and port inline, these take precedence
+	host, user, port, err := ssh_config.ParseDestination(dest)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid host %q: %v", dest, err)
+	}
+	if user == "" {
+		user = t.User
+	}
+
 	// We need to pass the user as it's needed for matching Match blocks
-	sc, err := ssh_config.ParseSSHConfig(t.Host, t.User)
+	sc, err := ssh_config.ParseSSHConfigFile(host, user, paths.ReplaceTilde(t.SSHConfigFile))
 	if err != nil {
-		return fmt.Errorf("could not parse SSH config: %v", err)
+		return nil, nil, fmt.Errorf("could not parse SSH config: %v", err)
 	}
 
 	// Override values manually set by user
-	if t.User != "" {
-		sc.User = t.User
+	if err = t.overrideLogin(sc, user, port); err != nil {
+		return nil, nil, err
+	}
+	if err = t.overrideHostKeys(sc); err != nil {
+		return nil, nil, err
 	}
-	if t.Port != "" {
+	t.exitOnFailure = sc.ExitOnForwardFailure
+	if t.ExitOnForwardFailure != nil {
+		t.exitOnFailure = *t.ExitOnForwardFailure
+	}
+
+	// If host could not be resolved from ssh config, take it literally
+	if sc.HostName == "" {
+		sc.HostName = host
+	}
+
+	sc.EnsureUser()
+
+	// Infer series of hops from ssh config
+	hops, err := sc.ToHops()
+	if err == nil && t.IPQoS != "" {
+		// Only the connection to the first hop is ours to mark
+		if hops[0].TOS, err = ssh_config.ParseIPQoS(t.IPQoS); err != nil {
+			return nil, nil, err
+		}
+	}
+	return hops, sc, err
+}
+
+// overrideLogin applies the tunnel's settings for logging in to sc. user
+// and port are given inline with the host, if not empty.
+func (t *Tunnel) overrideLogin(sc *ssh_config.SSHConfig, user string, port int) error {
+	var err error
+	if user != "" {
+		sc.User = user
+	}
+	if port != 0 {
+		sc.Port = port
+	} else if t.Port != "" {
 		if sc.Port, err = strconv.Atoi(t.Port.String()); err != nil {
 			return fmt.Errorf("invalid port %q", t.Port)
 		}
@@ -112,61 +406,344 @@ func (t *Tunnel) prepare() error {
 	if t.IdentityFile != "" {
 		sc.IdentityFiles = []string{t.IdentityFile}
 	}
+	if t.PreferredAuths != "" {
+		sc.PreferredAuths = strings.Split(t.PreferredAuths, ",")
+	}
+	if t.RekeyThreshold != "" {
+		if sc.RekeyThreshold, err = ssh_config.ParseRekeyLimit(t.RekeyThreshold.String()); err != nil {
+			return err
+		}
+	}
+	sc.Password = t.Password
+	sc.KeyCommand = t.KeyCommand
+	sc.Signers, sc.SignersOnly = t.Signers, t.SignersOnly
+	return nil
+}
 
-	// If t.Host could not be resolved from ssh config, take it literally
-	if sc.HostName == "" {
-		sc.HostName = t.Host
+// overrideHostKeys applies the tunnel's settings for verifying host keys
+// to sc
+func (t *Tunnel) overrideHostKeys(sc *ssh_config.SSHConfig) error {
+	if t.PinnedFingerprint != "" {
+		if !strings.HasPrefix(t.PinnedFingerprint, "SHA256:") {
+			return fmt.Errorf("invalid fingerprint %q, expected SHA256:<hash>", t.PinnedFingerprint)
+		}
+		sc.PinnedFingerprint = t.PinnedFingerprint
+	}
+	for _, fp := range t.HostKeys {
+		if !strings.HasPrefix(fp, "SHA256:") {
+			return fmt.Errorf("invalid host key %q, expected SHA256:<hash>", fp)
+		}
 	}
+	sc.AcceptedHostKeys = t.HostKeys
+	sc.HostKeyAnyAddress = t.HostKeyAnyAddress
+	if err := sc.SetHostKeyPolicy(t.HostKeyPolicy); err != nil {
+		return err
+	}
+	if err := sc.CheckNewHostsFile(); err != nil {
+		t.logger().Warningf("cannot add new host keys to %v: %v", sc.NewHostsFile, err)
+	}
+	return nil
+}
 
-	sc.EnsureUser()
+// expand expands tokens like %h in the address addr
+func (t *Tunnel) expand(addr string) string {
+	if t.expandAddr == nil {
+		return addr
+	}
+	return t.expandAddr(addr)
+}
 
-	// Infer series of hops from ssh config
-	if t.hops, err = sc.ToHops(); err != nil {
+// applyLogLevel makes the tunnel's messages subject to LogLevel rather
+// than the global level, if set.
+func (t *Tunnel) applyLogLevel() error {
+	if t.LogLevel == "" {
+		log.SetLevel(t.Name, nil)
+		return nil
+	}
+	l, err := log.ParseLevel(t.LogLevel)
+	if err != nil {
 		return err
 	}
+	log.SetLevel(t.Name, &l)
+	return nil
+}
 
-	allowShort := t.Mode == Remote || t.Mode == RemoteSocks
-	t.remoteAddr, err = parseAddr(string(t.RemoteAddress), allowShort)
-	if err != nil {
-		return fmt.Errorf("remote address: %v", err)
+// applyClientVersion sets the version string sent to all hops, which
+// defaults to one identifying boring.
+func (t *Tunnel) applyClientVersion(hops []ssh_config.Hop) error {
+	v := t.ClientVersion
+	if v == "" {
+		v = defaultClientVersion()
+	} else if err := checkClientVersion(v); err != nil {
+		return fmt.Errorf("invalid client_version %q: %v", v, err)
+	}
+	for i := range hops {
+		conf := *hops[i].ClientConfig
+		conf.ClientVersion = v
+		hops[i].ClientConfig = &conf
 	}
+	return nil
+}
 
-	t.localAddr, err = parseAddr(string(t.LocalAddress), !allowShort)
-	if err != nil {
-		return fmt.Errorf("local address: %v", err)
+func defaultClientVersion() string {
+	if buildinfo.Version == "" {
+		return "SSH-2.0-boring"
 	}
+	// Software version must not contain whitespace or minus signs
+	v := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return '_'
+		}
+		return r
+	}, buildinfo.Version)
+	return "SSH-2.0-boring_" + v
+}
 
-	t.prepared = true
+// checkClientVersion validates v according to RFC 4253, section 4.2
+func checkClientVersion(v string) error {
+	if !strings.HasPrefix(v, "SSH-2.0-") || len(v) == len("SSH-2.0-") {
+		return fmt.Errorf("must start with \"SSH-2.0-\" followed by a software version")
+	}
+	if len(v) > 253 {
+		return fmt.Errorf("too long")
+	}
+	for _, r := range v {
+		if r < 0x20 || r > 0x7e {
+			return fmt.Errorf("must only contain printable ASCII characters")
+		}
+	}
+	return nil
+}
+
+// recordHostKey wraps the host key callback of the hop to remember the
+// host key presented by the server, once it has been verified.
+func (t *Tunnel) recordHostKey(h *ssh_config.Hop) {
+	conf := *h.ClientConfig
+	verify := conf.HostKeyCallback
+	conf.HostKeyCallback = func(host string, remote net.Addr, key ssh.PublicKey) error {
+		if err := verify(host, remote, key); err != nil {
+			return err
+		}
+		t.hostKeyMu.Lock()
+		t.hostKey = key
+		t.hostKeyMu.Unlock()
+		return nil
+	}
+	h.ClientConfig = &conf
+}
 
+// expectBanner makes connecting to the hop fail unless the server's banner
+// contains ExpectBanner. This detects being routed to the wrong server, e.g.,
+// by a transparent proxy, before authenticating to it.
+func (t *Tunnel) expectBanner(h *ssh_config.Hop) {
+	if t.ExpectBanner == "" {
+		return
+	}
+	conf := *h.ClientConfig
+	prev := conf.BannerCallback
+	conf.BannerCallback = func(msg string) error {
+		if prev != nil {
+			if err := prev(msg); err != nil {
+				return err
+			}
+		}
+		if !strings.Contains(msg, t.ExpectBanner) {
+			return fmt.Errorf("server banner does not contain %q", t.ExpectBanner)
+		}
+		t.bannerMatched.Store(true)
+		return nil
+	}
+	h.ClientConfig = &conf
+}
+
+// ServerHostKey returns the host key presented by the server during the
+// most recent handshake, or nil if the tunnel has not connected yet.
+func (t *Tunnel) ServerHostKey() ssh.PublicKey {
+	t.hostKeyMu.Lock()
+	defer t.hostKeyMu.Unlock()
+	return t.hostKey
+}
+
+// Fingerprint returns the SHA256 fingerprint of the server's host key, or
+// an empty string if the tunnel has not connected yet.
+func (t *Tunnel) Fingerprint() string {
+	k := t.ServerHostKey()
+	if k == nil {
+		return ""
+	}
+	return ssh_config.FingerprintOf(k)
+}
+
+// applyGatewayPorts overrides the bind host of local listeners if
+// GatewayPorts is set: all interfaces if enabled, loopback otherwise.
+func (t *Tunnel) applyGatewayPorts() error {
+	if t.GatewayPorts == nil || t.Mode == Remote || t.Mode == RemoteSocks {
+		return nil
+	}
+	host := "localhost"
+	if *t.GatewayPorts {
+		host = ""
+	}
+	// Addresses differing only in the host collapse into one
+	seen := make(map[string]bool, len(t.localAddrs))
+	addrs := t.localAddrs[:0]
+	for _, a := range t.localAddrs {
+		if a.net == "tcp" {
+			_, port, err := net.SplitHostPort(a.addr)
+			if err != nil {
+				return err
+			}
+			a.addr = net.JoinHostPort(host, port)
+			if *t.GatewayPorts && len(seen) == 0 {
+				t.logger().Warningf("gateway ports enabled, listening on all interfaces")
+			}
+		}
+		if !seen[a.addr] {
+			seen[a.addr] = true
+			addrs = append(addrs, a)
+		}
+	}
+	t.localAddrs = addrs
+	t.localAddr = addrs[0]
 	return nil
 }
 
+// connect creates the client. When opening the tunnel initially, failures
+// are retried InitialRetries times with short backoff, which bridges
+// transient network hiccups, e.g., after waking from sleep. Re-connects are
+// retried by reconnectLoop instead.
+func (t *Tunnel) connect() error {
+	retries := t.InitialRetries
+	if t.stop != nil {
+		retries = 0
+	}
+	wait := initReconnectWait
+	for attempt := 1; ; attempt++ {
+		err := t.makeClient()
+		if err == nil || attempt > retries {
+			return err
+		}
+		t.logger().Warningf("could not connect: %v. Retrying in %v (%d/%d)...",
+			err, wait, attempt, retries)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
 func (t *Tunnel) makeClient() error {
-	if len(t.hops) == 0 {
-		return fmt.Errorf("no connections specified")
+	ctx, span := t.startConnectSpan()
+	c, err := t.dialHops()
+	if err != nil {
+		span.End(err)
+		return err
+	}
+	span.SetAttribute("boring.server", c.RemoteAddr().String())
+	span.End(nil)
+	t.traceCtx.Store(&ctx)
+	t.setClient(c)
+	return nil
+}
+
+// dialHops connects to the server and returns its client. If multiple
+// hosts are given, they are tried in order, starting with the one that
+// worked last.
+func (t *Tunnel) dialHops() (*ssh.Client, error) {
+	c, wait, err := t.dialBastions(t.currentBastions())
+	if err != nil {
+		return nil, err
+	}
+	if wait != nil {
+		// Wait for all wrapped clients to close in case of tunnel closing or reconnection
+		t.goWait(wait)
+	}
+	return c, nil
+}
+
+// dialBastions connects to the first reachable of bastions. Unless the
+// connection is shared, it also returns a function waiting for the clients
+// of all hops to close.
+func (t *Tunnel) dialBastions(bastions []bastion) (*ssh.Client, func(), error) {
+	if len(bastions) == 1 {
+		return t.dialBastion(bastions[0])
+	}
+	start := int(t.bastion.Load())
+	errs := make([]string, 0, len(bastions))
+	for i := range bastions {
+		k := (start + i) % len(bastions)
+		b := bastions[k]
+		c, wait, err := t.dialBastion(b)
+		if err == nil {
+			if k != start {
+				t.logger().Infof("failed over to host %v", b.host)
+			}
+			t.bastion.Store(int32(k))
+			t.hostKeyMu.Lock()
+			t.bastionHost = b.host
+			t.hostKeyMu.Unlock()
+			return c, wait, nil
+		}
+		select {
+		case <-t.stop:
+			return nil, nil, err
+		default:
+		}
+		t.logger().Warningf("host %v unreachable: %v", b.host, err)
+		errs = append(errs, err.Error())
+	}
+	return nil, nil, fmt.Errorf("no host reachable: %v", strings.Join(errs, "; "))
+}
+
+// BastionHost returns the host the tunnel is connected through if it fails
+// over between several, or an empty string otherwise.
+func (t *Tunnel) BastionHost() string {
+	t.hostKeyMu.Lock()
+	defer t.hostKeyMu.Unlock()
+	return t.bastionHost
+}
+
+// dialBastion connects to b, sharing the connection if configured
+func (t *Tunnel) dialBastion(b bastion) (*ssh.Client, func(), error) {
+	if b.share != nil {
+		c, err := t.dialShared(b)
+		return c, nil, err
+	}
+	return t.dialChain(b.hops)
+}
+
+// dialChain connects through all hops and returns the client of the last
+// one, along with a function waiting for the clients of all hops to close.
+func (t *Tunnel) dialChain(hops []ssh_config.Hop) (*ssh.Client, func(), error) {
+	if len(hops) == 0 {
+		return nil, nil, fmt.Errorf("no connections specified")
+	}
+
+	if err := t.throttle(hops[0].Addr()); err != nil {
+		return nil, nil, err
 	}
 
 	var c *ssh.Client
 	var wg sync.WaitGroup
 
+	t.bannerMatched.Store(false)
+
 	// Connect through all jump hosts
-	for _, j := range t.hops {
-		addr := fmt.Sprintf("%v:%v", j.HostName, j.Port)
-		n, err := wrapClient(c, addr, j.ClientConfig)
+	for _, j := range hops {
+		addr := j.Addr()
+		n, err := t.wrapClient(c, j)
 		if err != nil {
 			safeClose(c)
 			// Wait for all connections established until here to close
 			wg.Wait()
-			return fmt.Errorf("could not connect to host %v: %v", addr, err)
+			return nil, nil, fmt.Errorf("could not connect to host %v: %v", addr, err)
 		}
-		log.Debugf("%v: connected to host %v (client %p)", t.Name, j.HostName, n)
+		t.logger().Debugf("connected to host %v (client %p)", j.HostName, n)
 
 		// Add new client to wait group
 		wg.Add(1)
 		go func(n, c *ssh.Client) {
 			defer wg.Done()
 			n.Wait()
-			log.Debugf("%v: closed client %p to %v", t.Name, n, n.RemoteAddr())
+			t.logger().Debugf("closed client %p to %v", n, n.RemoteAddr())
 			// Close previous client when new one closes, this propagates
 			safeClose(c)
 		}(n, c)
@@ -174,76 +751,175 @@ func (t *Tunnel) makeClient() error {
 		c = n
 	}
 
-	// Wait for all wrapped clients to close in case of tunnel closing or reconnection
-	go t.waitFor(func() { wg.Wait() })
-
-	t.client = c
-	return nil
+	if t.ExpectBanner != "" && !t.bannerMatched.Load() {
+		c.Close()
+		wg.Wait()
+		return nil, nil, fmt.Errorf("server sent no banner, expected one containing %q", t.ExpectBanner)
+	}
+	t.recordServerVersion(c)
+	return c, wg.Wait, nil
 }
 
-func wrapClient(old *ssh.Client, addr string, conf *ssh.ClientConfig) (*ssh.Client, error) {
-	if old == nil {
-		return ssh.Dial("tcp", addr, conf)
+// recordServerVersion remembers the version string sent by the server c is
+// connected to, logging it when it is first seen or has changed.
+func (t *Tunnel) recordServerVersion(c *ssh.Client) {
+	v := string(c.ServerVersion())
+	t.hostKeyMu.Lock()
+	prev := t.serverVersion
+	t.serverVersion = v
+	t.hostKeyMu.Unlock()
+	if v != prev {
+		t.logger().Infof("server version %v", v)
 	}
+}
 
-	conn, err := old.Dial("tcp", addr)
+// ServerVersion returns the version string sent by the server during the
+// most recent handshake, e.g., "SSH-2.0-OpenSSH_9.6", or an empty string if
+// the tunnel has not connected yet.
+func (t *Tunnel) ServerVersion() string {
+	t.hostKeyMu.Lock()
+	defer t.hostKeyMu.Unlock()
+	return t.serverVersion
+}
+
+func (t *Tunnel) wrapClient(old *ssh.Client, h ssh_config.Hop) (*ssh.Client, error) {
+	addr, conf := h.Addr(), h.ClientConfig
+	if h.Auth != nil {
+		auth, err := h.Auth()
+		if err != nil {
+			return nil, err
+		}
+		fresh := *conf
+		fresh.Auth = auth
+		conf = &fresh
+	}
+	dial := func() (net.Conn, error) {
+		if old == nil {
+			return t.dialServer(addr, conf.Timeout, h.TOS)
+		}
+		return old.Dial("tcp", addr)
+	}
+	conn, err := dial()
 	if err != nil {
 		return nil, err
 	}
 
 	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, conf)
+	if ssh_config.IsTooManyAuthFailures(err) && h.PrunedAuth != nil {
+		// Agent keys may have used up the server's MaxAuthTries before
+		// the right key was offered, retry without them
+		auth, perr := h.PrunedAuth()
+		if perr != nil {
+			return nil, err
+		}
+		t.logger().Warningf("%v: too many authentication failures, retrying with configured identities only. "+
+			"Set IdentitiesOnly yes for the host in your SSH config to avoid this.", addr)
+		pruned := *conf
+		pruned.Auth = auth
+		if conn, err = dial(); err != nil {
+			return nil, err
+		}
+		ncc, chans, reqs, err = ssh.NewClientConn(conn, addr, &pruned)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if a, ok := ncc.(ssh.AlgorithmsConnMetadata); ok {
+		t.logger().Debugf("negotiated with %v: %v", addr, describeAlgorithms(a.Algorithms()))
+	}
 
 	return ssh.NewClient(ncc, chans, reqs), nil
 }
 
+// describeAlgorithms formats the algorithms negotiated in a handshake.
+// Ciphers and MACs are given as client to server, then server to client.
+func describeAlgorithms(a ssh.NegotiatedAlgorithms) string {
+	mac := func(d ssh.DirectionAlgorithms) string {
+		if d.MAC == "" {
+			// AEAD ciphers authenticate on their own
+			return "implicit"
+		}
+		return d.MAC
+	}
+	return fmt.Sprintf("kex %v, host key %v, cipher %v/%v, mac %v/%v",
+		a.KeyExchange, a.HostKey, a.Write.Cipher, a.Read.Cipher,
+		mac(a.Write), mac(a.Read))
+}
+
 func (t *Tunnel) makeListener() (err error) {
 	if t.Mode == Remote || t.Mode == RemoteSocks {
 		t.listener, err = t.client.Listen(t.remoteAddr.net, t.remoteAddr.addr)
 	} else {
-		t.listener, err = net.Listen(t.localAddr.net, t.localAddr.addr)
+		t.listener, err = listenAll(t.localAddrs)
+	}
+	if err == nil {
+		t.listenerMu.Lock()
+		t.setBound(t.listener)
+		t.listenerMu.Unlock()
+		t.listener = t.secure(t.listener)
 	}
 	return
 }
 
+// dial connects to addr from the forwarding side. Through SSH, host names
+// are passed on unresolved, so the server resolves them in its own context.
 func (t *Tunnel) dial(network, addr string) (net.Conn, error) {
 	if t.Mode == Remote || t.Mode == RemoteSocks {
 		return net.Dial(network, addr)
 	}
-	return t.client.Dial(network, addr)
+	return t.currentClient().Dial(network, addr)
 }
 
 func (t *Tunnel) run() {
 	disconn := make(chan struct{})
-	go func() {
-		t.client.Wait()
-		close(disconn)
-	}()
+	closed := clientClosed(t.client)
 
-	go t.waitFor(func() { t.keepAlive(disconn) })
-	go t.waitFor(func() { t.handleConns() })
+	t.goWait(func() { t.keepAlive(disconn) })
+	t.goWait(func() { t.resetBackoff(disconn) })
+	t.goWait(func() { t.handleConns(disconn) })
+	if t.IdleTimeout > 0 {
+		t.goWait(func() { t.reaper(disconn) })
+	}
 
 	stopped := false
-	select {
-	case <-t.stop:
-		log.Infof("%v: received stop signal", t.Name)
-		stopped = true
-		t.client.Close()
-	case <-disconn:
+loop:
+	for {
+		select {
+		case <-t.stop:
+			t.logger().Infof("received stop signal")
+			stopped = true
+			t.currentClient().Close()
+			break loop
+		case <-closed:
+			t.markDown()
+			break loop
+		case r := <-t.rotate:
+			// New connections use the new client from now on, the old
+			// one is closed once its connections are done
+			t.goWait(r.wait)
+			closed = clientClosed(r.client)
+			old, pending := t.setClient(r.client), t.activeStreams()
+			t.goWait(func() { t.drain(old, pending, disconn) })
+		}
 	}
+	close(disconn)
+	t.listenerMu.Lock()
 	t.listener.Close()
+	t.listenerMu.Unlock()
 	t.wg.Wait()
 	if !stopped {
-		if err := t.reconnectLoop(); err != nil {
-			log.Errorf("%v: could not re-connect: %v", t.Name, err)
+		err := t.reconnectLoop()
+		t.markUp(err == nil)
+		if err != nil {
+			t.logger().Errorf("could not re-connect: %v", err)
 		} else {
 			// Successfully re-connected
+			t.reconnects.Add(1)
 			return
 		}
 	}
 	t.Status = Closed
+	t.auditTunnel(audit.TunnelClose)
 	close(t.Closed)
 }
 
@@ -252,59 +928,139 @@ func (t *Tunnel) keepAlive(cancel chan struct{}) {
 	interv := *t.KeepAlive
 
 	if interv == 0 {
-		log.Infof("%v: disabling keep-alives since set to 0", t.Name)
+		t.logger().Infof("disabling keep-alives since set to 0")
 		return
 	}
 
-	for {
+	// Give the connection time to settle before the first one
+	wait := t.keepAliveDelay() + time.Duration(interv)*time.Second
+	for ; ; wait = time.Duration(interv) * time.Second {
 		select {
 		case <-cancel:
 			return
-		case <-time.After(time.Duration(interv) * time.Second):
-			_, _, err := t.client.SendRequest("keepalive@golang.org", true, nil)
+		case <-time.After(wait):
+			c := t.currentClient()
+			_, _, err := c.SendRequest("keepalive@golang.org", true, nil)
 			if err != nil {
-				log.Errorf("%v: error sending keepalive: %v", t.Name, err)
+				t.logger().Errorf("error sending keepalive: %v", err)
 				// Close the client, this triggers the reconnection logic
-				t.client.Close()
+				c.Close()
 				return
 			}
-			log.Debugf("%v: sent keep-alive", t.Name)
+			t.logger().Debugf("sent keep-alive")
 		}
 	}
 }
 
-func (t *Tunnel) handleConns() {
-	defer t.listener.Close()
-	defer t.client.Close()
+func (t *Tunnel) keepAliveDelay() time.Duration {
+	if t.KeepAliveDelay != nil {
+		return time.Duration(*t.KeepAliveDelay) * time.Second
+	}
+	return keepAliveDelay
+}
+
+// resetBackoff resets the re-connect wait time once the connection has
+// been up for BackoffReset seconds.
+func (t *Tunnel) resetBackoff(cancel chan struct{}) {
+	after := backoffReset
+	if t.BackoffReset > 0 {
+		after = time.Duration(t.BackoffReset) * time.Second
+	}
+	select {
+	case <-cancel:
+	case <-time.After(after):
+		if t.backoff > initReconnectWait {
+			t.logger().Debugf("connection stable, resetting re-connect backoff")
+		}
+		t.backoff = 0
+	}
+}
+
+func (t *Tunnel) handleConns(disconn <-chan struct{}) {
+	t.acceptErrs = acceptErrors{}
+	defer func() { t.listener.Close() }()
+	defer func() { t.currentClient().Close() }()
 	if t.Mode == Local || t.Mode == Remote {
-		t.handleForward()
+		t.handleForward(disconn)
 		return
 	}
-	t.handleSocks()
+	t.handleSocks(disconn)
 }
 
-func (t *Tunnel) handleForward() {
+func (t *Tunnel) handleForward(disconn <-chan struct{}) {
 	for {
 		conn1, err := t.listener.Accept()
 		if err != nil {
-			log.Errorf("%v: could not accept: %v", t.Name, err)
+			if t.acceptFailed(err, disconn) {
+				continue
+			}
 			return
 		}
-		go t.waitFor(func() {
+		s := t.admit(conn1)
+		if s == nil {
+			continue
+		}
+		t.setNoDelay(conn1)
+		t.goWait(func() {
+			var err error
+			span := t.startForwardSpan(conn1.RemoteAddr())
+			defer func() { span.End(err) }()
+			defer t.release(conn1)
+			if !t.handshake(conn1) {
+				err = errors.New("handshake failed")
+				return
+			}
 			addr := t.remoteAddr
 			if t.Mode == Remote || t.Mode == RemoteSocks {
 				addr = t.localAddr
 			}
-			conn2, err := t.dial(addr.net, addr.addr)
+			src := conn1
+			if t.sniRoutes != nil {
+				src, addr = t.route(conn1)
+			}
+			if t.ForwardedFor {
+				src = forwardedFor(src)
+			}
+			t.setTarget(s, addr.addr)
+			span.SetAttribute("boring.target", addr.addr)
+			conn2, err := t.dialTarget(addr)
 			if err != nil {
-				log.Errorf("%v: could not dial: %v", t.Name, err)
+				t.logger().Errorf("could not dial: %v", err)
 				return
 			}
-			tunnel(conn1, conn2)
+			t.setNoDelay(conn2)
+			if t.ProxyProtocol != ProxyNone {
+				h := proxyHeader(t.ProxyProtocol, conn1.RemoteAddr(), conn1.LocalAddr())
+				if _, err = conn2.Write(h); err != nil {
+					t.logger().Errorf("could not send PROXY header: %v", err)
+					conn1.Close()
+					conn2.Close()
+					return
+				}
+			}
+			src, conn2 = t.withPairedDeadlines(src, conn2)
+			tunnel(t.watchStalls(t.reapIdle(t.count(t.audit(src, addr.addr), s))),
+				t.watchStalls(conn2))
 		})
 	}
 }
 
+// setNoDelay disables Nagle's algorithm on TCP connections on our side,
+// unless turned off for the tunnel. Other connections are left alone.
+func (t *Tunnel) setNoDelay(c net.Conn) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	noDelay := t.NoDelay == nil || *t.NoDelay
+	if err := tc.SetNoDelay(noDelay); err != nil {
+		t.logger().Debugf("could not set TCP_NODELAY: %v", err)
+	}
+}
+
 func tunnel(c1, c2 net.Conn) {
 	defer c1.Close()
 	defer c2.Close()
@@ -323,47 +1079,81 @@ func tunnel(c1, c2 net.Conn) {
 	<-done
 }
 
-func (t *Tunnel) handleSocks() {
-	serv := &proxy.Server{
-		Dialer: func(ctx context.Context, netw, addr string) (net.Conn, error) {
-			return t.dial(netw, addr)
-		},
+func (t *Tunnel) handleSocks(disconn <-chan struct{}) {
+	dial := func(ctx context.Context, netw, addr string) (net.Conn, error) {
+		if err := t.checkLocalAllow(addr); err != nil {
+			return nil, err
+		}
+		c, err := t.dial(netw, addr)
+		if err != nil {
+			return nil, err
+		}
+		t.setNoDelay(c)
+		return t.watchStalls(t.withDeadlines(c)), nil
 	}
 	for {
 		conn, err := t.listener.Accept()
 		if err != nil {
-			log.Errorf("%v: could not accept: %v", t.Name, err)
+			if t.acceptFailed(err, disconn) {
+				continue
+			}
 			return
 		}
-		go t.waitFor(func() { serv.ServeConn(conn) })
+		s := t.admit(conn)
+		if s == nil {
+			continue
+		}
+		t.setNoDelay(conn)
+		span := t.startForwardSpan(conn.RemoteAddr())
+		serv := &proxy.Server{Dialer: t.auditDial(t.targetDial(dial, s, span), conn.RemoteAddr())}
+		t.goWait(func() {
+			defer t.release(conn)
+			span.End(serv.ServeConn(t.watchStalls(t.reapIdle(t.count(t.withDeadlines(conn), s)))))
+		})
 	}
 }
 
 func (t *Tunnel) reconnectLoop() error {
+	if t.forwardFailed.Load() {
+		return fmt.Errorf("forward failed and exit_on_forward_failure is set")
+	}
+	if t.acceptErrs.gaveUp {
+		return fmt.Errorf("listener keeps failing, see accept_error_limit")
+	}
 	t.Status = Reconn
 	timeout := time.After(reconnectTimeout)
 	wait := time.NewTimer(2 * time.Millisecond) // First time try (essent.) immediately
-	waitTime := initReconnectWait
+	if t.backoff == 0 {
+		t.backoff = initReconnectWait
+	}
 
-	for {
+	for attempts := 1; ; attempts++ {
 		select {
 		case <-timeout:
 			return fmt.Errorf("re-connect timeout")
 		case <-t.stop:
 			return fmt.Errorf("re-connect interrupted by stop signal")
 		case <-wait.C:
-			log.Infof("%v: try re-connect...", t.Name)
+			// Only report details once the outage has been reported
+			l := t.logger()
+			infof, errorf := l.Debugf, l.Debugf
+			if t.outageNotified() {
+				infof, errorf = l.Infof, l.Errorf
+			}
+			infof("try re-connect...")
 			err := t.Open()
 			if err == nil {
 				return nil
 			}
-			log.Errorf("%v: could not re-connect: %v. Retrying in %v...",
-				t.Name, err, waitTime)
-			wait.Reset(waitTime)
-			waitTime *= 2
-			if waitTime > maxReconnectWait {
-				waitTime = maxReconnectWait
+			if t.exitOnFailure && errors.Is(err, errListen) {
+				return fmt.Errorf("%v, exit_on_forward_failure is set", err)
+			}
+			if t.MaxReconnectAttempts > 0 && attempts >= t.MaxReconnectAttempts {
+				return fmt.Errorf("gave up after %d attempts, last error: %v", attempts, err)
 			}
+			errorf("could not re-connect: %v. Retrying in %v...", err, t.backoff)
+			wait.Reset(t.backoff)
+			t.backoff = min(2*t.backoff, maxReconnectWait)
 		}
 	}
 }
@@ -376,15 +1166,36 @@ func (t *Tunnel) Close() error {
 	return nil
 }
 
-// Logic registered with waitFor will be waited for upon tunnel closing
-// and reconnecting.
-func (t *Tunnel) waitFor(f func()) {
+// Abort drops the connections of a tunnel being closed and its SSH
+// connection, for it to finish closing promptly.
+func (t *Tunnel) Abort() {
+	t.dropStreams()
+	if c := t.currentClient(); c != nil {
+		c.Close()
+	}
+}
+
+// goWait runs f in a new goroutine, which is waited for upon tunnel
+// closing and reconnecting. It is registered before the goroutine starts,
+// such that a concurrent wait cannot miss it.
+func (t *Tunnel) goWait(f func()) {
 	t.wg.Add(1)
-	defer t.wg.Done()
-	f()
+	go func() {
+		defer t.wg.Done()
+		f()
+	}()
 }
 
 func parseAddr(addr string, allowShort bool) (*address, error) {
+	if name, ok := strings.CutPrefix(addr, srvScheme); ok {
+		if name == "" {
+			return nil, fmt.Errorf("missing SRV name")
+		}
+		return &address{name, "srv"}, nil
+	}
+	if name, ok := strings.CutPrefix(addr, systemdScheme); ok {
+		return &address{name, "systemd"}, nil
+	}
 	if _, err := strconv.Atoi(addr); err == nil {
 		// addr is a tcp port number
 		if !allowShort {
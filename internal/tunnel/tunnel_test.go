@@ -0,0 +1,1467 @@
+package tunnel
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/alebeck/boring/internal/log"
+	"github.com/alebeck/boring/internal/proxy"
+	"github.com/alebeck/boring/internal/ssh_config"
+	"golang.org/x/crypto/ssh"
+)
+
+func init() {
+	log.Init(io.Discard, false, false)
+}
+
+func testKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+func TestRecordHostKey(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test"})
+	if tun.ServerHostKey() != nil || tun.Fingerprint() != "" {
+		t.Fatal("expected no host key before connecting")
+	}
+
+	reject := errors.New("rejected")
+	accept := true
+	h := ssh_config.Hop{ClientConfig: &ssh.ClientConfig{
+		HostKeyCallback: func(string, net.Addr, ssh.PublicKey) error {
+			if !accept {
+				return reject
+			}
+			return nil
+		},
+	}}
+	tun.recordHostKey(&h)
+
+	k := testKey(t)
+	if err := h.HostKeyCallback("host:22", nil, k); err != nil {
+		t.Fatal(err)
+	}
+	if tun.Fingerprint() != ssh.FingerprintSHA256(k) {
+		t.Errorf("got fingerprint %q, want %q", tun.Fingerprint(), ssh.FingerprintSHA256(k))
+	}
+
+	// Rejected keys must not be recorded
+	accept = false
+	if err := h.HostKeyCallback("host:22", nil, testKey(t)); err != reject {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tun.Fingerprint() != ssh.FingerprintSHA256(k) {
+		t.Error("rejected key was recorded")
+	}
+}
+
+func TestApplyGatewayPorts(t *testing.T) {
+	on, off := true, false
+	cases := []struct {
+		gw   *bool
+		mode Mode
+		addr string
+		want string
+	}{
+		{nil, Local, "0.0.0.0:9000", "0.0.0.0:9000"},
+		{&on, Local, "localhost:9000", ":9000"},
+		{&off, Local, "0.0.0.0:9000", "localhost:9000"},
+		{&on, Socks, "127.0.0.1:9000", ":9000"},
+		{&on, Remote, "localhost:9000", "localhost:9000"},
+	}
+	for _, c := range cases {
+		tun := FromDesc(&Desc{Name: "test", Mode: c.mode, GatewayPorts: c.gw})
+		tun.localAddrs = []*address{{c.addr, "tcp"}}
+		tun.localAddr = tun.localAddrs[0]
+		if err := tun.applyGatewayPorts(); err != nil {
+			t.Fatal(err)
+		}
+		if tun.localAddr.addr != c.want {
+			t.Errorf("%v: got %q, want %q", c.addr, tun.localAddr.addr, c.want)
+		}
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", Status: Open})
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	if tun.admit(c1) == nil {
+		t.Fatal("connection rejected while not paused")
+	}
+
+	if err := tun.Pause(true); err != nil {
+		t.Fatal(err)
+	}
+	if tun.Status != Paused {
+		t.Errorf("got status %v, want paused", tun.Status)
+	}
+	if err := tun.Pause(false); err == nil {
+		t.Error("expected error when pausing twice")
+	}
+	// Established connection was dropped
+	if _, err := c2.Read(make([]byte, 1)); err == nil {
+		t.Error("expected dropped connection to be closed")
+	}
+
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	if tun.admit(c3) != nil {
+		t.Error("connection admitted while paused")
+	}
+
+	if err := tun.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if tun.Status != Open {
+		t.Errorf("got status %v, want open", tun.Status)
+	}
+	if err := tun.Resume(); err == nil {
+		t.Error("expected error when resuming an unpaused tunnel")
+	}
+}
+
+func TestClientVersion(t *testing.T) {
+	hops := func() []ssh_config.Hop {
+		return []ssh_config.Hop{
+			{ClientConfig: &ssh.ClientConfig{}},
+			{ClientConfig: &ssh.ClientConfig{}},
+		}
+	}
+
+	tun := FromDesc(&Desc{Name: "test"})
+	tun.hops = hops()
+	if err := tun.applyClientVersion(tun.hops); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range tun.hops {
+		if !strings.HasPrefix(h.ClientConfig.ClientVersion, "SSH-2.0-boring") {
+			t.Errorf("unexpected default version %q", h.ClientConfig.ClientVersion)
+		}
+	}
+
+	tun = FromDesc(&Desc{Name: "test", ClientVersion: "SSH-2.0-audit_1.0 ops"})
+	tun.hops = hops()
+	if err := tun.applyClientVersion(tun.hops); err != nil {
+		t.Fatal(err)
+	}
+	if v := tun.hops[1].ClientConfig.ClientVersion; v != "SSH-2.0-audit_1.0 ops" {
+		t.Errorf("got version %q", v)
+	}
+
+	for _, v := range []string{"boring", "SSH-1.99-boring", "SSH-2.0-", "SSH-2.0-a\r\nb"} {
+		tun = FromDesc(&Desc{Name: "test", ClientVersion: v})
+		tun.hops = hops()
+		if err := tun.applyClientVersion(tun.hops); err == nil {
+			t.Errorf("%q: expected error", v)
+		}
+	}
+}
+
+func TestResetBackoff(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", BackoffReset: 1})
+	tun.backoff = maxReconnectWait
+
+	// Connection lost before it became stable
+	cancel := make(chan struct{})
+	close(cancel)
+	tun.resetBackoff(cancel)
+	if tun.backoff != maxReconnectWait {
+		t.Errorf("backoff reset although connection was not stable")
+	}
+
+	tun.resetBackoff(make(chan struct{}))
+	if tun.backoff != 0 {
+		t.Errorf("backoff not reset, got %v", tun.backoff)
+	}
+}
+
+func TestRebindListener(t *testing.T) {
+	old, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun := FromDesc(&Desc{Name: "test", Mode: Local})
+	tun.localAddrs = []*address{{addr: old.Addr().String(), net: "tcp"}}
+	tun.listener = old
+	tun.stop = make(chan struct{})
+
+	if !tun.acceptFailed(errors.New("too many open files"), nil) {
+		t.Fatal("expected listener to be rebound")
+	}
+	defer tun.listener.Close()
+	if tun.listener == old {
+		t.Fatal("listener was not replaced")
+	}
+	c, err := net.Dial("tcp", old.Addr().String())
+	if err != nil {
+		t.Fatalf("could not connect to rebound listener: %v", err)
+	}
+	c.Close()
+}
+
+func TestRebindListenerStop(t *testing.T) {
+	// Keep the address occupied, such that rebinding keeps failing
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun := FromDesc(&Desc{Name: "test", Mode: Socks})
+	tun.localAddrs = []*address{{addr: busy.Addr().String(), net: "tcp"}}
+	tun.listener = l
+	tun.stop = make(chan struct{})
+
+	time.AfterFunc(100*time.Millisecond, func() { close(tun.stop) })
+	if tun.rebindListener(nil) {
+		t.Fatal("expected rebinding to be aborted")
+	}
+}
+
+func TestExitOnForwardFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun := FromDesc(&Desc{Name: "test", Mode: Local})
+	tun.localAddrs = []*address{{addr: l.Addr().String(), net: "tcp"}}
+	tun.listener = l
+	tun.exitOnFailure = true
+	defer l.Close()
+
+	if tun.acceptFailed(errors.New("too many open files"), nil) {
+		t.Fatal("listener must not be rebound")
+	}
+	if tun.listener != l {
+		t.Fatal("listener was replaced")
+	}
+	if err := tun.reconnectLoop(); err == nil {
+		t.Fatal("expected tunnel not to re-connect")
+	}
+
+	// Remote listeners fail when the connection is lost, which is no
+	// forward failure
+	tun = FromDesc(&Desc{Name: "test", Mode: Remote})
+	tun.exitOnFailure = true
+	tun.acceptFailed(errors.New("EOF"), nil)
+	if tun.forwardFailed.Load() {
+		t.Error("remote listener error taken as forward failure")
+	}
+}
+
+func TestCanRebind(t *testing.T) {
+	no := false
+	cases := []struct {
+		desc Desc
+		want bool
+	}{
+		{Desc{Mode: Local}, true},
+		{Desc{Mode: Socks}, true},
+		{Desc{Mode: Remote}, false},
+		{Desc{Mode: RemoteSocks}, false},
+		{Desc{Mode: Local, RebindListener: &no}, false},
+	}
+	for _, c := range cases {
+		if got := FromDesc(&c.desc).canRebind(); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.desc.Mode, got, c.want)
+		}
+	}
+	if FromDesc(&Desc{Mode: Local}).acceptFailed(net.ErrClosed, nil) {
+		t.Error("closed listener must not be rebound")
+	}
+}
+
+func TestMultipleLocalAddrs(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", Mode: Local,
+		LocalAddress: "127.0.0.1:0, localhost:0"})
+	if err := tun.parseLocalAddrs(false); err != nil {
+		t.Fatal(err)
+	}
+	if len(tun.localAddrs) != 2 || tun.localAddr != tun.localAddrs[0] {
+		t.Fatalf("unexpected addresses: %v", tun.localAddrs)
+	}
+
+	l, err := listenAll(tun.localAddrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ml := l.(*multiListener)
+	for _, sub := range ml.ls {
+		c, err := net.Dial("tcp", sub.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, err := l.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		a.Close()
+		c.Close()
+	}
+
+	l.Close()
+	if _, err := l.Accept(); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("got %v after close, want net.ErrClosed", err)
+	}
+	for _, sub := range ml.ls {
+		if c, err := net.Dial("tcp", sub.Addr().String()); err == nil {
+			c.Close()
+			t.Errorf("%v still listening after close", sub.Addr())
+		}
+	}
+
+	tun = FromDesc(&Desc{Name: "test", Mode: Remote, LocalAddress: "9000,9001"})
+	if err := tun.parseLocalAddrs(true); err == nil {
+		t.Error("expected error for multiple addresses in remote mode")
+	}
+}
+
+// failOnceListener fails its first Accept with a temporary error
+type failOnceListener struct {
+	net.Listener
+	failed bool
+}
+
+func (l *failOnceListener) Accept() (net.Conn, error) {
+	if !l.failed {
+		l.failed = true
+		return nil, &net.OpError{Op: "accept", Net: "tcp",
+			Err: os.NewSyscallError("accept", syscall.EMFILE)}
+	}
+	return l.Listener.Accept()
+}
+
+func TestMultiListenerTemporaryError(t *testing.T) {
+	m := &multiListener{conns: make(chan acceptResult), done: make(chan struct{})}
+	for range 2 {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.ls = append(m.ls, &failOnceListener{Listener: l})
+	}
+	defer m.Close()
+	for _, l := range m.ls {
+		go m.serve(l)
+	}
+
+	for range 2 {
+		if _, err := m.Accept(); !isTemporary(err) {
+			t.Fatalf("expected temporary error, got %v", err)
+		}
+	}
+	// Both addresses keep accepting after the error
+	for _, sub := range m.ls {
+		c, err := net.DialTimeout("tcp", sub.Addr().String(), time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		done := make(chan error, 1)
+		go func() {
+			a, err := m.Accept()
+			if err == nil {
+				a.Close()
+			}
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%v stopped accepting", sub.Addr())
+		}
+	}
+}
+
+func TestParseAddrZone(t *testing.T) {
+	cases := []struct {
+		in   string
+		want address
+	}{
+		{"9000", address{"localhost:9000", "tcp"}},
+		{"[::1]:9000", address{"[::1]:9000", "tcp"}},
+		{"[fe80::1%eth0]:9000", address{"[fe80::1%eth0]:9000", "tcp"}},
+		{"/tmp/sock", address{"/tmp/sock", "unix"}},
+	}
+	for _, c := range cases {
+		got, err := parseAddr(c.in, true)
+		if err != nil {
+			t.Errorf("parseAddr(%q) error: %v", c.in, err)
+			continue
+		}
+		if *got != c.want {
+			t.Errorf("parseAddr(%q) = %+v, want %+v", c.in, *got, c.want)
+		}
+		if got.net != "tcp" || c.in == "9000" {
+			continue
+		}
+		// Zone must survive splitting, as done before dialing
+		host, _, err := net.SplitHostPort(got.addr)
+		if err != nil {
+			t.Errorf("could not split %q: %v", got.addr, err)
+		} else if want := c.in[1:strings.Index(c.in, "]")]; host != want {
+			t.Errorf("got host %q, want %q", host, want)
+		}
+	}
+
+	// Zones are not mistaken for tokens
+	sc := &ssh_config.SSHConfig{Alias: "db", User: "alice", Port: 22}
+	for _, in := range []string{"[fe80::1%utun0]:9000", "[fe80::1%ppp0]:9000"} {
+		tun := FromDesc(&Desc{Name: "test", Mode: Local, LocalAddress: Addresses(in)})
+		tun.expandAddr = sc.ExpandAddress
+		if err := tun.parseLocalAddrs(false); err != nil {
+			t.Fatal(err)
+		}
+		if tun.localAddr.addr != in {
+			t.Errorf("got %q, want %q", tun.localAddr.addr, in)
+		}
+	}
+}
+
+// lockedBuffer is safe for concurrent logging and reading
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func captureLog(t *testing.T) *lockedBuffer {
+	var b lockedBuffer
+	log.Init(&b, true, false)
+	t.Cleanup(func() { log.Init(io.Discard, false, false) })
+	return &b
+}
+
+func TestDownNotifyImmediate(t *testing.T) {
+	b := captureLog(t)
+	tun := FromDesc(&Desc{Name: "test"})
+
+	tun.markDown()
+	if !strings.Contains(b.String(), "disconnected") || !tun.outageNotified() {
+		t.Fatalf("outage not reported immediately: %q", b.String())
+	}
+	tun.markUp(true)
+	if !strings.Contains(b.String(), "recovered") {
+		t.Errorf("recovery not reported: %q", b.String())
+	}
+}
+
+func TestDownNotifyThreshold(t *testing.T) {
+	b := captureLog(t)
+	tun := FromDesc(&Desc{Name: "test", DownNotifyAfter: 1})
+
+	// Short outages go unreported
+	tun.markDown()
+	tun.markUp(true)
+	if b.String() != "" {
+		t.Fatalf("short outage was reported: %q", b.String())
+	}
+
+	tun.markDown()
+	time.Sleep(1100 * time.Millisecond)
+	if !strings.Contains(b.String(), "down for more than 1s") {
+		t.Fatalf("long outage not reported: %q", b.String())
+	}
+	tun.markUp(true)
+	if !strings.Contains(b.String(), "recovered") {
+		t.Errorf("recovery not reported: %q", b.String())
+	}
+}
+
+func TestCategorize(t *testing.T) {
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{&net.DNSError{Err: "no such host", Name: "x"}, CategoryDNS},
+		{errors.New("ssh: handshake failed: ssh: unable to authenticate"), CategoryAuth},
+		{errors.New("host key fingerprint SHA256:a does not match pinned SHA256:b"), CategoryHostKey},
+		{errors.New("dial tcp 127.0.0.1:22: connect: connection refused"), CategoryNetwork},
+		{errors.New("dial tcp 10.0.0.1:22: i/o timeout"), CategoryTimeout},
+		{errors.New("something else"), CategoryOther},
+	}
+	for _, c := range cases {
+		if got := categorize(c.err); got != c.want {
+			t.Errorf("categorize(%q) = %q, want %q", c.err, got, c.want)
+		}
+	}
+}
+
+func TestCheckLocalAllow(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", Mode: RemoteSocks,
+		LocalAllow: []string{"127.0.0.1:5432", "10.0.0.*:*"}})
+	if err := tun.validateLocalAllow(); err != nil {
+		t.Fatal(err)
+	}
+	for addr, want := range map[string]bool{
+		"127.0.0.1:5432": true,
+		"127.0.0.1:22":   false,
+		"10.0.0.7:443":   true,
+		"10.0.1.7:443":   false,
+		"example.com:80": false,
+	} {
+		if got := tun.checkLocalAllow(addr) == nil; got != want {
+			t.Errorf("%v: got allowed %v, want %v", addr, got, want)
+		}
+	}
+
+	// Without a list, everything is allowed
+	tun = FromDesc(&Desc{Name: "test", Mode: RemoteSocks})
+	if err := tun.checkLocalAllow("example.com:80"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	tun = FromDesc(&Desc{Name: "test", LocalAllow: []string{"[:80"}})
+	if err := tun.validateLocalAllow(); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
+
+func TestWatchStalls(t *testing.T) {
+	out := captureLog(t)
+	tun := FromDesc(&Desc{Name: "test", StallWarning: 1})
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	s := tun.watchStalls(c1)
+	defer s.Close()
+
+	// Progress first, then writes block as nothing is read anymore
+	go io.Copy(io.Discard, io.LimitReader(c2, 3))
+	if _, err := s.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	go s.Write([]byte("def"))
+
+	time.Sleep(1500 * time.Millisecond)
+	if !strings.Contains(out.String(), "stalled") ||
+		!strings.Contains(out.String(), "after 3 bytes") {
+		t.Errorf("missing stall warning: %q", out.String())
+	}
+
+	if FromDesc(&Desc{}).watchStalls(c1) != c1 {
+		t.Error("connection wrapped although disabled")
+	}
+}
+
+func TestReapIdle(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", IdleTimeout: 1})
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	i := tun.reapIdle(c1)
+	defer i.Close()
+	go io.Copy(io.Discard, c2)
+
+	// Activity keeps the stream open beyond the timeout
+	for range 3 {
+		time.Sleep(500 * time.Millisecond)
+		if _, err := i.Write([]byte("a")); err != nil {
+			t.Fatalf("stream closed despite activity: %v", err)
+		}
+	}
+
+	tun.scanIdle(time.Now())
+	if _, err := i.Write([]byte("a")); err != nil {
+		t.Fatalf("active stream reaped: %v", err)
+	}
+	tun.scanIdle(time.Now().Add(1500 * time.Millisecond))
+	if _, err := i.Write([]byte("a")); err == nil {
+		t.Error("idle stream not closed")
+	}
+	if len(tun.idleConns) != 0 {
+		t.Error("closed stream still tracked")
+	}
+
+	// The reaper scans at least as often as the timeout
+	if d := tun.idleScanInterval(); d != time.Second {
+		t.Errorf("got scan interval %v", d)
+	}
+
+	if FromDesc(&Desc{}).reapIdle(c1) != c1 {
+		t.Error("connection wrapped although disabled")
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", ReadTimeout: 1, WriteTimeout: 1})
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	d := tun.withDeadlines(c1)
+	defer d.Close()
+
+	// Each write refreshes the deadline, as long as the peer reads
+	go func() {
+		for range 3 {
+			time.Sleep(500 * time.Millisecond)
+			c2.Write([]byte("a"))
+		}
+	}()
+	buf := make([]byte, 1)
+	for range 3 {
+		if _, err := d.Read(buf); err != nil {
+			t.Fatalf("read failed despite activity: %v", err)
+		}
+	}
+
+	start := time.Now()
+	if _, err := d.Read(buf); !os.IsTimeout(err) {
+		t.Errorf("got %v, want read timeout", err)
+	}
+	// Nothing reads from the pipe anymore
+	if _, err := d.Write([]byte("a")); !os.IsTimeout(err) {
+		t.Errorf("got %v, want write timeout", err)
+	}
+	if e := time.Since(start); e > 3*time.Second {
+		t.Errorf("deadlines took %v", e)
+	}
+
+	if FromDesc(&Desc{}).withDeadlines(c1) != c1 {
+		t.Error("connection wrapped although disabled")
+	}
+}
+
+func TestDeadlinesOneWay(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", ReadTimeout: 1})
+
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	client, target := tun.withPairedDeadlines(c1, c3)
+	defer client.Close()
+	defer target.Close()
+
+	// Data only flows from the target, the client never sends anything
+	go func() {
+		for range 4 {
+			time.Sleep(400 * time.Millisecond)
+			c4.Write([]byte("a"))
+		}
+	}()
+	go func() {
+		buf := make([]byte, 1)
+		for range 4 {
+			if _, err := target.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	if _, err := client.Read(make([]byte, 1)); !os.IsTimeout(err) {
+		t.Fatalf("got %v, want read timeout", err)
+	}
+	// The last data arrives after 1.6s, the deadline expires a second later
+	if e := time.Since(start); e < 2*time.Second {
+		t.Errorf("read timed out after %v despite activity", e)
+	}
+}
+
+func TestMaxReconnectAttempts(t *testing.T) {
+	tun := FromDesc(&Desc{
+		Name:                 "test",
+		Host:                 "127.0.0.1:1",
+		LocalAddress:         "0",
+		RemoteAddress:        "localhost:80",
+		MaxReconnectAttempts: 3,
+	})
+	tun.stop = make(chan struct{})
+	tun.backoff = time.Millisecond
+
+	err := tun.reconnectLoop()
+	if err == nil || !strings.Contains(err.Error(), "gave up after 3 attempts") {
+		t.Errorf("expected to give up, got %v", err)
+	}
+}
+
+func TestBound(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", Mode: Local,
+		LocalAddress: "0, 127.0.0.1:0"})
+	if tun.Addrs() != nil {
+		t.Error("expected no addresses before listening")
+	}
+	if err := tun.parseLocalAddrs(true); err != nil {
+		t.Fatal(err)
+	}
+	if err := tun.makeListener(); err != nil {
+		t.Fatal(err)
+	}
+	defer tun.listener.Close()
+
+	if len(tun.Bound) != 2 {
+		t.Fatalf("got bound addresses %v", tun.Bound)
+	}
+	for _, a := range tun.Bound {
+		if strings.HasSuffix(a, ":0") {
+			t.Errorf("port of %v not resolved", a)
+		}
+		c, err := net.Dial("tcp", a)
+		if err != nil {
+			t.Fatalf("could not connect to %v: %v", a, err)
+		}
+		c.Close()
+	}
+	if as := tun.Addrs(); len(as) != 2 || as[1].String() != tun.Bound[1] {
+		t.Errorf("got addresses %v, bound %v", as, tun.Bound)
+	}
+}
+
+func TestCheckClientAllow(t *testing.T) {
+	out := captureLog(t)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cases := []struct {
+		allow []string
+		mode  Mode
+		want  bool
+	}{
+		{nil, Local, true},
+		{[]string{"127.0.0.1"}, Local, true},
+		{[]string{"10.0.0.0/8", "127.0.0.0/8"}, Socks, true},
+		{[]string{"10.0.0.0/8"}, Local, false},
+		{[]string{"10.0.0.0/8"}, Remote, true},
+	}
+	for _, cs := range cases {
+		tun := FromDesc(&Desc{Name: "test", Mode: cs.mode, ClientAllow: cs.allow})
+		if err := tun.parseClientAllow(); err != nil {
+			t.Fatal(err)
+		}
+		if got := tun.checkClientAllow(conn); got != cs.want {
+			t.Errorf("%v in %v mode: got %v, want %v", cs.allow, cs.mode, got, cs.want)
+		}
+	}
+	if !strings.Contains(out.String(), "not in client_allow") {
+		t.Errorf("missing warning: %q", out.String())
+	}
+
+	tun := FromDesc(&Desc{Name: "test", ClientAllow: []string{"10.0.0.0/33"}})
+	if err := tun.parseClientAllow(); err == nil {
+		t.Error("expected error for malformed CIDR")
+	}
+}
+
+func TestStats(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test"})
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	c := tun.count(c1, tun.admit(c1))
+	defer c.Close()
+
+	go c2.Write([]byte("hello"))
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(c, buf); err != nil {
+		t.Fatal(err)
+	}
+	go io.Copy(io.Discard, c2)
+	if _, err := c.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := tun.Stats()
+	if s.BytesIn != 5 || s.BytesOut != 3 || s.ActiveConns != 1 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestStatsDeltas(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test"})
+	tun.RestoreStats(Stats{BytesIn: 5, BytesOut: 3, Reconnects: 1})
+
+	d1, d2 := tun.NewStatsDeltas(), tun.NewStatsDeltas()
+	if r := d1.Next(); r != (Stats{BytesIn: 5, BytesOut: 3, Reconnects: 1}) {
+		t.Errorf("unexpected first delta: %+v", r)
+	}
+	tun.bytesOut.Add(2)
+	if r := d1.Next(); r != (Stats{BytesOut: 2}) {
+		t.Errorf("unexpected delta: %+v", r)
+	}
+	// Baselines are per caller, the counters are left alone
+	if r := d2.Next(); r != (Stats{BytesIn: 5, BytesOut: 5, Reconnects: 1}) {
+		t.Errorf("unexpected delta of second caller: %+v", r)
+	}
+	if s := tun.Stats(); s.BytesOut != 5 {
+		t.Errorf("counters changed: %+v", s)
+	}
+
+	tun.RestoreStats(Stats{BytesOut: 1})
+	if r := d1.Next(); r != (Stats{BytesOut: 1}) {
+		t.Errorf("unexpected delta after restore: %+v", r)
+	}
+}
+
+func TestConns(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test"})
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	s := tun.admit(c1)
+	tun.setTarget(s, "db:5432")
+	c := tun.count(c1, s)
+	defer c.Close()
+
+	go c2.Write([]byte("hello"))
+	if _, err := io.ReadFull(c, make([]byte, 5)); err != nil {
+		t.Fatal(err)
+	}
+
+	conns := tun.Conns()
+	if len(conns) != 1 {
+		t.Fatalf("got %d connections, want 1", len(conns))
+	}
+	if cn := conns[0]; cn.Target != "db:5432" || cn.BytesIn != 5 || cn.BytesOut != 0 || cn.Started.IsZero() {
+		t.Errorf("unexpected connection: %+v", cn)
+	}
+
+	tun.release(c1)
+	if conns = tun.Conns(); len(conns) != 0 {
+		t.Errorf("released connection still listed: %+v", conns)
+	}
+}
+
+func TestInitialRetries(t *testing.T) {
+	out := captureLog(t)
+	tun := FromDesc(&Desc{Name: "test", InitialRetries: 2})
+	tun.hops = []ssh_config.Hop{{HostName: "127.0.0.1", Port: 1,
+		ClientConfig: &ssh.ClientConfig{Timeout: time.Second}}}
+
+	if err := tun.connect(); err == nil {
+		t.Fatal("expected connecting to fail")
+	}
+	if n := strings.Count(out.String(), "Retrying"); n != 2 {
+		t.Errorf("got %d retries, want 2: %q", n, out.String())
+	}
+
+	// Re-connects are not retried here
+	tun.stop = make(chan struct{})
+	tun.connect()
+	if n := strings.Count(out.String(), "Retrying"); n != 2 {
+		t.Errorf("re-connect was retried: %q", out.String())
+	}
+}
+
+type closeRecorder struct{ closed chan struct{} }
+
+func (c *closeRecorder) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestRotateDrain(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test", Status: Open})
+	c1, c2 := net.Pipe()
+	defer c2.Close()
+	tun.admit(c1)
+
+	old := &closeRecorder{make(chan struct{})}
+	go tun.drain(old, tun.activeStreams(), make(chan struct{}))
+
+	// Connections admitted after rotating are not waited for
+	c3, c4 := net.Pipe()
+	defer c4.Close()
+	tun.admit(c3)
+
+	select {
+	case <-old.closed:
+		t.Fatal("old client closed while a connection is pending")
+	case <-time.After(3 * drainPoll):
+	}
+	tun.release(c1)
+	select {
+	case <-old.closed:
+	case <-time.After(time.Second):
+		t.Fatal("old client not closed after draining")
+	}
+
+	if err := FromDesc(&Desc{Name: "test", Mode: Remote, Status: Open}).Rotate(); err == nil {
+		t.Error("expected error when rotating remote tunnel")
+	}
+	if err := FromDesc(&Desc{Name: "test", Status: Closed}).Rotate(); err == nil {
+		t.Error("expected error when rotating closed tunnel")
+	}
+}
+
+func testSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRotateRenewsCredentials(t *testing.T) {
+	var valid atomic.Pointer[ssh.Signer]
+	conf := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(k.Marshal(), (*valid.Load()).PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	hostKey := testSigner(t)
+	conf.AddHostKey(hostKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if sc, chans, reqs, err := ssh.NewServerConn(c, conf); err == nil {
+					go ssh.DiscardRequests(reqs)
+					for range chans {
+					}
+					sc.Close()
+				}
+			}()
+		}
+	}()
+
+	old, renewed := testSigner(t), testSigner(t)
+	valid.Store(&old)
+	current := old
+	keepAlive := 0
+	tun := FromDesc(&Desc{
+		Name:              "test",
+		Host:              "127.0.0.1",
+		Port:              StringOrInt(fmt.Sprint(l.Addr().(*net.TCPAddr).Port)),
+		User:              "test",
+		LocalAddress:      "127.0.0.1:0",
+		RemoteAddress:     "127.0.0.1:1",
+		PinnedFingerprint: ssh_config.FingerprintOf(hostKey.PublicKey()),
+		PreferredAuths:    "publickey",
+		KeepAlive:         &keepAlive,
+	})
+	tun.Signers = ssh_config.SignersFunc(func() ([]ssh.Signer, error) {
+		return []ssh.Signer{current}, nil
+	})
+	tun.SignersOnly = true
+	if err := tun.Open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server only accepts the renewed key from now on
+	current = renewed
+	valid.Store(&renewed)
+	if err := tun.Rotate(); err != nil {
+		t.Errorf("rotating with renewed key failed: %v", err)
+	}
+
+	tun.Close()
+	select {
+	case <-tun.Closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("tunnel did not close")
+	}
+}
+
+func TestAttemptLimiter(t *testing.T) {
+	l := &attemptLimiter{limit: 2, window: time.Minute}
+	now := time.Now()
+	// Attempts one second apart are made at these offsets
+	for i, want := range []time.Duration{0, time.Second, time.Minute, time.Minute + time.Second} {
+		at := now.Add(time.Duration(i) * time.Second)
+		if got := at.Add(l.reserve("host:22", at)).Sub(now); got != want {
+			t.Errorf("attempt %d: made at %v, want %v", i+1, got, want)
+		}
+	}
+	if got := l.reserve("other:22", now); got != 0 {
+		t.Errorf("got wait %v for other host", got)
+	}
+	// Attempts leave the window
+	if got := l.reserve("host:22", now.Add(5*time.Minute)); got != 0 {
+		t.Errorf("got wait %v after window", got)
+	}
+}
+
+func TestFallbackDelay(t *testing.T) {
+	tun := FromDesc(&Desc{Name: "test"})
+	if d := tun.fallbackDelay(); d != happyEyeballsDelay {
+		t.Errorf("got %v by default, want %v", d, happyEyeballsDelay)
+	}
+	off := false
+	tun.HappyEyeballs = &off
+	if d := tun.fallbackDelay(); d >= 0 {
+		t.Errorf("got %v when disabled, want negative", d)
+	}
+}
+
+func TestDescribeAlgorithms(t *testing.T) {
+	a := ssh.NegotiatedAlgorithms{
+		KeyExchange: "curve25519-sha256",
+		HostKey:     ssh.KeyAlgoED25519,
+		Read:        ssh.DirectionAlgorithms{Cipher: ssh.CipherAES128CTR, MAC: ssh.HMACSHA256},
+		Write:       ssh.DirectionAlgorithms{Cipher: ssh.CipherChaCha20Poly1305},
+	}
+	want := "kex curve25519-sha256, host key ssh-ed25519, " +
+		"cipher chacha20-poly1305@openssh.com/aes128-ctr, mac implicit/hmac-sha2-256"
+	if got := describeAlgorithms(a); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestDialServerSocks5(t *testing.T) {
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	go func() {
+		if c, err := target.Accept(); err == nil {
+			c.Write([]byte("ok"))
+			c.Close()
+		}
+	}()
+
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+	serv := &proxy.Server{Username: "user", Password: "pass"}
+	go func() {
+		if c, err := pl.Accept(); err == nil {
+			serv.ServeConn(c)
+		}
+	}()
+
+	tun := FromDesc(&Desc{Name: "test", Proxy: "socks5://user:pass@" + pl.Addr().String()})
+	if err := tun.resolveProxy(); err != nil {
+		t.Fatal(err)
+	}
+	c, err := tun.dialServer(target.Addr().String(), time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	b, err := io.ReadAll(c)
+	if err != nil || string(b) != "ok" {
+		t.Errorf("got %q, %v through proxy", b, err)
+	}
+
+	if _, err := parseProxyURL("socks4://localhost:1080"); err == nil {
+		t.Error("expected error for unsupported scheme")
+	}
+}
+
+func TestDialFunc(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	var gotAddr string
+	tun := FromDesc(&Desc{Name: "test", Proxy: "socks5://127.0.0.1:1"})
+	tun.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("expected a deadline")
+		}
+		gotAddr = addr
+		return client, nil
+	}
+	if err := tun.resolveProxy(); err != nil {
+		t.Fatal(err)
+	}
+	// The proxy must be bypassed
+	c, err := tun.dialServer("example.com:22", time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != client || gotAddr != "example.com:22" {
+		t.Errorf("got conn %v to %q", c, gotAddr)
+	}
+}
+
+func TestTooManyAuthFailures(t *testing.T) {
+	other, right := testSigner(t), testSigner(t)
+	conf := &ssh.ServerConfig{
+		MaxAuthTries: 1,
+		PublicKeyCallback: func(_ ssh.ConnMetadata, k ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(k.Marshal(), right.PublicKey().Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key")
+		},
+	}
+	conf.AddHostKey(testSigner(t))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if sc, _, reqs, err := ssh.NewServerConn(c, conf); err == nil {
+					go ssh.DiscardRequests(reqs)
+					sc.Close()
+				}
+			}()
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	hop := ssh_config.Hop{HostName: "127.0.0.1", Port: addr.Port, ClientConfig: &ssh.ClientConfig{
+		User:            "test",
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(other, right)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	}}
+	tun := FromDesc(&Desc{Name: "test"})
+	if _, err := tun.wrapClient(nil, hop); !ssh_config.IsTooManyAuthFailures(err) {
+		t.Fatalf("expected too many authentication failures, got %v", err)
+	}
+
+	hop.PrunedAuth = func() ([]ssh.AuthMethod, error) {
+		return []ssh.AuthMethod{ssh.PublicKeys(right)}, nil
+	}
+	c, err := tun.wrapClient(nil, hop)
+	if err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	c.Close()
+}
+
+func TestDesktopNotify(t *testing.T) {
+	sent := make(chan string, 2)
+	old := sendNotification
+	sendNotification = func(title, msg string) error {
+		sent <- title + ": " + msg
+		return nil
+	}
+	t.Cleanup(func() { sendNotification = old })
+
+	tun := FromDesc(&Desc{Name: "db", Notify: true})
+	tun.markDown()
+	tun.markUp(true)
+	// Notifications are sent concurrently, order is not guaranteed
+	var got []string
+	for range 2 {
+		select {
+		case msg := <-sent:
+			got = append(got, msg)
+		case <-time.After(time.Second):
+			t.Fatalf("missing notifications, got %q", got)
+		}
+	}
+	slices.Sort(got)
+	want := []string{"Tunnel 'db': disconnected", "Tunnel 'db': recovered after 0s"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Tunnels not marked are silent
+	tun = FromDesc(&Desc{Name: "other"})
+	tun.markDown()
+	tun.markUp(true)
+	select {
+	case got := <-sent:
+		t.Errorf("unexpected notification %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// serveSSH runs an SSH server accepting any client and returns its address
+func serveSSH(t *testing.T, hostKey ssh.Signer) *net.TCPAddr {
+	conf := &ssh.ServerConfig{NoClientAuth: true}
+	conf.AddHostKey(hostKey)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				if sc, chans, reqs, err := ssh.NewServerConn(c, conf); err == nil {
+					go ssh.DiscardRequests(reqs)
+					for nc := range chans {
+						nc.Reject(ssh.Prohibited, "test")
+					}
+					sc.Close()
+				}
+			}()
+		}
+	}()
+	return l.Addr().(*net.TCPAddr)
+}
+
+// perConnectRuns makes the auth method name unique across test runs, as
+// registered methods cannot be removed
+var perConnectRuns atomic.Int32
+
+func TestRegisteredAuthPerConnect(t *testing.T) {
+	var calls atomic.Int32
+	name := fmt.Sprintf("test-per-connect-%d", perConnectRuns.Add(1))
+	ssh_config.RegisterAuthMethod(name, func(*ssh_config.SSHConfig) (ssh.AuthMethod, error) {
+		calls.Add(1)
+		return ssh.Password("token"), nil
+	})
+
+	addr := serveSSH(t, testSigner(t))
+	sc := &ssh_config.SSHConfig{
+		Alias:          "test",
+		HostName:       "127.0.0.1",
+		Port:           addr.Port,
+		User:           "test",
+		PreferredAuths: []string{name},
+	}
+	if err := sc.SetHostKeyPolicy("insecure"); err != nil {
+		t.Fatal(err)
+	}
+	hops, err := sc.ToHops()
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := calls.Load()
+
+	tun := FromDesc(&Desc{Name: "test"})
+	for i := range 2 {
+		c, err := tun.wrapClient(nil, hops[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if got := calls.Load() - base; got != int32(i+1) {
+			t.Fatalf("factory called %d times after %d connects", got, i+1)
+		}
+	}
+}
+
+func TestResolveSRV(t *testing.T) {
+	defer func(f func(string, string, string) (string, []*net.SRV, error)) { lookupSRV = f }(lookupSRV)
+	recs := []*net.SRV{{Target: "db1.example.com.", Port: 5432}, {Target: "db2.example.com.", Port: 5433}}
+	lookupSRV = func(_, _, name string) (string, []*net.SRV, error) {
+		if name != "_postgres._tcp.example.com" {
+			return "", nil, fmt.Errorf("no such host")
+		}
+		return name, recs, nil
+	}
+
+	a, err := parseAddr("srv://_postgres._tcp.example.com", false)
+	if err != nil || a.net != "srv" {
+		t.Fatalf("got %+v, %v", a, err)
+	}
+	if got, err := resolveSRV(a.addr); err != nil || got != "db1.example.com:5432" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if _, err := resolveSRV("_other._tcp.example.com"); err == nil {
+		t.Error("expected lookup error")
+	}
+	recs = []*net.SRV{{Target: "."}}
+	if _, err := resolveSRV(a.addr); err == nil {
+		t.Error("expected error for unavailable service")
+	}
+
+	tun := FromDesc(&Desc{Name: "test", LocalAddress: "srv://_postgres._tcp.example.com"})
+	if err := tun.parseLocalAddrs(true); err == nil {
+		t.Error("expected error for SRV local address")
+	}
+}
+
+func TestSharing(t *testing.T) {
+	yes := true
+	hops := []ssh_config.Hop{{HostName: "jump", Port: 22, ClientConfig: &ssh.ClientConfig{User: "a"}},
+		{HostName: "db", Port: 2222, ClientConfig: &ssh.ClientConfig{User: "b"}}}
+
+	s, err := FromDesc(&Desc{Mode: Local}).sharing(&ssh_config.SSHConfig{}, hops)
+	if err != nil || s != nil {
+		t.Errorf("expected no sharing by default, got %v, %v", s, err)
+	}
+	sc := &ssh_config.SSHConfig{ControlPath: "/tmp/cm", ControlPersist: time.Minute}
+	if s, _ = FromDesc(&Desc{Mode: Socks}).sharing(sc, hops); s == nil || s.key != "/tmp/cm" || s.persist != time.Minute {
+		t.Errorf("expected ControlPath to be used, got %v", s)
+	}
+	if s, _ = FromDesc(&Desc{Mode: Local, ShareConnection: &yes}).sharing(&ssh_config.SSHConfig{}, hops); s == nil || s.key != "a@jump:22,b@db:2222" {
+		t.Errorf("expected route to be used as key, got %v", s)
+	}
+	if s, _ = FromDesc(&Desc{Mode: Remote}).sharing(sc, hops); s != nil {
+		t.Errorf("remote tunnels must not share, got %v", s)
+	}
+	if _, err = FromDesc(&Desc{Mode: Remote, ShareConnection: &yes}).sharing(sc, hops); err == nil {
+		t.Error("expected error for remote tunnel sharing its connection")
+	}
+}
+
+func TestSharedHostKeyVerified(t *testing.T) {
+	hostKey := testSigner(t)
+	addr := serveSSH(t, hostKey)
+	hopWith := func(cb ssh.HostKeyCallback) []ssh_config.Hop {
+		return []ssh_config.Hop{{HostName: "127.0.0.1", Port: addr.Port, ClientConfig: &ssh.ClientConfig{
+			User: "test", HostKeyCallback: cb, Timeout: time.Second}}}
+	}
+	pinned := func(k ssh.PublicKey) ssh.HostKeyCallback {
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if !bytes.Equal(key.Marshal(), k.Marshal()) {
+				return fmt.Errorf("key does not match pin")
+			}
+			return nil
+		}
+	}
+	share := &shareSpec{key: t.Name()}
+
+	insecure := FromDesc(&Desc{Name: "insecure", Mode: Local})
+	hops := hopWith(ssh.InsecureIgnoreHostKey())
+	insecure.recordHostKey(&hops[0])
+	c, err := insecure.dialShared(bastion{hops: hops, share: share})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// A tunnel pinning another key must not ride the connection
+	other := FromDesc(&Desc{Name: "pinned-other", Mode: Local})
+	if _, err := other.dialShared(bastion{hops: hopWith(pinned(testSigner(t).PublicKey())), share: share}); err == nil {
+		t.Error("joined shared connection despite host key mismatch")
+	}
+
+	same := FromDesc(&Desc{Name: "pinned", Mode: Local})
+	c2, err := same.dialShared(bastion{hops: hopWith(pinned(hostKey.PublicKey())), share: share})
+	if err != nil {
+		t.Fatalf("could not join with matching pin: %v", err)
+	}
+	c2.Close()
+}
+
+func TestKeepAliveDelay(t *testing.T) {
+	tun := FromDesc(&Desc{})
+	if d := tun.keepAliveDelay(); d != keepAliveDelay {
+		t.Errorf("got %v, want default %v", d, keepAliveDelay)
+	}
+	zero := 0
+	tun.KeepAliveDelay = &zero
+	if d := tun.keepAliveDelay(); d != 0 {
+		t.Errorf("got %v, want 0", d)
+	}
+}
+
+func TestDialTargetUnresolved(t *testing.T) {
+	conf := &ssh.ServerConfig{NoClientAuth: true}
+	conf.AddHostKey(testSigner(t))
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	targets := make(chan string, 1)
+	go func() {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+		sc, chans, reqs, err := ssh.NewServerConn(c, conf)
+		if err != nil {
+			return
+		}
+		defer sc.Close()
+		go ssh.DiscardRequests(reqs)
+		for nc := range chans {
+			var req struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if err := ssh.Unmarshal(nc.ExtraData(), &req); err == nil {
+				targets <- net.JoinHostPort(req.Host, fmt.Sprint(req.Port))
+			}
+			nc.Reject(ssh.ConnectionFailed, "test")
+		}
+	}()
+
+	client, err := ssh.Dial("tcp", l.Addr().String(), &ssh.ClientConfig{
+		User:            "test",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// The name only resolves on the server, it must be sent as is
+	tun := FromDesc(&Desc{Name: "test", Mode: Local})
+	tun.setClient(client)
+	a, err := parseAddr("db.invalid:5432", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tun.dialTarget(a)
+	select {
+	case got := <-targets:
+		if got != "db.invalid:5432" {
+			t.Errorf("server was asked for %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no direct-tcpip request received")
+	}
+}
@@ -59,6 +59,32 @@ func TestAgentPubIdentityFile(t *testing.T) {
 	}
 }
 
+// An IdentityFile given as fingerprint selects the matching agent key
+func TestAgentFingerprintIdentity(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_fp_id"
+	cfg.useAgent = true
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	cancel, err = startAgent(getEnv(env, "SSH_AUTH_SOCK"))
+	if err != nil {
+		t.Fatalf("could not start agent: %v", err)
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
 func TestAgentIdsOnly(t *testing.T) {
 	cfg := defaultConfig
 	cfg.sshConfig = "../testdata/config/ssh_config_ids_only"
@@ -1,9 +1,13 @@
 package e2e
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
@@ -36,6 +40,26 @@ func killPID(pid int) error {
 	return proc.Signal(syscall.SIGTERM)
 }
 
+// stopDaemon stops the daemon of env and waits for it to exit
+func stopDaemon(t *testing.T, env []string, cancel context.CancelFunc) {
+	sock := getEnv(env, "BORING_SOCK")
+	b, err := os.ReadFile(strings.TrimSuffix(sock, filepath.Ext(sock)) + ".pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for deadline := time.Now().Add(connTimeout); pidRunning(pid); {
+		if time.Now().After(deadline) {
+			t.Fatal("daemon did not exit")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
 func testDaemonLaunch(t *testing.T, env []string) string {
 	c, out, err := cliCommand(env, "list")
 	if err != nil {
@@ -266,3 +290,111 @@ func TestDaemonLaunchMismatch2(t *testing.T) {
 		t.Fatalf("expected incompatibility error, got: %s", out)
 	}
 }
+
+func TestDaemonHealth(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "test"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+	// Fails, since there is no server on the other end
+	cliCommand(env, "open", "test-pinned-wrong")
+
+	c, out, err := cliCommand(env, "health", "--json")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Errorf("expected exit code 1 due to failed tunnel, got %d: %s", c, out)
+	}
+	var h daemon.HealthInfo
+	if err := json.Unmarshal([]byte(out), &h); err != nil {
+		t.Fatalf("could not parse output %q: %v", out, err)
+	}
+	if h.Open != 1 || h.Failed != 1 || h.Configured <= 2 {
+		t.Errorf("unexpected health: %+v", h)
+	}
+}
+
+func TestDaemonRestoreState(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig
+	cfg.boringConfig = filepath.Join(dir, "config.toml")
+	conf := fmt.Sprintf("state_file = %q\n[[tunnels]]\nname = \"test\"\n"+
+		"host = \"127.0.0.1\"\nlocal = 49711\nremote = \"localhost:49712\"\n",
+		filepath.Join(dir, "state.json"))
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	if c, out, err := cliCommand(env, "open", "test"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+	if c, out, err := cliCommand(env, "pause", "test"); err != nil || c != 0 {
+		t.Fatalf("could not pause tunnel: %v, %s", err, out)
+	}
+
+	stopDaemon(t, env, cancel)
+
+	if cancel, err = daemonWithCancel(env); err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+	deadline := time.Now().Add(connTimeout)
+	for {
+		_, out, _ := cliCommand(env, "list")
+		lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+		if len(lines) > 1 && strings.Fields(lines[1])[0] == "paused" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("test tunnel not restored as paused: %s", out)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestDaemonSchedule(t *testing.T) {
+	now := time.Now()
+	window := func(from, to time.Duration) string {
+		return now.Add(from).Format("15:04") + "-" + now.Add(to).Format("15:04")
+	}
+	cfg := defaultConfig
+	cfg.boringConfig = filepath.Join(t.TempDir(), "config.toml")
+	conf := fmt.Sprintf("[[tunnels]]\nname = \"on\"\nhost = \"127.0.0.1\"\nlocal = 49711\n"+
+		"remote = \"localhost:49712\"\nschedule = %q\n[[tunnels]]\nname = \"off\"\n"+
+		"host = \"127.0.0.1\"\nlocal = 49713\nremote = \"localhost:49714\"\nschedule = %q\n",
+		window(-time.Hour, time.Hour), window(time.Hour, 2*time.Hour))
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	var out string
+	for range 50 {
+		_, out, _ = cliCommand(env, "list")
+		out = stripANSI(out)
+		if !strings.Contains(out, "closed") {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n")[1:] {
+		f := strings.Fields(line)
+		if f[1] == "on" && f[0] == "closed" || f[1] == "off" && f[0] != "scheduled-down" {
+			t.Errorf("unexpected status: %q", line)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build !windows
+
+package e2e
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestDaemonReloadOnHangup(t *testing.T) {
+	cfg := defaultConfig
+	cfg.boringConfig = filepath.Join(t.TempDir(), "config.toml")
+	write := func(local int) {
+		conf := fmt.Sprintf("keep_alive = 0\n[[tunnels]]\nname = \"test\"\n"+
+			"host = \"127.0.0.1\"\nlocal = %d\nremote = \"localhost:49712\"\n", local)
+		if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(49711)
+
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "test"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+
+	sock := getEnv(env, "BORING_SOCK")
+	b, err := os.ReadFile(strings.TrimSuffix(sock, filepath.Ext(sock)) + ".pid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hangup := func(want string) {
+		if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+			t.Fatal(err)
+		}
+		deadline := time.Now().Add(connTimeout)
+		for time.Now().Before(deadline) {
+			l, _ := os.ReadFile(getEnv(env, "BORING_LOG_FILE"))
+			if strings.Contains(string(l), want) {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("daemon did not log %q", want)
+	}
+
+	// Unchanged tunnels are kept
+	hangup("0 tunnel(s) restarted")
+
+	write(49715)
+	hangup("1 tunnel(s) restarted")
+	testTunnel(t, "localhost:49715", "localhost:49712")
+}
@@ -3,6 +3,7 @@ package e2e
 import (
 	"context"
 	"fmt"
+	"io"
 	"net"
 	"os"
 	"os/exec"
@@ -159,10 +160,15 @@ func makeDefaultEnvWithDaemon(t *testing.T) ([]string, context.CancelFunc, error
 }
 
 func cliCommand(env []string, cmds ...string) (int, string, error) {
+	return cliCommandStdin(env, nil, cmds...)
+}
+
+func cliCommandStdin(env []string, stdin io.Reader, cmds ...string) (int, string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), cliTimeout)
 	defer cancel()
 	cmd := exec.CommandContext(ctx, binary, cmds...)
 	cmd.Env = env
+	cmd.Stdin = stdin
 	output, err := cmd.CombinedOutput()
 	if exitErr, ok := err.(*exec.ExitError); ok {
 		return exitErr.ExitCode(), string(output), nil
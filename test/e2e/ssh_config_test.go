@@ -1,6 +1,8 @@
 package e2e
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -31,3 +33,94 @@ func TestSubstitutions(t *testing.T) {
 func TestRSA(t *testing.T) {
 	// TODO
 }
+
+func TestShowSSHConfig(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_subst"
+	env, err := makeEnv(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "config", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	found := false
+	for _, l := range strings.Split(stripANSI(out), "\n") {
+		f := strings.Fields(l)
+		if len(f) == 3 && f[0] == "Port" {
+			found = f[1] == "58391" && f[2] == cfg.sshConfig
+		}
+	}
+	if !found {
+		t.Errorf("port not resolved from ssh config: %s", out)
+	}
+}
+
+func TestKnownHost(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "known-host", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 || !strings.Contains(out, "[127.0.0.1]:58391' is trusted") ||
+		!strings.Contains(out, "ssh-ed25519") {
+		t.Errorf("exit code %d: %s", c, out)
+	}
+
+	c, out, err = cliCommand(env, "known-host", "unknown")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 || !strings.Contains(out, "not in known hosts") {
+		t.Errorf("exit code %d: %s", c, out)
+	}
+}
+
+func TestOpenByTag(t *testing.T) {
+	cfg := defaultConfig
+	cfg.sshConfig = "../testdata/config/ssh_config_tag"
+	cfg.boringConfig = filepath.Join(t.TempDir(), "config.toml")
+	conf := "[[tunnels]]\nname = \"tagged\"\nhost = \"tagged\"\nlocal = 49711\n" +
+		"remote = \"localhost:49712\"\n[[tunnels]]\nname = \"untagged\"\n" +
+		"host = \"127.0.0.1\"\nlocal = 49713\nremote = \"localhost:49714\"\n" +
+		"[[tunnels]]\nname = \"labeled\"\nhost = \"127.0.0.1\"\ntags = [\"fleet\"]\n" +
+		"local = 49715\nremote = \"localhost:49716\"\n"
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "-t", "fleet"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnels: %v, %s", err, out)
+	}
+	_, out, _ := cliCommand(env, "list")
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	for _, line := range lines[1:] {
+		f := strings.Fields(line)
+		if running := f[0] != "closed"; running != (f[1] != "untagged") {
+			t.Errorf("unexpected status: %q", line)
+		}
+	}
+
+	_, out, _ = cliCommand(env, "list", "-t", "fleet")
+	if lines = strings.Split(strings.TrimSpace(stripANSI(out)), "\n"); len(lines) != 3 {
+		t.Errorf("expected two tunnels with tag: %s", out)
+	}
+
+	if c, out, _ := cliCommand(env, "close", "-t", "other"); c == 0 {
+		t.Errorf("expected failure for unknown tag: %s", out)
+	}
+}
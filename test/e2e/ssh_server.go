@@ -123,6 +123,9 @@ func startServer() (s *sshServer, err error) {
 	s = &sshServer{}
 	s.config = &ssh.ServerConfig{
 		PublicKeyCallback: checker.Authenticate,
+		BannerCallback: func(ssh.ConnMetadata) string {
+			return "boring test server\n"
+		},
 	}
 
 	s.conns = make(map[net.Conn]struct{})
@@ -197,12 +200,7 @@ func (s *sshServer) handleConn(conn net.Conn) {
 
 	for newChannel := range chans {
 		if newChannel.ChannelType() == "direct-tcpip" {
-			channel, requests, err := newChannel.Accept()
-			if err != nil {
-				return
-			}
-			go ssh.DiscardRequests(requests)
-			go handleForwardedConnection(channel, newChannel.ExtraData())
+			go handleForwardedConnection(newChannel)
 		} else {
 			newChannel.Reject(ssh.UnknownChannelType, "no channels supported")
 		}
@@ -251,22 +249,30 @@ func listenAndForward(c *ssh.ServerConn, req tcpipForwardRequest) {
 	}
 }
 
-func handleForwardedConnection(channel ssh.Channel, extra []byte) {
-	defer channel.Close()
-
+func handleForwardedConnection(newChannel ssh.NewChannel) {
 	var payload forwardedTCPPayload
-	if err := ssh.Unmarshal(extra, &payload); err != nil {
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &payload); err != nil {
 		fmt.Printf("failed to unmarshal forwarded-tcpip payload: %v\n", err)
+		newChannel.Reject(ssh.Prohibited, "bad payload")
 		return
 	}
 	addr := net.JoinHostPort(payload.Addr, fmt.Sprintf("%d", payload.Port))
 
+	// Like OpenSSH, only accept the channel once connected to the target
 	conn, err := net.Dial("tcp", addr)
 	if err != nil {
 		fmt.Printf("failed to connect to %s: %v\n", addr, err)
+		newChannel.Reject(ssh.ConnectionFailed, err.Error())
 		return
 	}
 	defer conn.Close()
+
+	channel, requests, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+	go ssh.DiscardRequests(requests)
 	go io.Copy(conn, channel)
 	io.Copy(channel, conn)
 }
@@ -308,6 +314,12 @@ func (s *sshServer) closeAll() {
 	}
 }
 
+func (s *sshServer) connCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
 func (s *sshServer) resetKeepAlives() {
 	s.keepAliveMu.Lock()
 	defer s.keepAliveMu.Unlock()
@@ -2,6 +2,8 @@ package e2e
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net"
@@ -46,7 +48,7 @@ func TestList(t *testing.T) {
 
 	// Check that header is present
 	if !(reflect.DeepEqual(strings.Fields(lines[0]),
-		[]string{"Status", "Name", "Local", "Remote", "Via"})) {
+		[]string{"Status", "Name", "Local", "Remote", "Via", "Server"})) {
 		t.Errorf("list output did not start with expected header: %s", out)
 	}
 
@@ -912,7 +914,9 @@ func TestTunnelSocksRemote(t *testing.T) {
 }
 
 func TestTunnelKeepAlive(t *testing.T) {
-	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	cfg := defaultConfig
+	cfg.boringConfig = "../testdata/config/config_keepalive.toml"
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
 	if err != nil {
 		t.Fatalf("%v", err.Error())
 	}
@@ -937,6 +941,33 @@ func TestTunnelKeepAlive(t *testing.T) {
 	}
 }
 
+// Test that the first keep-alive is delayed by keep_alive_delay
+func TestTunnelKeepAliveDelay(t *testing.T) {
+	cfg := defaultConfig
+	cfg.boringConfig = "../testdata/config/config_keepalive.toml"
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "test-keepalive-delay"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+
+	server.resetKeepAlives()
+
+	// No keep-alive within the interval, as the delay comes on top
+	time.Sleep(1100 * time.Millisecond)
+	if server.keepAlives != 0 {
+		t.Fatalf("expected no keep-alive yet, got %d", server.keepAlives)
+	}
+	time.Sleep(1000 * time.Millisecond)
+	if server.keepAlives != 1 {
+		t.Fatalf("expected 1 keep-alive, got %d", server.keepAlives)
+	}
+}
+
 // Test connecting to a server that presents an SSH host certificate,
 // trusted via an @cert-authority known_hosts entry.
 func TestTunnelHostCert(t *testing.T) {
@@ -1020,3 +1051,444 @@ func TestTunnelIgnoreWrongCert(t *testing.T) {
 		t.Fatalf("exit code %d: %s", c, out)
 	}
 }
+
+// Test that a pinned host key fingerprint is accepted
+func TestTunnelPinned(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-pinned")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+}
+
+// Test that a host key not matching the pinned fingerprint is rejected
+func TestTunnelPinnedMismatch(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	c, out, err := cliCommand(env, "open", "test-pinned-wrong")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if !strings.Contains(out, "does not match pinned") {
+		t.Errorf("did not get expected output: %s", out)
+	}
+}
+
+func TestPauseResume(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "test"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+
+	c, out, err := cliCommand(env, "pause", "--drop", "test")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+
+	_, out, _ = cliCommand(env, "list")
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	if strings.Fields(lines[1])[0] != "paused" {
+		t.Errorf("test tunnel not paused in list output: %s", out)
+	}
+
+	if c, out, _ = cliCommand(env, "pause", "test"); c == 0 {
+		t.Errorf("pausing twice should fail: %s", out)
+	}
+
+	if c, out, _ = cliCommand(env, "resume", "test"); c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	_, out, _ = cliCommand(env, "list")
+	lines = strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	re := regexp.MustCompile(`^\d{2}m\d{2}s$`)
+	if !re.MatchString(strings.Fields(lines[1])[0]) {
+		t.Errorf("test tunnel not open in list output: %s", out)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	c, out, err := cliCommand(env, "check", "test", "test-pinned-wrong")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 {
+		t.Errorf("expected exit code 1, got %d: %s", c, out)
+	}
+	// Collect results by tunnel name, skipping log lines
+	results := make(map[string]string)
+	for _, l := range strings.Split(stripANSI(out), "\n") {
+		if f := strings.Fields(l); len(f) >= 2 && (f[0] == "ok" || f[0] == "failed") {
+			results[f[1]] = l
+		}
+	}
+	if !strings.HasPrefix(results["test"], "ok") {
+		t.Errorf("expected test to pass: %s", out)
+	}
+	if r := results["test-pinned-wrong"]; !strings.HasPrefix(r, "failed") ||
+		!strings.Contains(r, "host key") {
+		t.Errorf("expected test-pinned-wrong to fail with host key error: %s", out)
+	}
+}
+
+func TestExpectBanner(t *testing.T) {
+	env, err := makeDefaultEnv(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+
+	if c, out, err := cliCommand(env, "check", "test-banner"); err != nil || c != 0 {
+		t.Errorf("expected matching banner to pass (%v): %s", err, out)
+	}
+	c, out, err := cliCommand(env, "check", "test-banner-wrong")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 || !strings.Contains(out, "banner does not contain") {
+		t.Errorf("expected mismatching banner to fail: %s", out)
+	}
+}
+
+// Test that opening waits for the remote service to accept connections
+func TestWaitForRemote(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	const delay = time.Second
+	go func() {
+		time.Sleep(delay)
+		l, err := net.Listen("tcp", "localhost:49713")
+		if err != nil {
+			return
+		}
+		time.Sleep(5 * time.Second)
+		l.Close()
+	}()
+
+	start := time.Now()
+	c, out, err := cliCommand(env, "open", "test-wait-remote")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 0 {
+		t.Fatalf("exit code %d: %s", c, out)
+	}
+	if time.Since(start) < delay {
+		t.Errorf("tunnel opened before remote was ready")
+	}
+}
+
+// Test opening tunnels given as JSON lines, continuing past invalid ones
+func TestOpenJSONLines(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	in := `{"name": "json-a", "host": "127.0.0.1", "local": "localhost:49721", "remote": "localhost:49712"}
+{"name": "json-b", "host": "127.0.0.1", "local": "localhost:49722", "remote": "localhost:49712", "mode": "local"}
+{"name": "bad name"}
+`
+	c, out, err := cliCommandStdin(env, strings.NewReader(in), "open", "--json")
+	if err != nil {
+		t.Fatalf("failed to run CLI command: %v", err)
+	}
+	if c != 1 || !strings.Contains(out, "Could not open 1 of 3 tunnels") {
+		t.Errorf("exit code %d: %s", c, out)
+	}
+
+	_, out, _ = cliCommand(env, "list")
+	for _, n := range []string{"json-a", "json-b"} {
+		if !strings.Contains(out, n) {
+			t.Errorf("tunnel %v not running: %s", n, out)
+		}
+	}
+}
+
+// Test failing over to the next host if the first is unreachable
+func TestTunnelFailoverHost(t *testing.T) {
+	cfg := defaultConfig
+	cfg.boringConfig = t.TempDir() + "/config.toml"
+	conf := "[[tunnels]]\nname = \"failover\"\nhost = [\"127.0.0.1:1\", \"127.0.0.1\"]\n" +
+		"local = 49711\nremote = \"localhost:49712\"\n" +
+		// The unreachable host is not in known_hosts
+		"fingerprint = \"SHA256:J5ZSKbQ4iUGfm3AR0Ts5E8md2ppIr5vCvSDTk2xHm5g\"\n"
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "failover"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+
+	_, out, _ := cliCommand(env, "list")
+	lines := strings.Split(strings.TrimSpace(stripANSI(out)), "\n")
+	if f := strings.Fields(lines[len(lines)-1]); len(f) < 6 || f[5] != "127.0.0.1" {
+		t.Errorf("expected host in use to be listed: %s", out)
+	}
+}
+
+// Test that the server's version string is shown for open tunnels
+func TestListServerVersion(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "test"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+	_, out, _ := cliCommand(env, "list")
+	for _, line := range strings.Split(stripANSI(out), "\n") {
+		if f := strings.Fields(line); len(f) > 1 && f[1] == "test" {
+			if f[len(f)-1] != "Go" {
+				t.Errorf("expected server version in %q", line)
+			}
+			return
+		}
+	}
+	t.Errorf("tunnel not listed: %s", out)
+}
+
+// Test that tunnel and connection lifecycles are written to the audit log
+func TestAuditLog(t *testing.T) {
+	dir := t.TempDir()
+	cfg := defaultConfig
+	cfg.boringConfig = dir + "/config.toml"
+	auditPath := dir + "/audit.log"
+	conf := fmt.Sprintf("audit_log = %q\n[[tunnels]]\nname = \"test\"\nhost = \"127.0.0.1\"\n"+
+		"local = 49711\nremote = \"localhost:49712\"\n", auditPath)
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "test"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+	testTunnel(t, "localhost:49711", "localhost:49712")
+	if c, out, err := cliCommand(env, "close", "test"); err != nil || c != 0 {
+		t.Fatalf("could not close tunnel: %v, %s", err, out)
+	}
+
+	events := readAuditEvents(t, auditPath)
+	want := []string{"tunnel_open", "conn_open", "conn_close", "tunnel_close"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got events %v, want %v", events, want)
+	}
+}
+
+// readAuditEvents returns the events in the audit log at path, checking
+// that each belongs to the test tunnel and that connection events are
+// complete
+func readAuditEvents(t *testing.T, path string) (events []string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var e struct {
+			Event   string `json:"event"`
+			Tunnel  string `json:"tunnel"`
+			Peer    string `json:"peer"`
+			Target  string `json:"target"`
+			BytesIn uint64 `json:"bytes_in"`
+		}
+		if err := json.Unmarshal([]byte(line), &e); err != nil || e.Tunnel != "test" {
+			t.Fatalf("unexpected line %q: %v", line, err)
+		}
+		if e.Event == "conn_close" && (e.Peer == "" || e.Target != "localhost:49712" || e.BytesIn == 0) {
+			t.Errorf("incomplete connection event: %q", line)
+		}
+		events = append(events, e.Event)
+	}
+	return
+}
+
+// Test that tunnels to the same host share one SSH connection
+func TestTunnelShareConnection(t *testing.T) {
+	cfg := defaultConfig
+	cfg.boringConfig = t.TempDir() + "/config.toml"
+	conf := "[[tunnels]]\nname = \"a\"\nhost = \"127.0.0.1\"\nshare_connection = true\n" +
+		"local = 49731\nremote = \"localhost:49732\"\n" +
+		"[[tunnels]]\nname = \"b\"\nhost = \"127.0.0.1\"\nshare_connection = true\n" +
+		"local = 49733\nremote = \"localhost:49734\"\n"
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	before := server.connCount()
+	if c, out, err := cliCommand(env, "open", "a", "b"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnels: %v, %s", err, out)
+	}
+	testTunnel(t, "localhost:49731", "localhost:49732")
+	testTunnel(t, "localhost:49733", "localhost:49734")
+	if n := server.connCount() - before; n > 1 {
+		t.Errorf("expected tunnels to share a connection, got %d", n)
+	}
+
+	// Closing one tunnel keeps the connection for the other
+	if c, out, err := cliCommand(env, "close", "a"); err != nil || c != 0 {
+		t.Fatalf("could not close tunnel: %v, %s", err, out)
+	}
+	testTunnel(t, "localhost:49733", "localhost:49734")
+}
+
+// Test that the connections a tunnel forwards are listed
+func TestConnections(t *testing.T) {
+	env, cancel, err := makeDefaultEnvWithDaemon(t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "test"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+	// Keep the connection open while listing
+	conn := holdConnection(t, "localhost:49711", "localhost:49712")
+
+	c, out, err := cliCommand(env, "connections", "test", "--json")
+	if err != nil || c != 0 {
+		t.Fatalf("could not list connections: %v, %s", err, out)
+	}
+	var conns []struct {
+		Peer    string `json:"peer"`
+		Target  string `json:"target"`
+		BytesIn int    `json:"bytes_in"`
+	}
+	if err := json.Unmarshal([]byte(out), &conns); err != nil {
+		t.Fatalf("could not parse %q: %v", out, err)
+	}
+	if len(conns) != 1 || conns[0].Peer != conn.LocalAddr().String() ||
+		conns[0].Target != "localhost:49712" || conns[0].BytesIn != len(testMsg) {
+		t.Errorf("unexpected connections: %s", out)
+	}
+
+	if c, _, _ := cliCommand(env, "connections", "nonexistent"); c == 0 {
+		t.Error("expected failure for tunnel not running")
+	}
+}
+
+// holdConnection connects through the tunnel from from to to and sends
+// testMsg. Both ends are kept open until the test finishes.
+func holdConnection(t *testing.T, from, to string) net.Conn {
+	l, err := makeListener(to)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	t.Cleanup(func() { l.Close() })
+	conn, err := dial(from)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, err := conn.Write(testMsg); err != nil {
+		t.Fatal(err)
+	}
+	remote, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { remote.Close() })
+	if _, err := remote.Read(make([]byte, len(testMsg))); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// Test terminating TLS on the local listener with a self-signed certificate
+func TestTunnelTLS(t *testing.T) {
+	cfg := defaultConfig
+	cfg.boringConfig = t.TempDir() + "/config.toml"
+	conf := "[[tunnels]]\nname = \"tls\"\nhost = \"127.0.0.1\"\ntls = true\n" +
+		"local = 49735\nremote = \"localhost:49736\"\n"
+	if err := os.WriteFile(cfg.boringConfig, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env, cancel, err := makeEnvWithDaemon(cfg, t)
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer cancel()
+
+	if c, out, err := cliCommand(env, "open", "tls"); err != nil || c != 0 {
+		t.Fatalf("could not open tunnel: %v, %s", err, out)
+	}
+	l, err := makeListener("localhost:49736")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	defer l.Close()
+
+	// Plaintext is not accepted
+	plain, err := dial("localhost:49735")
+	if err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+	plain.Write(testMsg)
+	plain.SetReadDeadline(time.Now().Add(connTimeout))
+	if _, err := plain.Read(make([]byte, 1)); err == nil {
+		t.Error("expected plaintext connection to fail")
+	}
+	plain.Close()
+
+	conn, err := tls.Dial("tcp", "localhost:49735", &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.ConnectionState().PeerCertificates[0].VerifyHostname("localhost"); err != nil {
+		t.Errorf("certificate not valid for localhost: %v", err)
+	}
+	if err := testConnected(l, conn); err != nil {
+		t.Fatalf("%v", err.Error())
+	}
+}